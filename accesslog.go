@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accessLog writes a JSON line per request, for log collectors. The client
+// is the IP seen through TRUSTED_PROXIES.
+func accessLog() gin.HandlerFunc {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		logger.Info("request",
+			"method", c.Request.Method,
+			"uri", c.Request.URL.RequestURI(),
+			"status", c.Writer.Status(),
+			"bytes", max(c.Writer.Size(), 0),
+			"durationMs", float64(time.Since(start).Microseconds())/1000,
+			"client", c.ClientIP(),
+			"userAgent", c.Request.UserAgent(),
+		)
+	}
+}
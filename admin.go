@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin only lets requests through that carry the configured admin
+// token as a bearer token. The audit log names them by a fingerprint of the
+// token, so entries tell rotated tokens apart without recording them.
+func requireAdmin(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if config.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.adminToken)) != 1 {
+		abortProblem(c, http.StatusUnauthorized, "unauthorized", "A valid admin token is required.")
+		return
+	}
+	sum := sha256.Sum256([]byte(token))
+	c.Set(auditActorKey, "admin:"+hex.EncodeToString(sum[:4]))
+	c.Next()
+}
+
+// purgeCacheHandler deletes the image with the cache key in ?key= from the
+// render cache, or every image and text layout without it. Use it after
+// changing fonts, templates or palettes without WATCH_ASSETS.
+func purgeCacheHandler(c *gin.Context) {
+	key := c.Query("key")
+	if key != "" {
+		if decoded, err := hex.DecodeString(key); err != nil || len(decoded) != sha256.Size {
+			problem(c, http.StatusBadRequest, "invalid_cache_key", "Key should be a cache key of 64 hex digits.")
+			return
+		}
+	}
+
+	deleted := 0
+	var err error
+	switch {
+	case renderCache == nil:
+	case key != "":
+		var removed bool
+		removed, err = renderCache.remove(key)
+		deleted = ternary(removed, 1, 0)
+	default:
+		deleted, err = renderCache.purge()
+	}
+	if key == "" {
+		layouts.purge()
+	}
+	params := map[string]string{"deleted": strconv.Itoa(deleted)}
+	if key != "" {
+		params["key"] = key
+	}
+	audit(c, ternary(key == "", "cache.purge", "cache.delete"), params)
+
+	if err != nil {
+		log.Printf("Failed to purge the cache: %v", err)
+		problem(c, http.StatusInternalServerError, "purge_failed", fmt.Sprintf("Failed to purge the cache after deleting %d images.", deleted))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
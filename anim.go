@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+
+	colors "github.com/gitkumi/placeholder/color"
+)
+
+// maxAnimationFrames caps the frames of an animation, fps times duration.
+const maxAnimationFrames = 120
+
+// animations are the values of ?anim=. The loading presets mimic skeleton
+// loaders: pulse fades the image in and out, and shimmer sweeps a light
+// band across it.
+var animations = map[string]bool{
+	"spinner": true,
+	"cycle":   true,
+	"pulse":   true,
+	"shimmer": true,
+}
+
+// setAnimation reads ?anim=spinner and the ?fps and ?duration of the loop,
+// 12 frames a second for 2 seconds by default. Animations are encoded as
+// APNG, or as GIF with ?format=gif, and other formats get the still image.
+// Every frame counts against MAX_PIXELS.
+func (i *Image) setAnimation(anim, fps, duration string) error {
+	i.anim = ""
+	// JSON describes the animation without rendering it.
+	if !animations[anim] || !slices.Contains([]string{"png", "apng", "gif", "json"}, i.format) {
+		return nil
+	}
+	i.fps = 12
+	if n, err := strconv.Atoi(fps); err == nil {
+		i.fps = clamp(n, 1, 30)
+	}
+	seconds := 2.0
+	if n, err := strconv.ParseFloat(duration, 64); err == nil && !math.IsNaN(n) {
+		seconds = math.Max(0.1, math.Min(n, 10))
+	}
+	i.frameCount = clamp(int(math.Round(seconds*float64(i.fps))), 1, maxAnimationFrames)
+	if err := checkPixels(i.width, i.height*i.frameCount); err != nil {
+		return err
+	}
+	i.anim = anim
+	if anim == "cycle" {
+		i.bg = cycleHue(i.bg, 0)
+		i.cycleFrom = i.bg
+	}
+	if i.format == "png" {
+		i.format = "apng"
+	}
+	return nil
+}
+
+// frames returns the number of frames of the image.
+func (i *Image) frames() int {
+	return ternary(i.anim != "", i.frameCount, 1)
+}
+
+// renderFrame renders frame n into i.data. Frame 0 is the image apply has
+// rendered already.
+func (i *Image) renderFrame(ctx context.Context, n int) error {
+	if n > 0 {
+		if i.anim == "cycle" {
+			i.bg = cycleHue(i.cycleFrom, float64(n)/float64(i.frames()))
+		}
+		i.release()
+		if err := i.apply(ctx); err != nil {
+			return err
+		}
+	}
+	phase := float64(n) / float64(i.frames())
+	switch i.anim {
+	case "spinner":
+		i.drawSpinner(i.data, phase)
+	case "pulse":
+		// Fades to half opacity over white and back, like a CSS pulse.
+		blendColumns(i.data, func(int) float64 { return 0.25 * (1 - math.Cos(2*math.Pi*phase)) })
+	case "shimmer":
+		// The band starts and ends off the image, so the loop is seamless.
+		band := float64(i.width) / 4
+		center := -band + phase*(float64(i.width)+2*band)
+		blendColumns(i.data, func(x int) float64 {
+			distance := (float64(x) - center) / (band / 2)
+			return 0.4 * math.Exp(-distance*distance)
+		})
+	}
+	return nil
+}
+
+// blendColumns lightens every column x of img toward white by alpha(x),
+// from 0 to 1.
+func blendColumns(img *image.RGBA, alpha func(x int) float64) {
+	bounds := img.Bounds()
+	weights := make([]uint32, bounds.Dx())
+	for x := range weights {
+		weights[x] = uint32(math.Round(255 * math.Max(0, math.Min(alpha(bounds.Min.X+x), 1))))
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for x, weight := range weights {
+			if weight == 0 {
+				continue
+			}
+			pixel := row[4*x : 4*x+4 : 4*x+4]
+			// Premultiplied channels are lightened up to their alpha.
+			for c := 0; c < 3; c++ {
+				pixel[c] = uint8((uint32(pixel[c])*(255-weight) + uint32(pixel[3])*weight) / 255)
+			}
+		}
+	}
+}
+
+// drawSpinner draws a ring of twelve dots in the foreground color, with a
+// fading trail behind the one at phase, from 0 to 1 around the ring.
+func (i *Image) drawSpinner(dst *image.RGBA, phase float64) {
+	const dots = 12
+	size := float64(min(i.width, i.height))
+	cx, cy := float64(i.width)/2, float64(i.height)/2
+	radius, dotRadius := size/8, size/48
+	for k := 0; k < dots; k++ {
+		angle := 2*math.Pi*float64(k)/dots - math.Pi/2
+		age := math.Mod(phase-float64(k)/dots+1, 1)
+		alpha := uint8(255 * (1 - 0.85*age) * float64(i.fg.A) / 255)
+		fillCircle(dst, float32(cx+radius*math.Cos(angle)), float32(cy+radius*math.Sin(angle)), float32(dotRadius),
+			color.NRGBA{i.fg.R, i.fg.G, i.fg.B, alpha})
+	}
+}
+
+// cycleHue turns the hue of c by turn, from 0 to 1 around the color wheel.
+// Grays have no hue to turn, so they cycle from red at half saturation.
+func cycleHue(c color.RGBA, turn float64) color.RGBA {
+	hue, saturation, lightness := toHSL(c)
+	if saturation == 0 {
+		saturation = 0.5
+	}
+	cycled := colors.HSL(math.Mod(hue+360*turn, 360), saturation, lightness)
+	cycled.A = c.A
+	return cycled
+}
+
+// toHSL returns the hue in degrees, and the saturation and lightness from
+// 0 to 1, of an opaque color.
+func toHSL(c color.RGBA) (float64, float64, float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	high, low := max(r, g, b), min(r, g, b)
+	lightness := (high + low) / 2
+	if high == low {
+		return 0, 0, lightness
+	}
+	chroma := high - low
+	saturation := chroma / (1 - math.Abs(2*lightness-1))
+	var hue float64
+	switch high {
+	case r:
+		hue = math.Mod((g-b)/chroma+6, 6)
+	case g:
+		hue = (b-r)/chroma + 2
+	default:
+		hue = (r-g)/chroma + 4
+	}
+	return hue * 60, saturation, lightness
+}
+
+// encodeGIF encodes every frame as a looping GIF, each reduced to a 256
+// color palette.
+func (i *Image) encodeGIF(ctx context.Context, w io.Writer) error {
+	animation := &gif.GIF{}
+	for n := 0; n < i.frames(); n++ {
+		if err := i.renderFrame(ctx, n); err != nil {
+			return err
+		}
+		animation.Image = append(animation.Image, quantize(i.data, 256))
+		animation.Delay = append(animation.Delay, int(math.Round(100/float64(i.fps))))
+	}
+	return gif.EncodeAll(w, animation)
+}
+
+// encodeAPNG encodes every frame as a looping APNG. Frames are encoded one
+// at a time as PNGs, and their image data is moved into frame chunks.
+func (i *Image) encodeAPNG(ctx context.Context, w io.Writer) error {
+	encoder := i.encoder()
+	frames := i.frames()
+	var ihdr []byte
+	sequence := uint32(0)
+	for n := 0; n < frames; n++ {
+		if err := i.renderFrame(ctx, n); err != nil {
+			return err
+		}
+		var frame bytes.Buffer
+		if err := encoder.Encode(&frame, i.data); err != nil {
+			return err
+		}
+		header, data, err := pngImageData(frame.Bytes())
+		if err != nil {
+			return err
+		}
+
+		var out []byte
+		if n == 0 {
+			ihdr = header
+			out = append(append(frame.Bytes()[:8:8], ihdr...), i.pngChunks()...)
+			actl := binary.BigEndian.AppendUint32(nil, uint32(frames))
+			out = append(out, pngChunk("acTL", binary.BigEndian.AppendUint32(actl, 0))...)
+		} else if !bytes.Equal(header, ihdr) {
+			// Frames share the header of the first, with its color type.
+			return errors.New("APNG frames were encoded with different headers")
+		}
+
+		fctl := binary.BigEndian.AppendUint32(nil, sequence)
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(i.width))
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(i.height))
+		fctl = binary.BigEndian.AppendUint64(fctl, 0)
+		fctl = binary.BigEndian.AppendUint16(fctl, 1)
+		fctl = binary.BigEndian.AppendUint16(fctl, uint16(i.fps))
+		// Every frame replaces the previous one.
+		fctl = append(fctl, 0, 0)
+		out = append(out, pngChunk("fcTL", fctl)...)
+		sequence++
+
+		for _, chunk := range data {
+			if n == 0 {
+				out = append(out, pngChunk("IDAT", chunk)...)
+				continue
+			}
+			fdat := binary.BigEndian.AppendUint32(nil, sequence)
+			out = append(out, pngChunk("fdAT", append(fdat, chunk...))...)
+			sequence++
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(pngChunk("IEND", nil))
+	return err
+}
+
+// pngImageData splits an encoded PNG into its IHDR chunk and the data of its
+// IDAT chunks.
+func pngImageData(encoded []byte) ([]byte, [][]byte, error) {
+	if len(encoded) < ihdrEnd || !bytes.Equal(encoded[12:16], []byte("IHDR")) {
+		return nil, nil, errors.New("malformed PNG")
+	}
+	var data [][]byte
+	for rest := encoded[ihdrEnd:]; len(rest) >= 12; {
+		length := int(binary.BigEndian.Uint32(rest))
+		if len(rest) < 12+length {
+			return nil, nil, errors.New("malformed PNG")
+		}
+		if string(rest[4:8]) == "IDAT" {
+			data = append(data, rest[8:8+length])
+		}
+		rest = rest[12+length:]
+	}
+	return encoded[8:ihdrEnd], data, nil
+}
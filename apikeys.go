@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKey holds the limits of a key from API_KEYS_FILE or API_KEYS. Zero
+// means no quota, or MAX_SIZE for the size.
+type APIKey struct {
+	MaxSize int `json:"maxSize"`
+	Quota   int `json:"quota"`
+}
+
+var apiKeys = loadAPIKeys(config.apiKeysFile, config.apiKeys)
+
+// loadAPIKeys reads keys as JSON, an object of keys to limits such as
+// {"internal": {"maxSize": 3000}, "partner": {"quota": 10000}}, and from
+// entries like "partner:1200:10000", which win on clashes.
+func loadAPIKeys(file string, entries []string) map[string]*APIKey {
+	loaded := map[string]*APIKey{}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &loaded)
+		}
+		if err != nil {
+			log.Printf("Failed to load API keys: %v", err)
+		}
+	}
+
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		key := &APIKey{}
+		if len(parts) > 1 {
+			key.MaxSize, _ = strconv.Atoi(parts[1])
+		}
+		if len(parts) > 2 {
+			key.Quota, _ = strconv.Atoi(parts[2])
+		}
+		loaded[parts[0]] = key
+	}
+	if len(loaded) > 0 {
+		auditServer("apikeys.load", map[string]string{"file": file, "count": strconv.Itoa(len(loaded))})
+	}
+	return loaded
+}
+
+type quotaUsage struct {
+	start time.Time
+	used  int
+}
+
+// Quotas counts the requests of each key in fixed windows, which start
+// with the first request of a key.
+type Quotas struct {
+	mu     sync.Mutex
+	window time.Duration
+	usage  map[string]*quotaUsage
+}
+
+var quotas = &Quotas{window: config.apiQuotaWindow, usage: map[string]*quotaUsage{}}
+
+// take counts a request against the quota of key. It returns the requests
+// left in the window and when the window ends, and false when none were
+// left.
+func (q *Quotas) take(key string, quota int) (int, time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	usage, ok := q.usage[key]
+	if !ok || now.Sub(usage.start) >= q.window {
+		usage = &quotaUsage{start: now}
+		q.usage[key] = usage
+	}
+	reset := usage.start.Add(q.window).Sub(now)
+	if usage.used >= quota {
+		return 0, reset, false
+	}
+	usage.used++
+	return quota - usage.used, reset, true
+}
+
+// requestAPIKey returns the key of a request, from a bearer token, the
+// X-API-Key header or ?key=.
+func requestAPIKey(c *gin.Context) string {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	return c.Query("key")
+}
+
+// authenticate checks the API key of a render request and counts it
+// against the key's quota. Requests without a key are let through with
+// PUBLIC_MAX_SIZE unless API_KEY_REQUIRED is set. Without configured keys,
+// any key is ignored, as collections use keys of their own.
+func authenticate(c *gin.Context) {
+	token := requestAPIKey(c)
+	key, ok := apiKeys[token]
+	if !ok {
+		switch {
+		case token != "" && len(apiKeys) > 0:
+			abortProblem(c, http.StatusUnauthorized, "invalid_api_key", "Invalid API key.")
+		case config.apiKeyRequired:
+			abortProblem(c, http.StatusUnauthorized, "api_key_required", "An API key is required.")
+		default:
+			c.Set(maxSizeKey, ternary(config.publicMaxSize > 0, min(config.publicMaxSize, config.maxSize), config.maxSize))
+			c.Next()
+		}
+		return
+	}
+
+	if key.Quota > 0 {
+		remaining, reset, ok := quotas.take(token, key.Quota)
+		c.Header("X-Quota-Limit", strconv.Itoa(key.Quota))
+		c.Header("X-Quota-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+			abortProblem(c, http.StatusTooManyRequests, "quota_exceeded", "Quota exceeded.")
+			return
+		}
+	}
+	c.Set(maxSizeKey, ternary(key.MaxSize > 0, min(key.MaxSize, config.maxSize), config.maxSize))
+	c.Next()
+}
+
+// maxSizeKey is the context key of the largest width or height a request
+// may render.
+const maxSizeKey = "maxSize"
+
+// maxSizeFor returns the size limit that authenticate set for a request,
+// or MAX_SIZE for routes without it, capped by the limit of the tenant.
+func maxSizeFor(c *gin.Context) int {
+	maxSize := config.maxSize
+	if limit, ok := c.Get(maxSizeKey); ok {
+		maxSize = limit.(int)
+	}
+	if tenant := tenantFor(c); tenant != nil && tenant.MaxSize > 0 {
+		maxSize = min(maxSize, tenant.MaxSize)
+	}
+	return maxSize
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditEntry struct {
+	Time   time.Time         `json:"time"`
+	Actor  string            `json:"actor"`
+	IP     string            `json:"ip"`
+	Action string            `json:"action"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// maxAuditEntries is how many of the latest entries are kept in memory for
+// /admin/audit. The file keeps every entry.
+const maxAuditEntries = 10000
+
+// AuditLog is an append-only record of admin operations and of the changes
+// the server makes to its own configuration. Entries are written to a JSON
+// lines file when one is configured, and the latest are kept in memory.
+type AuditLog struct {
+	mu      sync.Mutex
+	path    string
+	entries []AuditEntry
+}
+
+var auditLog = newAuditLog(config.auditLogFile)
+
+// newAuditLog keeps the latest entries of the file at path in memory.
+func newAuditLog(path string) *AuditLog {
+	a := &AuditLog{path: path}
+	if path != "" {
+		if err := a.load(); err != nil {
+			log.Printf("Failed to read the audit log: %v", err)
+		}
+	}
+	return a
+}
+
+// keep adds entries to memory and drops the oldest ones beyond
+// maxAuditEntries, in batches so adding stays cheap. Callers must hold the
+// lock, or own the log.
+func (a *AuditLog) keep(entries ...AuditEntry) {
+	a.entries = append(a.entries, entries...)
+	if len(a.entries) > 2*maxAuditEntries {
+		a.entries = slices.Clone(a.entries[len(a.entries)-maxAuditEntries:])
+	}
+}
+
+func (a *AuditLog) record(entry AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.keep(entry)
+	if a.path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// query returns the latest entries for action since a time, newest first,
+// from the ones kept in memory.
+func (a *AuditLog) query(action string, since time.Time, limit int) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	matches := []AuditEntry{}
+	for i := len(a.entries) - 1; i >= 0 && len(matches) < limit; i-- {
+		entry := a.entries[i]
+		if (action == "" || entry.Action == action) && !entry.Time.Before(since) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+// load keeps the latest entries of the file in memory. The file is read a
+// line at a time, so older entries don't stay in memory, and lines of any
+// length are read. Lines that aren't entries are skipped.
+func (a *AuditLog) load() error {
+	file, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		var entry AuditEntry
+		if len(line) > 0 && json.Unmarshal(line, &entry) == nil {
+			a.keep(entry)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(a.entries) > maxAuditEntries {
+		a.entries = slices.Clone(a.entries[len(a.entries)-maxAuditEntries:])
+	}
+	return nil
+}
+
+// auditActorKey is the context key of the actor requireAdmin
+// authenticated.
+const auditActorKey = "auditActor"
+
+// audit records an admin action performed in the current request, by the
+// actor of its admin token.
+func audit(c *gin.Context, action string, params map[string]string) {
+	recordAudit(c.GetString(auditActorKey), c.ClientIP(), action, params)
+}
+
+// auditServer records a change the server made on its own, such as loading
+// API keys or reloading assets.
+func auditServer(action string, params map[string]string) {
+	recordAudit("server", "", action, params)
+}
+
+func recordAudit(actor, ip, action string, params map[string]string) {
+	err := auditLog.record(AuditEntry{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		IP:     ip,
+		Action: action,
+		Params: params,
+	})
+	if err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+func auditHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			problem(c, http.StatusBadRequest, "invalid_since", "Since should be an RFC 3339 timestamp.")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, auditLog.query(c.Query("action"), since, limit))
+}
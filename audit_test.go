@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditActorFromToken(t *testing.T) {
+	r := newSigningRouter(t)
+	defer func(token string, log *AuditLog) { config.adminToken, auditLog = token, log }(config.adminToken, auditLog)
+	config.adminToken = "test-admin-token"
+	auditLog = newAuditLog("")
+
+	header := http.Header{"Authorization": {"Bearer test-admin-token"}, "X-Actor": {"someone-else"}}
+	if w := request(r, http.MethodGet, "/admin/sign?url=/300x200", "", header); w.Code != http.StatusOK {
+		t.Fatalf("signing got %d %s", w.Code, w.Body)
+	}
+	w := request(r, http.MethodGet, "/admin/audit?action=url.sign", "", header)
+	var entries []AuditEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !strings.HasPrefix(entries[0].Actor, "admin:") {
+		t.Errorf("audit entries are %+v, want one signing by the token", entries)
+	}
+}
+
+func TestAuditLogBounded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a := newAuditLog(path)
+	for n := 0; n < 2*maxAuditEntries+1; n++ {
+		a.record(AuditEntry{Action: "test"})
+	}
+	if len(a.entries) > 2*maxAuditEntries {
+		t.Errorf("kept %d entries in memory", len(a.entries))
+	}
+	if n := len(newAuditLog(path).query("test", time.Time{}, 3*maxAuditEntries)); n != maxAuditEntries {
+		t.Errorf("reloading kept %d entries, want %d", n, maxAuditEntries)
+	}
+}
+
+func TestAuditLogLongLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a := newAuditLog(path)
+	a.record(AuditEntry{Action: "url.sign", Params: map[string]string{"url": "/300x200?text=" + strings.Repeat("x", 100<<10)}})
+	a.record(AuditEntry{Action: "cache.purge"})
+	if n := len(newAuditLog(path).query("", time.Time{}, 10)); n != 2 {
+		t.Errorf("reloading kept %d entries, want 2", n)
+	}
+}
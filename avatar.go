@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+type Avatar struct {
+	size     int
+	initials string
+	bg       color.RGBA
+	fg       color.RGBA
+	circle   bool
+	// fontScale is the font size as a fraction of the size, 0.42 when zero.
+	fontScale float64
+}
+
+func avatarHandler(c *gin.Context) {
+	img := &Image{maxSize: maxSizeFor(c)}
+	if err := img.setSize(c.Param("size")); err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	name := c.Query("name")
+	bg, fg := seedColors(name)
+	avatar := &Avatar{
+		// Avatars are always square.
+		size:     ternary(img.width < img.height, img.width, img.height),
+		initials: initials(name),
+		bg:       parseColor(c.Query("bg"), bg),
+		fg:       parseColor(c.Query("fg"), fg),
+	}
+	avatar.circle, _ = strconv.ParseBool(c.Query("circle"))
+
+	serveRender(c, avatar.cacheKey(), "image/png", avatar.render)
+}
+
+// initials returns the first letter of the first and last word of name.
+func initials(name string) string {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return "?"
+	}
+
+	first := []rune(words[0])[0]
+	if len(words) == 1 {
+		return string(unicode.ToUpper(first))
+	}
+	last := []rune(words[len(words)-1])[0]
+	return string([]rune{unicode.ToUpper(first), unicode.ToUpper(last)})
+}
+
+func (a *Avatar) cacheKey() string {
+	spec := fmt.Sprintf("avatar|%d|%q|%v|%v|%v", a.size, a.initials, a.bg, a.fg, a.circle)
+	return renderKey(spec)
+}
+
+func (a *Avatar) render(ctx context.Context, w io.Writer) error {
+	img, err := a.draw()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return pngEncoder.Encode(&contextWriter{ctx, w}, img)
+}
+
+func (a *Avatar) draw() (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, a.size, a.size))
+	var mask image.Image
+	if a.circle {
+		mask = circleMask(a.size)
+	}
+	draw.DrawMask(img, img.Bounds(), &image.Uniform{a.bg}, image.Point{}, mask, image.Point{}, draw.Src)
+
+	if regularFontErr != nil {
+		return nil, regularFontErr
+	}
+	drawer := &font.Drawer{
+		Dst: img,
+		Src: &image.Uniform{a.fg},
+		Face: newFace(regularFont, &truetype.Options{
+			Size:    float64(a.size) * ternary(a.fontScale > 0, a.fontScale, 0.42),
+			DPI:     72,
+			Hinting: font.HintingFull,
+		}),
+	}
+
+	// Center the ink of the letters rather than the line box, so initials
+	// without descenders don't sit high.
+	bounds, advance := drawer.BoundString(a.initials)
+	drawer.Dot = fixed.Point26_6{
+		X: (fixed.I(a.size) - advance) / 2,
+		Y: (fixed.I(a.size)-(bounds.Max.Y-bounds.Min.Y))/2 - bounds.Min.Y,
+	}
+	drawer.DrawString(a.initials)
+	return img, nil
+}
+
+// circleMask is opaque inside a circle filling size, with an anti-aliased
+// edge.
+func circleMask(size int) *image.Alpha {
+	mask := image.NewAlpha(image.Rect(0, 0, size, size))
+	radius := float64(size) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			distance := math.Hypot(float64(x)+0.5-radius, float64(y)+0.5-radius)
+			coverage := math.Max(0, math.Min(1, radius-distance+0.5))
+			mask.Pix[y*mask.Stride+x] = uint8(coverage * 255)
+		}
+	}
+	return mask
+}
@@ -0,0 +1,21 @@
+//go:build avif
+
+package main
+
+import (
+	"image"
+	"io"
+
+	"github.com/gen2brain/avif"
+)
+
+// avifSupported reports whether the binary was built with -tags avif.
+const avifSupported = true
+
+func encodeAVIF(w io.Writer, img image.Image) error {
+	return avif.Encode(w, img, avif.Options{
+		Quality:      config.avifQuality,
+		QualityAlpha: config.avifQuality,
+		Speed:        config.avifSpeed,
+	})
+}
@@ -0,0 +1,18 @@
+//go:build !avif
+
+package main
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// avifSupported reports whether the binary was built with -tags avif. The
+// encoder embeds a WebAssembly build of libavif, so it is left out by
+// default.
+const avifSupported = false
+
+func encodeAVIF(w io.Writer, img image.Image) error {
+	return errors.New("AVIF support is not compiled in, build with -tags avif")
+}
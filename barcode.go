@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/codabar"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/code39"
+	"github.com/boombuler/barcode/code93"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/twooffive"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// barcodeQuietZone is the number of blank modules on each side of the bars.
+const barcodeQuietZone = 10
+
+var barcodeEncoders = map[string]func(data string) (barcode.Barcode, error){
+	"code128": func(data string) (barcode.Barcode, error) { return code128.Encode(data) },
+	"code39":  func(data string) (barcode.Barcode, error) { return code39.Encode(data, false, true) },
+	"code93":  func(data string) (barcode.Barcode, error) { return code93.Encode(data, true, true) },
+	"codabar": codabar.Encode,
+	"itf":     func(data string) (barcode.Barcode, error) { return twooffive.Encode(data, true) },
+	"ean13": func(data string) (barcode.Barcode, error) {
+		if len(data) != 12 && len(data) != 13 {
+			return nil, badRequest("invalid_barcode_data", "EAN-13 needs 12 or 13 digits.")
+		}
+		return ean.Encode(data)
+	},
+	"ean8": func(data string) (barcode.Barcode, error) {
+		if len(data) != 7 && len(data) != 8 {
+			return nil, badRequest("invalid_barcode_data", "EAN-8 needs 7 or 8 digits.")
+		}
+		return ean.Encode(data)
+	},
+}
+
+type Barcode struct {
+	width  int
+	height int
+	kind   string
+	data   string
+	label  bool
+	bg     color.RGBA
+	fg     color.RGBA
+	code   barcode.Barcode
+}
+
+func barcodeHandler(c *gin.Context) {
+	img := &Image{maxSize: maxSizeFor(c)}
+	if err := img.setSize(c.Param("size")); err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	kind := c.DefaultQuery("type", "code128")
+	encode, ok := barcodeEncoders[kind]
+	if !ok {
+		problem(c, http.StatusBadRequest, "unknown_barcode_type", fmt.Sprintf("Unknown barcode type %q.", kind))
+		return
+	}
+
+	// Encoding is cheap and validates the data, so it happens up front to
+	// report bad data as a 400.
+	code, err := encode(c.Query("data"))
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_barcode_data")
+		return
+	}
+	// Bars narrower than a pixel would be cut off at the edges, which no
+	// scanner reads.
+	if minWidth := code.Bounds().Dx() + 2*barcodeQuietZone; img.width < minWidth {
+		problem(c, http.StatusBadRequest, "size_out_of_range", fmt.Sprintf("The barcode needs a width of at least %d pixels.", minWidth))
+		return
+	}
+
+	bc := &Barcode{
+		width:  img.width,
+		height: img.height,
+		kind:   kind,
+		data:   c.Query("data"),
+		label:  true,
+		bg:     parseColor(c.Query("bg"), color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}),
+		fg:     parseColor(c.Query("fg"), color.RGBA{0x00, 0x00, 0x00, 0xFF}),
+		code:   code,
+	}
+	if label, err := strconv.ParseBool(c.Query("label")); err == nil {
+		bc.label = label
+	}
+
+	serveRender(c, bc.cacheKey(), "image/png", bc.render)
+}
+
+func (b *Barcode) cacheKey() string {
+	spec := fmt.Sprintf("barcode|%d|%d|%s|%q|%v|%v|%v", b.width, b.height, b.kind, b.data, b.label, b.bg, b.fg)
+	return renderKey(spec)
+}
+
+func (b *Barcode) render(ctx context.Context, w io.Writer) error {
+	img := image.NewRGBA(image.Rect(0, 0, b.width, b.height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{b.bg}, image.Point{}, draw.Src)
+
+	modules := b.code.Bounds().Dx()
+	size := clamp(b.width/(modules+2*barcodeQuietZone), 1, b.width)
+	left := (b.width - modules*size) / 2
+	margin := b.height / 10
+	barsBottom := b.height - margin
+	if b.label {
+		barsBottom -= b.height / 5
+	}
+
+	for x := 0; x < modules; x++ {
+		if r, _, _, _ := b.code.At(x, 0).RGBA(); r != 0 {
+			continue
+		}
+		rect := image.Rect(left+x*size, margin, left+(x+1)*size, barsBottom)
+		draw.Draw(img, rect, &image.Uniform{b.fg}, image.Point{}, draw.Src)
+	}
+
+	if b.label && regularFontErr == nil {
+		drawer := &font.Drawer{
+			Dst: img,
+			Src: &image.Uniform{b.fg},
+			Face: newFace(regularFont, &truetype.Options{
+				Size:    float64(b.height) / 6,
+				DPI:     72,
+				Hinting: font.HintingFull,
+			}),
+		}
+		drawer.Dot = fixed.Point26_6{
+			X: (fixed.I(b.width) - drawer.MeasureString(b.data)) / 2,
+			Y: fixed.I(b.height - margin),
+		}
+		drawer.DrawString(b.data)
+	}
+
+	return pngEncoder.Encode(&contextWriter{ctx, w}, img)
+}
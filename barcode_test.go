@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBarcodeMinimumWidth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerRoutes(r, nil, nil)
+
+	// 12 characters of code128 are 167 modules, plus the quiet zones.
+	w := request(r, http.MethodGet, "/barcode/150?data=ABCDEFGHIJKL", "", nil)
+	var body struct{ Code, Detail string }
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusBadRequest || body.Code != "size_out_of_range" {
+		t.Errorf("narrow barcode got %d %q, want 400 size_out_of_range", w.Code, body.Code)
+	}
+	if body.Detail != "The barcode needs a width of at least 187 pixels." {
+		t.Errorf("detail is %q, want the minimum width", body.Detail)
+	}
+
+	if w := request(r, http.MethodGet, "/barcode/187x100?data=ABCDEFGHIJKL", "", nil); w.Code != http.StatusOK {
+		t.Errorf("barcode at its minimum width got %d, want 200", w.Code)
+	}
+}
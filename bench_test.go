@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// benchmarkSizes run from the smallest default size up to MAX_SIZE.
+var benchmarkSizes = []string{"150x150", "600x400", "1920x1080", "3000x3000"}
+
+func benchmarkFormats() []string {
+	formats := []string{"png", "blurhash", "lqip", "pdf"}
+	if avifSupported {
+		formats = append(formats, "avif")
+	}
+	return formats
+}
+
+// BenchmarkRender measures apply and generate, the work serveRender does
+// on a cache miss.
+func BenchmarkRender(b *testing.B) {
+	for _, format := range benchmarkFormats() {
+		for _, size := range benchmarkSizes {
+			b.Run(format+"/"+size, func(b *testing.B) {
+				c, _ := gin.CreateTestContext(httptest.NewRecorder())
+				c.Request = httptest.NewRequest(http.MethodGet, "/"+size+"?format="+format, nil)
+				img, err := parseImage(c, size)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for n := 0; n < b.N; n++ {
+					if err := img.apply(context.Background()); err != nil {
+						b.Fatal(err)
+					}
+					if err := img.generate(context.Background(), io.Discard); err != nil {
+						b.Fatal(err)
+					}
+					img.release()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkImageHandler measures a whole uncached request, including query
+// parsing and the layout cache.
+func BenchmarkImageHandler(b *testing.B) {
+	r := gin.New()
+	r.GET("/:size", imageHandler)
+	for _, size := range benchmarkSizes {
+		b.Run(size, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+size+"?text=Hello%20world&seed=bench", nil))
+				if w.Code != http.StatusOK {
+					b.Fatalf("responded %d: %s", w.Code, w.Body)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLayout measures wrapping and placing text without the cache.
+func BenchmarkLayout(b *testing.B) {
+	drawer := newTestDrawer(b, 40)
+	key := layoutKey{text: "The quick brown fox jumps over the lazy dog", width: 600, height: 400, fontSize: 40}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		layoutText(key, drawer)
+	}
+}
+
+// BenchmarkFill measures filling the background of a MAX_SIZE canvas.
+func BenchmarkFill(b *testing.B) {
+	img := image.NewRGBA(image.Rect(0, 0, 3000, 3000))
+	bg := &image.Uniform{color.RGBA{0xD4, 0xD4, 0xD4, 0xFF}}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		fillBands(img, func(band *image.RGBA) {
+			draw.Draw(band, band.Bounds(), bg, image.Point{}, draw.Src)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/bidi"
+)
+
+func (i *Image) setDirection(dir string) {
+	switch dir {
+	case "ltr", "rtl":
+		i.direction = dir
+	default:
+		i.direction = "auto"
+	}
+}
+
+// visualOrder reorders a wrapped line from logical to display order. The
+// paragraph direction comes from dir, or the first strong character when
+// dir is auto.
+func visualOrder(line, dir string) string {
+	rtl := dir == "rtl" || (dir == "auto" && firstStrongIsRTL(line))
+	if !rtl && !containsRTL(line) {
+		return line
+	}
+
+	// Marks pin the paragraph level, which the bidi package otherwise
+	// detects on its own.
+	var paragraph bidi.Paragraph
+	if rtl {
+		paragraph.SetString("\u200F"+line, bidi.DefaultDirection(bidi.RightToLeft))
+	} else {
+		paragraph.SetString("\u200E" + line)
+	}
+	ordering, err := paragraph.Order()
+	if err != nil {
+		return line
+	}
+
+	// Runs alternate between the paragraph level and one level deeper, so
+	// reversing right to left runs, and the run order in right to left
+	// paragraphs, is enough to get the display order.
+	runs := make([]string, ordering.NumRuns())
+	for n := range runs {
+		run := ordering.Run(n)
+		runs[n] = run.String()
+		if run.Direction() == bidi.RightToLeft {
+			runs[n] = bidi.ReverseString(runs[n])
+		}
+	}
+	if rtl {
+		for a, b := 0, len(runs)-1; a < b; a, b = a+1, b-1 {
+			runs[a], runs[b] = runs[b], runs[a]
+		}
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '\u200E' || r == '\u200F' {
+			return -1
+		}
+		return r
+	}, strings.Join(runs, ""))
+}
+
+func firstStrongIsRTL(text string) bool {
+	for _, r := range text {
+		properties, _ := bidi.LookupRune(r)
+		switch properties.Class() {
+		case bidi.L:
+			return false
+		case bidi.R, bidi.AL:
+			return true
+		}
+	}
+	return false
+}
+
+func containsRTL(text string) bool {
+	for _, r := range text {
+		properties, _ := bidi.LookupRune(r)
+		if class := properties.Class(); class == bidi.R || class == bidi.AL {
+			return true
+		}
+	}
+	return false
+}
+
+// arabicForms maps Arabic letters to their isolated presentation form. Dual
+// joining letters are followed by final, initial and medial forms, right
+// joining letters only by a final form.
+var arabicForms = map[rune]struct {
+	isolated rune
+	dual     bool
+}{
+	'ء': {0xFE80, false}, 'آ': {0xFE81, false}, 'أ': {0xFE83, false},
+	'ؤ': {0xFE85, false}, 'إ': {0xFE87, false}, 'ئ': {0xFE89, true},
+	'ا': {0xFE8D, false}, 'ب': {0xFE8F, true}, 'ة': {0xFE93, false},
+	'ت': {0xFE95, true}, 'ث': {0xFE99, true}, 'ج': {0xFE9D, true},
+	'ح': {0xFEA1, true}, 'خ': {0xFEA5, true}, 'د': {0xFEA9, false},
+	'ذ': {0xFEAB, false}, 'ر': {0xFEAD, false}, 'ز': {0xFEAF, false},
+	'س': {0xFEB1, true}, 'ش': {0xFEB5, true}, 'ص': {0xFEB9, true},
+	'ض': {0xFEBD, true}, 'ط': {0xFEC1, true}, 'ظ': {0xFEC5, true},
+	'ع': {0xFEC9, true}, 'غ': {0xFECD, true}, 'ف': {0xFED1, true},
+	'ق': {0xFED5, true}, 'ك': {0xFED9, true}, 'ل': {0xFEDD, true},
+	'م': {0xFEE1, true}, 'ن': {0xFEE5, true}, 'ه': {0xFEE9, true},
+	'و': {0xFEED, false}, 'ى': {0xFEEF, false}, 'ي': {0xFEF1, true},
+}
+
+// lamAlef maps the alef following a lam to the isolated ligature.
+var lamAlef = map[rune]rune{
+	'آ': 0xFEF5, 'أ': 0xFEF7, 'إ': 0xFEF9, 'ا': 0xFEFB,
+}
+
+// shapeArabic replaces Arabic letters with the contextual form that joins
+// them to their neighbours, in logical order.
+func shapeArabic(text string) string {
+	runes := []rune(text)
+	joinsNext := func(i int) bool {
+		form, ok := arabicForms[runes[i]]
+		return (ok && form.dual) || runes[i] == 'ـ'
+	}
+	joinable := func(i int) bool {
+		_, ok := arabicForms[runes[i]]
+		return (ok && runes[i] != 'ء') || runes[i] == 'ـ'
+	}
+
+	var shaped strings.Builder
+	for i := 0; i < len(runes); i++ {
+		form, ok := arabicForms[runes[i]]
+		if !ok {
+			shaped.WriteRune(runes[i])
+			continue
+		}
+
+		prev := i > 0 && joinsNext(i-1)
+		if runes[i] == 'ل' && i+1 < len(runes) {
+			if ligature, ok := lamAlef[runes[i+1]]; ok {
+				shaped.WriteRune(ligature + rune(ternary(prev, 1, 0)))
+				i++
+				continue
+			}
+		}
+
+		next := form.dual && i+1 < len(runes) && joinable(i+1)
+		switch {
+		case prev && next:
+			shaped.WriteRune(form.isolated + 3)
+		case prev && runes[i] != 'ء':
+			shaped.WriteRune(form.isolated + 1)
+		case next:
+			shaped.WriteRune(form.isolated + 2)
+		default:
+			shaped.WriteRune(form.isolated)
+		}
+	}
+	return shaped.String()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// checkPixels limits the canvas to MAX_PIXELS. MAX_SIZE bounds each side,
+// but a 3000x3000 canvas is still 36MB before encoding, and supersampling or
+// animating it multiplies that.
+func checkPixels(width, height int) error {
+	if config.maxPixels > 0 && width*height > config.maxPixels {
+		return &apiError{http.StatusRequestEntityTooLarge, "too_many_pixels",
+			fmt.Sprintf("Size %dx%d is %d pixels, more than the %d allowed.", width, height, width*height, config.maxPixels)}
+	}
+	return nil
+}
+
+// checkFontSize limits the glyphs of text at size, drawn supersample times
+// larger, to MAX_PIXELS. Faces allocate at least one glyph mask up front,
+// which grows with the square of the size whatever the canvas.
+func checkFontSize(size float64, supersample int) error {
+	if config.maxPixels <= 0 || regularFontErr != nil {
+		return nil
+	}
+	scaled := size * float64(supersample)
+	if glyph := glyphBytes(regularFont, scaled, 72); glyph > config.maxPixels {
+		return &apiError{http.StatusRequestEntityTooLarge, "font_too_large",
+			fmt.Sprintf("Font size %g at supersample %d needs glyphs of %d pixels, more than the %d allowed.", size, supersample, glyph, config.maxPixels)}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/freetype/truetype"
+)
+
+func TestFontSizeBudget(t *testing.T) {
+	defer func(pixels int) { config.maxPixels = pixels }(config.maxPixels)
+	config.maxPixels = 400000
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerRoutes(r, nil, nil)
+
+	tests := []struct {
+		target string
+		status int
+		code   string
+	}{
+		{"/600x150?text=Hi&fontSize=60&supersample=2", http.StatusOK, ""},
+		{"/600x150?text=Hi&fontSize=600", http.StatusRequestEntityTooLarge, "font_too_large"},
+		{"/600x150?text=Hi&fontSize=300&supersample=2", http.StatusRequestEntityTooLarge, "font_too_large"},
+		{"/600x150?text=Hi&text2=Caption&text2Size=600", http.StatusRequestEntityTooLarge, "font_too_large"},
+	}
+	for _, test := range tests {
+		w := request(r, http.MethodGet, test.target, "", nil)
+		var body struct{ Code string }
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != test.status || body.Code != test.code {
+			t.Errorf("%s: got %d %q, want %d %q", test.target, w.Code, body.Code, test.status, test.code)
+		}
+	}
+}
+
+func TestGlyphCacheBounded(t *testing.T) {
+	for _, size := range []float64{12, 100, 600, 3000} {
+		options := withGlyphCache(regularFont, &truetype.Options{Size: size, DPI: 72})
+		glyph := glyphBytes(regularFont, size, 72)
+		if options.GlyphCacheEntries > 1 && options.GlyphCacheEntries*glyph > glyphCacheBytes {
+			t.Errorf("size %g: %d entries of %d bytes, over the budget", size, options.GlyphCacheEntries, glyph)
+		}
+	}
+	if options := withGlyphCache(regularFont, &truetype.Options{Size: 12, DPI: 72}); options.GlyphCacheEntries != 512 {
+		t.Errorf("small faces got %d glyph cache entries, want 512", options.GlyphCacheEntries)
+	}
+}
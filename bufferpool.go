@@ -0,0 +1,84 @@
+package main
+
+import (
+	"expvar"
+	"image"
+	"math/bits"
+	"sync"
+)
+
+// Pixel buffers are pooled in size classes, four between each power of two,
+// so a buffer is at most 25% larger than the image it is used for. A
+// 3000x3000 canvas is 36MB, which is most of what a request allocates.
+var bufferBuckets [64 * 4]sync.Pool
+
+// minPooledBytes is the smallest buffer worth pooling.
+const minPooledBytes = 4 << 10
+
+var (
+	bufferPoolStats = expvar.NewMap("bufferPool")
+	bufferHits      = new(expvar.Int)
+	bufferMisses    = new(expvar.Int)
+)
+
+func init() {
+	bufferPoolStats.Set("hits", bufferHits)
+	bufferPoolStats.Set("misses", bufferMisses)
+	bufferPoolStats.Set("hitRate", expvar.Func(func() any {
+		hits, misses := bufferHits.Value(), bufferMisses.Value()
+		if hits+misses == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(hits+misses)
+	}))
+}
+
+// newPooledRGBA returns a cleared image like image.NewRGBA, backed by a
+// pooled buffer when one is free. Give it back with releaseRGBA once
+// nothing refers to it anymore.
+func newPooledRGBA(rect image.Rectangle) *image.RGBA {
+	size := 4 * rect.Dx() * rect.Dy()
+	if size < minPooledBytes {
+		return image.NewRGBA(rect)
+	}
+
+	bucket, capacity := bufferClass(size)
+	if pooled, ok := bufferBuckets[bucket].Get().(*[]byte); ok {
+		bufferHits.Add(1)
+		pix := (*pooled)[:size]
+		clear(pix)
+		return &image.RGBA{Pix: pix, Stride: 4 * rect.Dx(), Rect: rect}
+	}
+	bufferMisses.Add(1)
+	pix := make([]byte, size, capacity)
+	return &image.RGBA{Pix: pix, Stride: 4 * rect.Dx(), Rect: rect}
+}
+
+// releaseRGBA returns the buffer of an image from newPooledRGBA to its
+// bucket. Other images are left to the garbage collector.
+func releaseRGBA(img *image.RGBA) {
+	if img == nil {
+		return
+	}
+	capacity := cap(img.Pix)
+	if capacity < minPooledBytes {
+		return
+	}
+	bucket, classCapacity := bufferClass(capacity)
+	if classCapacity != capacity {
+		return
+	}
+	pix := img.Pix[:capacity]
+	img.Pix = nil
+	bufferBuckets[bucket].Put(&pix)
+}
+
+// bufferClass returns the bucket and capacity of buffers for size bytes,
+// from minPooledBytes. Between 2^(e-1) and 2^e bytes, capacities are steps
+// of 2^(e-3).
+func bufferClass(size int) (int, int) {
+	e := bits.Len(uint(size - 1))
+	step := 1 << (e - 3)
+	capacity := (size + step - 1) &^ (step - 1)
+	return e*4 + capacity/step - 5, capacity
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestBufferClass(t *testing.T) {
+	seen := map[int]int{}
+	for size := minPooledBytes; size <= 1<<22; size += 4093 {
+		bucket, capacity := bufferClass(size)
+		if capacity < size || capacity > size+size/4 {
+			t.Fatalf("%d bytes got a capacity of %d", size, capacity)
+		}
+		if other, ok := seen[bucket]; ok && other != capacity {
+			t.Fatalf("bucket %d has capacities %d and %d", bucket, other, capacity)
+		}
+		seen[bucket] = capacity
+	}
+}
+
+func TestPooledRGBAReuse(t *testing.T) {
+	img := newPooledRGBA(image.Rect(0, 0, 600, 400))
+	img.Pix[0] = 0xFF
+	releaseRGBA(img)
+
+	// A slightly smaller image fits in the same size class, cleared.
+	again := newPooledRGBA(image.Rect(0, 0, 590, 400))
+	defer releaseRGBA(again)
+	if len(again.Pix) != 4*590*400 || again.Pix[0] != 0 {
+		t.Errorf("got %d bytes starting with %d, want %d cleared bytes", len(again.Pix), again.Pix[0], 4*590*400)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// cacheVersion is part of every cache key. Bump it when keys change
+// meaning, so images cached under older keys aren't served.
+const cacheVersion = 2
+
+// renderKey hashes the spec of a render, which starts with the name of its
+// route, into its cache key.
+func renderKey(spec string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("v%d|%s", cacheVersion, spec)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache stores encoded images by their cache key.
+type Cache interface {
+	get(key string) ([]byte, bool)
+	put(key string, data []byte) error
+	// remove deletes one image and reports whether it was cached.
+	remove(key string) (bool, error)
+	// purge deletes every image and returns how many there were.
+	purge() (int, error)
+}
+
+func newCache() (Cache, error) {
+	switch config.cacheBackend {
+	case "":
+		return nil, nil
+	case "disk":
+		return newDiskCache(config.cacheDir, config.cacheMaxBytes)
+	case "s3", "gcs":
+		return newObjectStorage(config.cacheBackend)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", config.cacheBackend)
+	}
+}
+
+// newObjectStorage connects to the bucket of an s3 or gcs CACHE_BACKEND.
+func newObjectStorage(backend string) (*ObjectCache, error) {
+	if backend == "gcs" {
+		// GCS is used through its S3 compatible XML API with HMAC keys.
+		return newObjectCache("https://storage.googleapis.com", "auto")
+	}
+	return newObjectCache(config.cacheEndpoint, config.cacheRegion)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// maxCells caps the columns and rows of ?grid=.
+const maxCells = 20
+
+// setCells reads ?grid=3x2, which splits the canvas into 3 columns and 2
+// rows of labelled cells.
+func (i *Image) setCells(grid string) {
+	i.columns, i.rows = 0, 0
+	columns, rows, ok := strings.Cut(grid, "x")
+	if !ok {
+		return
+	}
+	c, err := strconv.Atoi(columns)
+	if err != nil {
+		return
+	}
+	r, err := strconv.Atoi(rows)
+	if err != nil {
+		return
+	}
+	i.columns, i.rows = clamp(c, 1, maxCells), clamp(r, 1, maxCells)
+}
+
+// drawCells labels every cell with its index and size, in place of the
+// text. Cell edges fall on whole pixels, so sizes can differ by one.
+func (i *Image) drawCells(ctx context.Context, img *image.RGBA) error {
+	edge := &image.Uniform{color.NRGBA{i.fg.R, i.fg.G, i.fg.B, 0x80}}
+	cell := *i
+	cell.textPosition, cell.blocks = "center", nil
+	for row := 0; row < i.rows; row++ {
+		y0, y1 := i.height*row/i.rows, i.height*(row+1)/i.rows
+		for column := 0; column < i.columns; column++ {
+			x0, x1 := i.width*column/i.columns, i.width*(column+1)/i.columns
+
+			cell.width, cell.height = x1-x0, y1-y0
+			cell.fontSize = i.fontSize * float64(cell.width) / float64(i.width)
+			cell.text = fmt.Sprintf("%d %dx%d", row*i.columns+column+1, cell.width, cell.height)
+			layer := image.NewRGBA(image.Rect(0, 0, cell.width, cell.height))
+			if err := cell.drawText(ctx, layer); err != nil {
+				return err
+			}
+			draw.Draw(img, image.Rect(x0, y0, x1, y1), layer, image.Point{}, draw.Over)
+
+			if column > 0 {
+				draw.Draw(img, image.Rect(x0, y0, x0+1, y1), edge, image.Point{}, draw.Over)
+			}
+			if row > 0 {
+				draw.Draw(img, image.Rect(x0, y0, x1, y0+1), edge, image.Point{}, draw.Over)
+			}
+		}
+	}
+	return nil
+}
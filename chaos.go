@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxChaosDelay = 30 * time.Second
+
+// chaos injects artificial latency (?delay=ms) and random failures
+// (?fail=probability) so frontends can test loading and error states. It is
+// only installed when CHAOS is enabled.
+func chaos(c *gin.Context) {
+	if ms, err := strconv.Atoi(c.Query("delay")); err == nil && ms > 0 {
+		delay := time.Duration(ms) * time.Millisecond
+		if delay > maxChaosDelay {
+			delay = maxChaosDelay
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-c.Request.Context().Done():
+			c.Abort()
+			return
+		}
+	}
+
+	if probability, err := strconv.ParseFloat(c.Query("fail"), 64); err == nil && rand.Float64() < probability {
+		abortProblem(c, http.StatusInternalServerError, "injected_failure", "Injected failure.")
+		return
+	}
+
+	c.Next()
+}
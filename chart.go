@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/vector"
+)
+
+// maxSeries caps the number of values in a chart.
+const maxSeries = 100
+
+type Chart struct {
+	width  int
+	height int
+	kind   string
+	series []float64
+	bg     color.RGBA
+	fg     color.RGBA
+}
+
+func chartHandler(c *gin.Context) {
+	img := &Image{maxSize: maxSizeFor(c)}
+	if err := img.setSize(c.Param("size")); err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	img.setSeed(c.Query("seed"), "")
+	img.setColors(c.Query("bg"), c.Query("fg"))
+
+	series, err := parseSeries(c.DefaultQuery("series", "5,8,3,9,6"))
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_series")
+		return
+	}
+
+	chart := &Chart{
+		width:  img.width,
+		height: img.height,
+		kind:   c.DefaultQuery("type", "bar"),
+		series: series,
+		bg:     img.bg,
+		fg:     img.fg,
+	}
+	if chart.kind != "bar" && chart.kind != "line" && chart.kind != "pie" {
+		problem(c, http.StatusBadRequest, "unknown_chart_type", fmt.Sprintf("Unknown chart type %q.", chart.kind))
+		return
+	}
+
+	serveRender(c, chart.cacheKey(), "image/png", chart.render)
+}
+
+// parseSeries reads comma separated values. Negative values are drawn as
+// zero.
+func parseSeries(value string) ([]float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) > maxSeries {
+		return nil, badRequest("invalid_series", "A series can have at most %d values.", maxSeries)
+	}
+
+	series := make([]float64, len(parts))
+	for n, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, badRequest("invalid_series", "Series should be comma separated numbers.")
+		}
+		series[n] = math.Max(0, v)
+	}
+	return series, nil
+}
+
+func (ch *Chart) cacheKey() string {
+	spec := fmt.Sprintf("chart|%d|%d|%s|%v|%v|%v", ch.width, ch.height, ch.kind, ch.series, ch.bg, ch.fg)
+	return renderKey(spec)
+}
+
+func (ch *Chart) render(ctx context.Context, w io.Writer) error {
+	img := image.NewRGBA(image.Rect(0, 0, ch.width, ch.height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{ch.bg}, image.Point{}, draw.Src)
+
+	margin := ternary(ch.width < ch.height, ch.width, ch.height) / 12
+	plot := img.Bounds().Inset(margin)
+	switch ch.kind {
+	case "bar":
+		ch.drawAxes(img, plot)
+		ch.drawBars(img, plot)
+	case "line":
+		ch.drawAxes(img, plot)
+		ch.drawLine(img, plot)
+	case "pie":
+		ch.drawPie(img, plot)
+	}
+
+	return pngEncoder.Encode(&contextWriter{ctx, w}, img)
+}
+
+// maximum is the largest value, or 1 so an all zero series still draws.
+func (ch *Chart) maximum() float64 {
+	maximum := 0.0
+	for _, v := range ch.series {
+		maximum = math.Max(maximum, v)
+	}
+	return ternary(maximum > 0, maximum, 1)
+}
+
+// drawAxes draws the axes and four faint gridlines.
+func (ch *Chart) drawAxes(img *image.RGBA, plot image.Rectangle) {
+	faint := &image.Uniform{color.NRGBA{ch.fg.R, ch.fg.G, ch.fg.B, 0x40}}
+	for n := 1; n <= 4; n++ {
+		y := plot.Max.Y - plot.Dy()*n/4
+		draw.Draw(img, image.Rect(plot.Min.X, y, plot.Max.X, y+1), faint, image.Point{}, draw.Over)
+	}
+
+	axis := &image.Uniform{ch.fg}
+	draw.Draw(img, image.Rect(plot.Min.X, plot.Min.Y, plot.Min.X+2, plot.Max.Y), axis, image.Point{}, draw.Over)
+	draw.Draw(img, image.Rect(plot.Min.X, plot.Max.Y-2, plot.Max.X, plot.Max.Y), axis, image.Point{}, draw.Over)
+}
+
+func (ch *Chart) drawBars(img *image.RGBA, plot image.Rectangle) {
+	maximum := ch.maximum()
+	slot := float64(plot.Dx()) / float64(len(ch.series))
+	for n, v := range ch.series {
+		x0 := plot.Min.X + int(slot*(float64(n)+0.15))
+		x1 := plot.Min.X + int(slot*(float64(n)+0.85))
+		top := plot.Max.Y - int(v/maximum*float64(plot.Dy()))
+		draw.Draw(img, image.Rect(x0, top, ternary(x1 > x0, x1, x0+1), plot.Max.Y), &image.Uniform{ch.fg}, image.Point{}, draw.Over)
+	}
+}
+
+func (ch *Chart) drawLine(img *image.RGBA, plot image.Rectangle) {
+	maximum := ch.maximum()
+	thickness := float32(math.Max(2, float64(plot.Dy())/100))
+	step := float32(plot.Dx()) / float32(ternary(len(ch.series) > 1, len(ch.series)-1, 1))
+	point := func(n int) (float32, float32) {
+		return float32(plot.Min.X) + step*float32(n),
+			float32(plot.Max.Y) - float32(ch.series[n]/maximum)*float32(plot.Dy())
+	}
+
+	rasterizer := vector.NewRasterizer(img.Rect.Dx(), img.Rect.Dy())
+	for n := 1; n < len(ch.series); n++ {
+		x0, y0 := point(n - 1)
+		x1, y1 := point(n)
+		strokeLine(rasterizer, x0, y0, x1, y1, thickness)
+	}
+	rasterizer.Draw(img, img.Rect, &image.Uniform{ch.fg}, image.Point{})
+
+	for n := range ch.series {
+		x, y := point(n)
+		fillCircle(img, x, y, thickness*1.5, ch.fg)
+	}
+}
+
+// drawPie draws one slice per value, in shades running from the text color
+// towards the background.
+func (ch *Chart) drawPie(img *image.RGBA, plot image.Rectangle) {
+	total := 0.0
+	for _, v := range ch.series {
+		total += v
+	}
+	if total == 0 {
+		return
+	}
+
+	cx, cy := float32(plot.Min.X+plot.Max.X)/2, float32(plot.Min.Y+plot.Max.Y)/2
+	radius := float32(ternary(plot.Dx() < plot.Dy(), plot.Dx(), plot.Dy())) / 2
+
+	// Start at twelve o'clock and go clockwise.
+	angle := -math.Pi / 2
+	for n, v := range ch.series {
+		sweep := v / total * 2 * math.Pi
+		if sweep == 0 {
+			continue
+		}
+
+		rasterizer := vector.NewRasterizer(img.Rect.Dx(), img.Rect.Dy())
+		rasterizer.MoveTo(cx, cy)
+		segments := int(math.Ceil(sweep / (math.Pi / 90)))
+		for s := 0; s <= segments; s++ {
+			a := angle + sweep*float64(s)/float64(segments)
+			rasterizer.LineTo(cx+radius*float32(math.Cos(a)), cy+radius*float32(math.Sin(a)))
+		}
+		rasterizer.ClosePath()
+
+		shade := mixColors(ch.fg, ch.bg, 0.75*float64(n)/float64(len(ch.series)))
+		rasterizer.Draw(img, img.Rect, &image.Uniform{shade}, image.Point{})
+		angle += sweep
+	}
+}
+
+// mixColors blends from a to b, t is between 0 and 1.
+func mixColors(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 { return clampChannel(float64(x) + t*(float64(y)-float64(x))) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
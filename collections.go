@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SavedSpec struct {
+	ID string `json:"id"`
+	// Key is the hash of the API key that saved the spec, see collectionKey.
+	Key     string    `json:"-"`
+	Name    string    `json:"name"`
+	Spec    string    `json:"spec"`
+	Created time.Time `json:"created"`
+}
+
+// maxSpecsPerKey, maxSpecLength and maxSpecNameLength cap what one API key
+// can save.
+const (
+	maxSpecsPerKey    = 500
+	maxSpecLength     = 2048
+	maxSpecNameLength = 200
+)
+
+type Collections struct {
+	mu    sync.RWMutex
+	path  string
+	specs map[string]*SavedSpec
+	// journal is COLLECTIONS_FILE, open for appending changes, and changes
+	// counts its lines.
+	journal *os.File
+	changes int
+	// broken is why COLLECTIONS_FILE couldn't be loaded. Nothing is written
+	// over it until it is fixed and the server restarted.
+	broken error
+}
+
+// collectionChange is a line of COLLECTIONS_FILE, a saved spec with the hash
+// of its key or the id of a removed one. Older versions wrote the key itself
+// as Key, which is hashed when the file is loaded.
+type collectionChange struct {
+	Key     string     `json:"key,omitempty"`
+	KeyHash string     `json:"keyHash,omitempty"`
+	Spec    *SavedSpec `json:"spec,omitempty"`
+	Removed string     `json:"removed,omitempty"`
+}
+
+var collections = newCollections(config.collectionsFile)
+
+// newCollections loads the collections saved in path and compacts it. When
+// it can't be read, the collections start empty and changes are refused
+// instead of overwriting it.
+func newCollections(path string) *Collections {
+	store := &Collections{path: path, specs: map[string]*SavedSpec{}}
+	if path == "" {
+		return store
+	}
+	if err := store.load(); err != nil {
+		log.Printf("Failed to load collections, saving is disabled: %v", err)
+		store.broken = err
+		return store
+	}
+	if err := store.compact(); err != nil {
+		log.Printf("Failed to write collections, saving is disabled: %v", err)
+		store.broken = err
+	}
+	return store
+}
+
+// load replays the changes in the file, or reads the object of specs by
+// key that older versions wrote.
+func (s *Collections) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved map[string][]*SavedSpec
+	if json.Unmarshal(data, &saved) == nil {
+		for key, specs := range saved {
+			for _, spec := range specs {
+				s.apply(collectionChange{KeyHash: hashKey(key), Spec: spec})
+			}
+		}
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for line := 1; ; line++ {
+		var change collectionChange
+		err := decoder.Decode(&change)
+		switch {
+		case err == io.EOF:
+			return nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			// The server stopped while appending the last change.
+			log.Printf("Ignoring the truncated change %d of %s", line, s.path)
+			return nil
+		case err != nil:
+			return fmt.Errorf("change %d of %s: %w", line, s.path, err)
+		}
+		if change.Key != "" {
+			change.KeyHash, change.Key = hashKey(change.Key), ""
+		}
+		s.apply(change)
+	}
+}
+
+func (s *Collections) apply(change collectionChange) {
+	if change.Spec != nil {
+		change.Spec.Key = change.KeyHash
+		s.specs[change.Spec.ID] = change.Spec
+	}
+	if change.Removed != "" {
+		delete(s.specs, change.Removed)
+	}
+}
+
+// compact replaces the file with one line per saved spec, through a
+// temporary file so a crash leaves the old one, and opens it for appending.
+// Callers must hold the lock, or own the collections.
+func (s *Collections) compact() error {
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	specs := make([]*SavedSpec, 0, len(s.specs))
+	for _, spec := range s.specs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(a, b int) bool { return specs[a].Created.Before(specs[b].Created) })
+	encoder := json.NewEncoder(tmp)
+	for _, spec := range specs {
+		if err = encoder.Encode(collectionChange{KeyHash: spec.Key, Spec: spec}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		return err
+	}
+	s.changes = len(specs)
+	s.journal, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+// record appends a change to the file, and compacts it once most of its
+// lines are outdated. Callers must hold the lock.
+func (s *Collections) record(change collectionChange) error {
+	if s.path == "" {
+		return nil
+	}
+	if s.broken != nil {
+		return fmt.Errorf("collections can't be saved: %w", s.broken)
+	}
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	if _, err := s.journal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.changes++
+	if s.changes > 2*len(s.specs)+100 {
+		// The change is written, a failed compaction only keeps the file long.
+		if err := s.compact(); err != nil {
+			log.Printf("Failed to compact collections: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *Collections) list(key string) []*SavedSpec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	specs := []*SavedSpec{}
+	for _, spec := range s.specs {
+		if spec.Key == key {
+			specs = append(specs, spec)
+		}
+	}
+	sort.Slice(specs, func(a, b int) bool {
+		return specs[a].Created.Before(specs[b].Created)
+	})
+	return specs
+}
+
+func (s *Collections) get(id string) (*SavedSpec, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	spec, ok := s.specs[id]
+	return spec, ok
+}
+
+// add saves a spec, unless its key has maxSpecsPerKey already.
+func (s *Collections) add(spec *SavedSpec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, saved := range s.specs {
+		if saved.Key == spec.Key {
+			count++
+		}
+	}
+	if count >= maxSpecsPerKey {
+		return &apiError{http.StatusConflict, "collection_full", fmt.Sprintf("A collection can have at most %d specs.", maxSpecsPerKey)}
+	}
+	if err := s.record(collectionChange{KeyHash: spec.Key, Spec: spec}); err != nil {
+		return err
+	}
+	s.apply(collectionChange{KeyHash: spec.Key, Spec: spec})
+	return nil
+}
+
+// remove deletes a spec of key and reports whether there was one.
+func (s *Collections) remove(key, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spec, ok := s.specs[id]
+	if !ok || spec.Key != key {
+		return false, nil
+	}
+	if err := s.record(collectionChange{Removed: id}); err != nil {
+		return false, err
+	}
+	s.apply(collectionChange{Removed: id})
+	return true, nil
+}
+
+// parseSpec validates a spec such as "400x300?text=hero&bg=0c79ed" and
+// returns the size and query parts.
+func parseSpec(spec string) (string, url.Values, error) {
+	parsed, err := url.Parse(strings.TrimPrefix(spec, "/"))
+	if err != nil || parsed.Path == "" || strings.Contains(parsed.Path, "/") {
+		return "", nil, badRequest("invalid_spec", "Spec should look like 400x300?text=hello.")
+	}
+	return parsed.Path, parsed.Query(), nil
+}
+
+// collectionKey returns the hash of the API key of a request, which its
+// collection is saved under, so COLLECTIONS_FILE doesn't hold the keys.
+func collectionKey(c *gin.Context) string {
+	return hashKey(requestAPIKey(c))
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireCollectionKey lets requests with a key through. Once API keys are
+// configured, the key has to be one of them.
+func requireCollectionKey(c *gin.Context) {
+	key := requestAPIKey(c)
+	if key == "" {
+		abortProblem(c, http.StatusUnauthorized, "api_key_required", "An API key is required.")
+		return
+	}
+	if _, ok := apiKeys[key]; len(apiKeys) > 0 && !ok {
+		abortProblem(c, http.StatusUnauthorized, "invalid_api_key", "Invalid API key.")
+		return
+	}
+	c.Next()
+}
+
+func listCollectionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, collections.list(collectionKey(c)))
+}
+
+func saveCollectionHandler(c *gin.Context) {
+	var body struct {
+		Name string `json:"name"`
+		Spec string `json:"spec"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		problem(c, http.StatusBadRequest, "invalid_body", "Invalid request body.")
+		return
+	}
+
+	if len(body.Spec) > maxSpecLength {
+		problem(c, http.StatusBadRequest, "invalid_spec", fmt.Sprintf("Specs can have at most %d characters.", maxSpecLength))
+		return
+	}
+	if len(body.Name) > maxSpecNameLength {
+		problem(c, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Names can have at most %d characters.", maxSpecNameLength))
+		return
+	}
+	if _, _, err := parseSpec(body.Spec); err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_spec")
+		return
+	}
+
+	spec := &SavedSpec{
+		ID:      randomID(),
+		Key:     collectionKey(c),
+		Name:    body.Name,
+		Spec:    strings.TrimPrefix(body.Spec, "/"),
+		Created: time.Now().UTC(),
+	}
+	if err := collections.add(spec); err != nil {
+		var known *apiError
+		if errors.As(err, &known) {
+			problemFor(c, err, http.StatusInternalServerError, "save_failed")
+			return
+		}
+		log.Printf("Failed to save a spec: %v", err)
+		problem(c, http.StatusInternalServerError, "save_failed", "Failed to save the spec.")
+		return
+	}
+	c.JSON(http.StatusCreated, spec)
+}
+
+func deleteCollectionHandler(c *gin.Context) {
+	removed, err := collections.remove(collectionKey(c), c.Param("id"))
+	if err != nil {
+		log.Printf("Failed to remove a spec: %v", err)
+		problem(c, http.StatusInternalServerError, "save_failed", "Failed to remove the spec.")
+		return
+	}
+	if !removed {
+		problem(c, http.StatusNotFound, "spec_not_found", "Spec not found.")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func exportCollectionHandler(c *gin.Context) {
+	type manifestEntry struct {
+		Name string `json:"name"`
+		Spec string `json:"spec"`
+		URL  string `json:"url"`
+	}
+
+	manifest := []manifestEntry{}
+	for _, spec := range collections.list(collectionKey(c)) {
+		manifest = append(manifest, manifestEntry{spec.Name, spec.Spec, "/" + spec.Spec})
+	}
+	c.Header("Content-Disposition", `attachment; filename="manifest.json"`)
+	c.JSON(http.StatusOK, gin.H{"specs": manifest})
+}
+
+// renderCollectionHandler re-renders a saved spec. Specs are shared by id,
+// so no API key is needed to view them.
+func renderCollectionHandler(c *gin.Context) {
+	spec, ok := collections.get(c.Param("id"))
+	if !ok {
+		problem(c, http.StatusNotFound, "spec_not_found", "Spec not found.")
+		return
+	}
+
+	size, query, err := parseSpec(spec.Spec)
+	if err != nil {
+		problem(c, http.StatusInternalServerError, "invalid_spec", err.Error())
+		return
+	}
+
+	c.Params = gin.Params{{Key: "size", Value: size}}
+	c.Request.URL.RawQuery = query.Encode()
+	imageHandler(c)
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectionsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collections.json")
+	store := newCollections(path)
+	for _, id := range []string{"a", "b"} {
+		if err := store.add(&SavedSpec{ID: id, Key: "k", Spec: "300x200", Created: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if removed, err := store.remove("k", "a"); !removed || err != nil {
+		t.Fatalf("remove returned %v, %v", removed, err)
+	}
+
+	loaded := newCollections(path)
+	if specs := loaded.list("k"); len(specs) != 1 || specs[0].ID != "b" {
+		t.Errorf("reloaded %v, want only b", specs)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("compacted file has %d lines, want 1", lines)
+	}
+}
+
+func TestCollectionsLoadOldFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collections.json")
+	os.WriteFile(path, []byte(`{"k": [{"id": "a", "name": "hero", "spec": "300x200"}]}`), 0o644)
+	if specs := newCollections(path).list(hashKey("k")); len(specs) != 1 || specs[0].Name != "hero" {
+		t.Errorf("loaded %v, want the hero spec", specs)
+	}
+}
+
+func TestCollectionsHashKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collections.json")
+	os.WriteFile(path, []byte(`{"key":"secret","spec":{"id":"a","spec":"300x200"}}`+"\n"), 0o644)
+	if specs := newCollections(path).list(hashKey("secret")); len(specs) != 1 {
+		t.Errorf("loaded %v, want the spec of the hashed key", specs)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "secret") {
+		t.Errorf("compacted file still has the key: %s", data)
+	}
+}
+
+func TestCollectionsKeepUnreadableFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collections.json")
+	os.WriteFile(path, []byte("not json"), 0o644)
+	store := newCollections(path)
+	if err := store.add(&SavedSpec{ID: "a", Key: "k", Spec: "300x200"}); err == nil {
+		t.Error("saved over an unreadable file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "not json" {
+		t.Errorf("file was changed to %q", data)
+	}
+}
+
+func TestCollectionsLimit(t *testing.T) {
+	store := newCollections("")
+	for n := 0; n < maxSpecsPerKey; n++ {
+		if err := store.add(&SavedSpec{ID: randomID(), Key: "k", Spec: "300x200"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.add(&SavedSpec{ID: randomID(), Key: "k", Spec: "300x200"}); err == nil {
+		t.Error("saved more than maxSpecsPerKey specs")
+	}
+	if err := store.add(&SavedSpec{ID: randomID(), Key: "other", Spec: "300x200"}); err != nil {
+		t.Errorf("another key can't save: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+// Package color parses and formats the colors accepted in query parameters.
+package color
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// ParseHex parses a CSS style hex color with an optional leading '#': rgb,
+// rgba, rrggbb or rrggbbaa. Anything else is an error, the color is never
+// partially applied.
+func ParseHex(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+
+	digits := make([]uint8, len(hex))
+	for n := 0; n < len(hex); n++ {
+		v, ok := hexDigit(hex[n])
+		if !ok {
+			return color.RGBA{}, fmt.Errorf("Invalid color %q, %q is not a hex digit.", s, hex[n])
+		}
+		digits[n] = v
+	}
+
+	switch len(digits) {
+	case 3, 4:
+		// Short forms repeat each digit, so f0c is ff00cc.
+		c := color.RGBA{digits[0] * 0x11, digits[1] * 0x11, digits[2] * 0x11, 0xFF}
+		if len(digits) == 4 {
+			c.A = digits[3] * 0x11
+		}
+		return c, nil
+	case 6, 8:
+		c := color.RGBA{digits[0]<<4 | digits[1], digits[2]<<4 | digits[3], digits[4]<<4 | digits[5], 0xFF}
+		if len(digits) == 8 {
+			c.A = digits[6]<<4 | digits[7]
+		}
+		return c, nil
+	}
+	return color.RGBA{}, fmt.Errorf("Invalid color %q, expected 3, 4, 6 or 8 hex digits.", s)
+}
+
+// Hex formats c as #rrggbb, or #rrggbbaa when it is not opaque.
+func Hex(c color.RGBA) string {
+	if c.A == 0xFF {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+func hexDigit(b byte) (uint8, bool) {
+	switch {
+	case '0' <= b && b <= '9':
+		return b - '0', true
+	case 'a' <= b && b <= 'f':
+		return b - 'a' + 10, true
+	case 'A' <= b && b <= 'F':
+		return b - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,118 @@
+package color
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.RGBA
+	}{
+		{"f0c", color.RGBA{0xFF, 0x00, 0xCC, 0xFF}},
+		{"#f0c", color.RGBA{0xFF, 0x00, 0xCC, 0xFF}},
+		{"f0c8", color.RGBA{0xFF, 0x00, 0xCC, 0x88}},
+		{"0c79ed", color.RGBA{0x0C, 0x79, 0xED, 0xFF}},
+		{"#0C79ED", color.RGBA{0x0C, 0x79, 0xED, 0xFF}},
+		{"0000007f", color.RGBA{0x00, 0x00, 0x00, 0x7F}},
+		{"ffffff00", color.RGBA{0xFF, 0xFF, 0xFF, 0x00}},
+	}
+	for _, test := range tests {
+		got, err := ParseHex(test.in)
+		if err != nil {
+			t.Errorf("ParseHex(%q) returned error: %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseHex(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseHexInvalid(t *testing.T) {
+	for _, in := range []string{
+		"", "#", "f", "ff", "fffff", "fffffff", "fffffffff",
+		"ggg", "12345g", "+12345", "0x1234", " fff", "fff ", "##fff", "ff ff",
+	} {
+		if got, err := ParseHex(in); err == nil {
+			t.Errorf("ParseHex(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestHex(t *testing.T) {
+	tests := []struct {
+		in   color.RGBA
+		want string
+	}{
+		{color.RGBA{0x0C, 0x79, 0xED, 0xFF}, "#0c79ed"},
+		{color.RGBA{0x00, 0x00, 0x00, 0x7F}, "#0000007f"},
+	}
+	for _, test := range tests {
+		if got := Hex(test.in); got != test.want {
+			t.Errorf("Hex(%v) = %q, want %q", test.in, got, test.want)
+		}
+		if back, err := ParseHex(Hex(test.in)); err != nil || back != test.in {
+			t.Errorf("ParseHex(Hex(%v)) = %v, %v", test.in, back, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.RGBA
+	}{
+		{"0c79ed", color.RGBA{0x0C, 0x79, 0xED, 0xFF}},
+		{"rgb(255,0,0)", color.RGBA{0xFF, 0x00, 0x00, 0xFF}},
+		{"rgb(255, 128, 0)", color.RGBA{0xFF, 0x80, 0x00, 0xFF}},
+		{"RGB(100%,0%,50%)", color.RGBA{0xFF, 0x00, 0x80, 0xFF}},
+		{"rgba(0,0,0,0.5)", color.RGBA{0x00, 0x00, 0x00, 0x80}},
+		{"rgb(0 0 0 / 50%)", color.RGBA{0x00, 0x00, 0x00, 0x80}},
+		{"hsl(0,100%,50%)", color.RGBA{0xFF, 0x00, 0x00, 0xFF}},
+		{"hsl(120deg 100% 25%)", color.RGBA{0x00, 0x80, 0x00, 0xFF}},
+		{"hsl(210,50%,40%)", color.RGBA{0x33, 0x66, 0x99, 0xFF}},
+		{"hsl(-120,100%,50%)", color.RGBA{0x00, 0x00, 0xFF, 0xFF}},
+		{"hsla(0,0%,100%,0)", color.RGBA{0xFF, 0xFF, 0xFF, 0x00}},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Parse(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{
+		"rgb(", "rgb()", "rgb(1,2)", "rgb(1,2,3,4,5)", "rgb(256,0,0)", "rgb(-1,0,0)",
+		"rgb(1,2,x)", "rgb(1,,3)", "rgb(1,2,3,1.5)", "rgb(1, 2, 3 / 0.5)", "rgb(NaN,0,0)",
+		"hsl(0,50,50)", "hsl(x,50%,50%)", "hsl(0,101%,50%)", "hsl(Inf,50%,50%)",
+		"cmyk(0,0,0,0)", "rgb(1,2,3))",
+	} {
+		if got, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, in := range []string{"fff", "#f0c8", "0c79ed", "#0000007f", "rgb(1 2 3 / 50%)", "rgba(1,2,3,0.5)", "hsl(210,50%,40%)", "hsla(0 0% 100% / 0)"} {
+		f.Add(in)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		c, err := Parse(in)
+		if err != nil {
+			return
+		}
+		// Every parsed color must survive a round trip through Hex.
+		if got, err := ParseHex(Hex(c)); err != nil || got != c {
+			t.Errorf("ParseHex(Hex(%v)) = %v, %v", c, got, err)
+		}
+	})
+}
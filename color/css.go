@@ -0,0 +1,152 @@
+package color
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Parse accepts a hex color or the CSS rgb(), rgba(), hsl() and hsla()
+// functions. Arguments may be separated by commas or spaces, with the
+// alpha after a slash in the space separated form: rgb(255 0 0 / 50%).
+func Parse(s string) (color.RGBA, error) {
+	name, args, ok := strings.Cut(strings.TrimSpace(s), "(")
+	if !ok {
+		return ParseHex(s)
+	}
+	if !strings.HasSuffix(args, ")") {
+		return color.RGBA{}, fmt.Errorf("Invalid color %q, missing a closing parenthesis.", s)
+	}
+
+	values, err := splitArgs(strings.TrimSuffix(args, ")"))
+	if err != nil || (len(values) != 3 && len(values) != 4) {
+		return color.RGBA{}, fmt.Errorf("Invalid color %q, expected 3 or 4 arguments.", s)
+	}
+
+	alpha := 1.0
+	if len(values) == 4 {
+		if alpha, err = parseNumber(values[3], 1); err != nil || alpha < 0 || alpha > 1 {
+			return color.RGBA{}, fmt.Errorf("Invalid color %q, alpha should be between 0 and 1 or 0%% and 100%%.", s)
+		}
+	}
+
+	var c color.RGBA
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "rgb", "rgba":
+		var channels [3]uint8
+		for n, value := range values[:3] {
+			v, err := parseNumber(value, 255)
+			if err != nil || v < 0 || v > 255 {
+				return color.RGBA{}, fmt.Errorf("Invalid color %q, channels should be between 0 and 255 or 0%% and 100%%.", s)
+			}
+			channels[n] = uint8(math.Round(v))
+		}
+		c = color.RGBA{channels[0], channels[1], channels[2], 0xFF}
+	case "hsl", "hsla":
+		hue, err := strconv.ParseFloat(strings.TrimSuffix(values[0], "deg"), 64)
+		if err != nil || math.IsNaN(hue) || math.IsInf(hue, 0) {
+			return color.RGBA{}, fmt.Errorf("Invalid color %q, hue should be in degrees.", s)
+		}
+		saturation, errS := parsePercent(values[1])
+		lightness, errL := parsePercent(values[2])
+		if errS != nil || errL != nil || saturation < 0 || saturation > 1 || lightness < 0 || lightness > 1 {
+			return color.RGBA{}, fmt.Errorf("Invalid color %q, saturation and lightness should be between 0%% and 100%%.", s)
+		}
+		c = HSL(hue, saturation, lightness)
+	default:
+		return color.RGBA{}, fmt.Errorf("Invalid color %q, expected hex, rgb() or hsl().", s)
+	}
+
+	c.A = uint8(math.Round(alpha * 255))
+	return c, nil
+}
+
+// HSL converts a hue in degrees and saturation and lightness between 0 and
+// 1 to an opaque color.
+func HSL(hue, saturation, lightness float64) color.RGBA {
+	chroma := (1 - math.Abs(2*lightness-1)) * saturation
+	h := math.Mod(math.Mod(hue, 360)+360, 360) / 60
+	x := chroma * (1 - math.Abs(math.Mod(h, 2)-1))
+
+	var r, g, b float64
+	switch {
+	case h < 1:
+		r, g, b = chroma, x, 0
+	case h < 2:
+		r, g, b = x, chroma, 0
+	case h < 3:
+		r, g, b = 0, chroma, x
+	case h < 4:
+		r, g, b = 0, x, chroma
+	case h < 5:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+
+	m := lightness - chroma/2
+	return color.RGBA{
+		R: uint8(math.Round((r + m) * 255)),
+		G: uint8(math.Round((g + m) * 255)),
+		B: uint8(math.Round((b + m) * 255)),
+		A: 0xFF,
+	}
+}
+
+// splitArgs splits "1, 2, 3" or "1 2 3 / 0.5" into its arguments.
+func splitArgs(args string) ([]string, error) {
+	var alpha string
+	if before, after, ok := strings.Cut(args, "/"); ok {
+		if strings.Contains(before, ",") {
+			return nil, fmt.Errorf("mixed separators")
+		}
+		args, alpha = before, strings.TrimSpace(after)
+	}
+
+	var values []string
+	if strings.Contains(args, ",") {
+		for _, value := range strings.Split(args, ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+	} else {
+		values = strings.Fields(args)
+	}
+	if alpha != "" {
+		values = append(values, alpha)
+	}
+
+	for _, value := range values {
+		if value == "" {
+			return nil, fmt.Errorf("empty argument")
+		}
+	}
+	return values, nil
+}
+
+// parseNumber reads a plain number, or a percentage of full.
+func parseNumber(value string, full float64) (float64, error) {
+	if strings.HasSuffix(value, "%") {
+		percent, err := parsePercent(value)
+		return percent * full, err
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return v, nil
+}
+
+// parsePercent reads "40%" as 0.4. The percent sign is required.
+func parsePercent(value string) (float64, error) {
+	number, ok := strings.CutSuffix(value, "%")
+	if !ok {
+		return 0, fmt.Errorf("missing %% in %q", value)
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid percentage %q", value)
+	}
+	return v / 100, nil
+}
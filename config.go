@@ -0,0 +1,295 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	minSize int
+	maxSize int
+	strict  bool
+
+	maxPixels     int
+	maxTextLength int
+	denylist      []string
+	denylistFile  string
+	denylistMode  string
+
+	collectionsFile string
+	layoutCacheSize int
+	fontFallbacks   []string
+	templatesDir    string
+	palettesFile    string
+	watchAssets     bool
+	defaultText     string
+	templateScripts bool
+	scriptTimeout   time.Duration
+
+	fetchAllowedHosts []string
+	fetchMaxBytes     int64
+	fetchTimeout      time.Duration
+	fetchCacheSize    int
+	fetchCacheBytes   int64
+	fetchAllowPrivate bool
+	logoPresets       []string
+
+	photosDir       string
+	photoCacheSize  int
+	photoCacheBytes int64
+
+	avifQuality int
+	avifSpeed   int
+
+	ffmpegPath string
+
+	pngCompression string
+	serviceName    string
+	deterministic  bool
+	metadataTime   bool
+
+	cacheBackend   string
+	cacheDir       string
+	cacheMaxBytes  int64
+	cacheEndpoint  string
+	cacheRegion    string
+	cacheBucket    string
+	cachePrefix    string
+	cacheAccessKey string
+	cacheSecretKey string
+
+	rateLimitRPS   float64
+	rateLimitBurst int
+
+	renderConcurrency  int
+	renderQueue        int
+	renderQueueTimeout time.Duration
+	renderTimeout      time.Duration
+	diagnosticHeaders  bool
+
+	prewarmFile string
+
+	workerQueue       string
+	workerEvents      string
+	workerConcurrency int
+	workerPrefix      string
+	workerAccessKey   string
+	workerSecretKey   string
+
+	securityHeaders       bool
+	contentSecurityPolicy string
+	referrerPolicy        string
+	frameAncestors        []string
+
+	corsOrigins               []string
+	corsMaxAge                time.Duration
+	crossOriginResourcePolicy string
+
+	chaos bool
+
+	adminToken   string
+	auditLogFile string
+
+	pprof bool
+
+	apiKeys        []string
+	apiKeysFile    string
+	apiKeyRequired bool
+	apiQuotaWindow time.Duration
+	publicMaxSize  int
+
+	urlSigningKey string
+	publicURL     string
+
+	tenantsFile string
+
+	addrs []string
+
+	ginMode        string
+	accessLog      string
+	trustedProxies []string
+
+	tlsCertFile      string
+	tlsKeyFile       string
+	autocertDomains  []string
+	autocertCacheDir string
+	autocertEmail    string
+	autocertHTTPAddr string
+}
+
+var config = loadConfig()
+
+func loadConfig() Config {
+	return Config{
+		minSize: envInt("MIN_SIZE", 150),
+		maxSize: envInt("MAX_SIZE", 3000),
+		strict:  envBool("STRICT", false),
+
+		maxPixels:     envInt("MAX_PIXELS", 1<<25),
+		maxTextLength: envInt("MAX_TEXT_LENGTH", 1000),
+		denylist:      envList("DENYLIST"),
+		denylistFile:  os.Getenv("DENYLIST_FILE"),
+		denylistMode:  envString("DENYLIST_MODE", "mask"),
+
+		collectionsFile: os.Getenv("COLLECTIONS_FILE"),
+		layoutCacheSize: envInt("LAYOUT_CACHE_SIZE", 1024),
+		fontFallbacks:   envList("FONT_FALLBACKS"),
+		templatesDir:    os.Getenv("TEMPLATES_DIR"),
+		palettesFile:    os.Getenv("PALETTES_FILE"),
+		watchAssets:     envBool("WATCH_ASSETS", false),
+		defaultText:     os.Getenv("DEFAULT_TEXT"),
+		templateScripts: envBool("TEMPLATE_SCRIPTS", false),
+		scriptTimeout:   envDuration("SCRIPT_TIMEOUT", 50*time.Millisecond),
+
+		fetchAllowedHosts: envList("FETCH_ALLOWED_HOSTS"),
+		fetchMaxBytes:     int64(envInt("FETCH_MAX_BYTES", 5<<20)),
+		fetchTimeout:      envDuration("FETCH_TIMEOUT", 5*time.Second),
+		fetchCacheSize:    envInt("FETCH_CACHE_SIZE", 64),
+		fetchCacheBytes:   int64(envInt("FETCH_CACHE_BYTES", 128<<20)),
+		fetchAllowPrivate: envBool("FETCH_ALLOW_PRIVATE", false),
+		logoPresets:       envList("LOGO_PRESETS"),
+
+		photosDir:       os.Getenv("PHOTOS_DIR"),
+		photoCacheSize:  envInt("PHOTO_CACHE_SIZE", 16),
+		photoCacheBytes: int64(envInt("PHOTO_CACHE_BYTES", 256<<20)),
+
+		avifQuality: envInt("AVIF_QUALITY", 60),
+		avifSpeed:   envInt("AVIF_SPEED", 8),
+
+		ffmpegPath: envString("FFMPEG_PATH", "ffmpeg"),
+
+		pngCompression: envString("PNG_COMPRESSION", "default"),
+		serviceName:    envString("SERVICE_NAME", "placeholder"),
+		deterministic:  envBool("DETERMINISTIC", false),
+		metadataTime:   envBool("METADATA_TIME", false),
+
+		cacheBackend:   envString("CACHE_BACKEND", ternary(os.Getenv("CACHE_DIR") != "", "disk", "")),
+		cacheDir:       os.Getenv("CACHE_DIR"),
+		cacheMaxBytes:  int64(envInt("CACHE_MAX_BYTES", 1<<30)),
+		cacheEndpoint:  os.Getenv("CACHE_ENDPOINT"),
+		cacheRegion:    envString("CACHE_REGION", "us-east-1"),
+		cacheBucket:    os.Getenv("CACHE_BUCKET"),
+		cachePrefix:    envString("CACHE_PREFIX", "cache/"),
+		cacheAccessKey: os.Getenv("CACHE_ACCESS_KEY"),
+		cacheSecretKey: os.Getenv("CACHE_SECRET_KEY"),
+
+		rateLimitRPS:   envFloat("RATE_LIMIT_RPS", 0),
+		rateLimitBurst: envInt("RATE_LIMIT_BURST", 20),
+
+		renderConcurrency:  envInt("RENDER_CONCURRENCY", 0),
+		renderQueue:        envInt("RENDER_QUEUE", 64),
+		renderQueueTimeout: envDuration("RENDER_QUEUE_TIMEOUT", 10*time.Second),
+		renderTimeout:      envDuration("RENDER_TIMEOUT", 10*time.Second),
+		diagnosticHeaders:  envBool("DIAGNOSTIC_HEADERS", false),
+
+		prewarmFile: os.Getenv("PREWARM_FILE"),
+
+		workerQueue:       os.Getenv("WORKER_QUEUE"),
+		workerEvents:      os.Getenv("WORKER_EVENTS"),
+		workerConcurrency: envInt("WORKER_CONCURRENCY", 0),
+		workerPrefix:      envString("WORKER_PREFIX", "renders/"),
+		workerAccessKey:   envString("WORKER_ACCESS_KEY", os.Getenv("CACHE_ACCESS_KEY")),
+		workerSecretKey:   envString("WORKER_SECRET_KEY", os.Getenv("CACHE_SECRET_KEY")),
+
+		securityHeaders:       envBool("SECURITY_HEADERS", true),
+		contentSecurityPolicy: envString("CONTENT_SECURITY_POLICY", "default-src 'self'; img-src 'self' data: blob:; style-src 'self' 'unsafe-inline'"),
+		referrerPolicy:        envString("REFERRER_POLICY", "strict-origin-when-cross-origin"),
+		frameAncestors:        envList("FRAME_ANCESTORS"),
+
+		corsOrigins:               envList("CORS_ORIGINS"),
+		corsMaxAge:                envDuration("CORS_MAX_AGE", 10*time.Minute),
+		crossOriginResourcePolicy: envString("CROSS_ORIGIN_RESOURCE_POLICY", "cross-origin"),
+
+		chaos: envBool("CHAOS", false),
+
+		adminToken:   os.Getenv("ADMIN_TOKEN"),
+		auditLogFile: os.Getenv("AUDIT_LOG_FILE"),
+
+		pprof: envBool("PPROF", false),
+
+		apiKeys:        envList("API_KEYS"),
+		apiKeysFile:    os.Getenv("API_KEYS_FILE"),
+		apiKeyRequired: envBool("API_KEY_REQUIRED", false),
+		apiQuotaWindow: envDuration("API_QUOTA_WINDOW", 24*time.Hour),
+		publicMaxSize:  envInt("PUBLIC_MAX_SIZE", 0),
+
+		urlSigningKey: os.Getenv("URL_SIGNING_KEY"),
+		publicURL:     os.Getenv("PUBLIC_URL"),
+
+		tenantsFile: os.Getenv("TENANTS_FILE"),
+
+		addrs: listenAddrs(),
+
+		ginMode:        envString("GIN_MODE", "release"),
+		accessLog:      envString("ACCESS_LOG", "text"),
+		trustedProxies: envList("TRUSTED_PROXIES"),
+
+		tlsCertFile:      os.Getenv("TLS_CERT_FILE"),
+		tlsKeyFile:       os.Getenv("TLS_KEY_FILE"),
+		autocertDomains:  envList("AUTOCERT_DOMAINS"),
+		autocertCacheDir: envString("AUTOCERT_CACHE_DIR", "autocert"),
+		autocertEmail:    os.Getenv("AUTOCERT_EMAIL"),
+		autocertHTTPAddr: envString("AUTOCERT_HTTP_ADDR", ":80"),
+	}
+}
+
+// listenAddrs reads LISTEN, or else the PORT that hosting platforms set.
+// Without either, ENVIRONMENT=production still listens on 8080 as it
+// always has, and Let's Encrypt certificates are served on 443.
+func listenAddrs() []string {
+	if addrs := envList("LISTEN"); len(addrs) > 0 {
+		return addrs
+	}
+	port := ternary(os.Getenv("ENVIRONMENT") == "production", "8080", "3000")
+	if len(envList("AUTOCERT_DOMAINS")) > 0 {
+		port = "443"
+	}
+	return []string{":" + envString("PORT", port)}
+}
+
+func envString(key string, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func envInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func envFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func envDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func envBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestListenAddrs(t *testing.T) {
+	for _, test := range []struct {
+		listen, port, environment, domains string
+		want                               []string
+	}{
+		{want: []string{":3000"}},
+		{environment: "production", want: []string{":8080"}},
+		{domains: "example.com", environment: "production", want: []string{":443"}},
+		{domains: "example.com", port: "8443", want: []string{":8443"}},
+		{port: "9000", environment: "production", want: []string{":9000"}},
+		{listen: "127.0.0.1:4000, /run/placeholder.sock", port: "9000", want: []string{"127.0.0.1:4000", "/run/placeholder.sock"}},
+	} {
+		for key, value := range map[string]string{"LISTEN": test.listen, "PORT": test.port, "ENVIRONMENT": test.environment, "AUTOCERT_DOMAINS": test.domains} {
+			t.Setenv(key, value)
+		}
+		if test.port == "" {
+			// An empty PORT would be a port of its own. Setenv restores it.
+			os.Unsetenv("PORT")
+		}
+		if got := listenAddrs(); !slices.Equal(got, test.want) {
+			t.Errorf("LISTEN=%q PORT=%q ENVIRONMENT=%q: %q, want %q", test.listen, test.port, test.environment, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"image/color"
+	"math"
+)
+
+// minContrast is the WCAG AA ratio for normal text.
+const minContrast = 4.5
+
+// contrastingColor picks a readable text color for bg: a darker shade of it
+// on light backgrounds and a lighter one on dark backgrounds, or black or
+// white when the shade is not readable enough.
+func contrastingColor(bg color.RGBA) color.RGBA {
+	black, white := color.RGBA{0x00, 0x00, 0x00, 0xFF}, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+
+	// Black and white contrast equally on a luminance of about 0.18.
+	light := luminance(bg) > 0.18
+	shade := mixColors(bg, ternary(light, black, white), ternary(light, 0.65, 0.75))
+	shade.A = 0xFF
+	if contrastRatio(shade, bg) >= minContrast {
+		return shade
+	}
+	return ternary(light, black, white)
+}
+
+// contrastRatio is the WCAG contrast ratio of a and b, from 1 to 21.
+func contrastRatio(a, b color.RGBA) float64 {
+	la, lb := luminance(a), luminance(b)
+	return (math.Max(la, lb) + 0.05) / (math.Min(la, lb) + 0.05)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cors lets the origins in CORS_ORIGINS fetch images from JavaScript and
+// draw them to a canvas without tainting it, and answers preflight
+// requests. Cross-Origin-Resource-Policy is sent to every origin, so pages
+// with Cross-Origin-Embedder-Policy can still use the images in <img>.
+func cors() gin.HandlerFunc {
+	anyOrigin := slices.Contains(config.corsOrigins, "*")
+	maxAge := strconv.Itoa(int(config.corsMaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		if config.crossOriginResourcePolicy != "" {
+			header.Set("Cross-Origin-Resource-Policy", config.crossOriginResourcePolicy)
+		}
+
+		origin := c.GetHeader("Origin")
+		if origin == "" || !(anyOrigin || slices.Contains(config.corsOrigins, origin)) {
+			c.Next()
+			return
+		}
+		if anyOrigin {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Add("Vary", "Origin")
+		}
+		header.Set("Access-Control-Expose-Headers", "Retry-After, X-Quota-Limit, X-Quota-Remaining, X-Cache, X-Render-Time, X-Image-Bytes, Server-Timing, X-Placeholder-Bg")
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, DELETE")
+			header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
+			header.Set("Access-Control-Max-Age", maxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/vector"
+)
+
+func (i *Image) setBoxStyle(style string) {
+	i.boxStyle = ternary(style == "cross", "cross", "plain")
+}
+
+// drawCross draws the classic wireframe image box, a border with both
+// diagonals, in the text color.
+func drawCross(img *image.RGBA, fg color.RGBA) {
+	width, height := float32(img.Rect.Dx()), float32(img.Rect.Dy())
+	thickness := float32(math.Max(1, math.Min(float64(width), float64(height))/200))
+
+	rasterizer := vector.NewRasterizer(img.Rect.Dx(), img.Rect.Dy())
+	strokeLine(rasterizer, 0, 0, width, height, thickness)
+	strokeLine(rasterizer, width, 0, 0, height, thickness)
+	rasterizer.Draw(img, img.Rect, &image.Uniform{fg}, image.Point{})
+
+	border := int(math.Ceil(float64(thickness)))
+	ink := &image.Uniform{fg}
+	bounds := img.Bounds()
+	draw.Draw(img, image.Rect(0, 0, bounds.Dx(), border), ink, image.Point{}, draw.Over)
+	draw.Draw(img, image.Rect(0, bounds.Dy()-border, bounds.Dx(), bounds.Dy()), ink, image.Point{}, draw.Over)
+	draw.Draw(img, image.Rect(0, 0, border, bounds.Dy()), ink, image.Point{}, draw.Over)
+	draw.Draw(img, image.Rect(bounds.Dx()-border, 0, bounds.Dx(), bounds.Dy()), ink, image.Point{}, draw.Over)
+}
+
+// strokeLine adds a line of the given thickness to the rasterizer's path.
+func strokeLine(rasterizer *vector.Rasterizer, x0, y0, x1, y1, thickness float32) {
+	dx, dy := x1-x0, y1-y0
+	length := float32(math.Hypot(float64(dx), float64(dy)))
+	if length == 0 {
+		return
+	}
+
+	// Offset both ends perpendicular to the line to get a quad.
+	nx, ny := -dy/length*thickness/2, dx/length*thickness/2
+	rasterizer.MoveTo(x0+nx, y0+ny)
+	rasterizer.LineTo(x1+nx, y1+ny)
+	rasterizer.LineTo(x1-nx, y1-ny)
+	rasterizer.LineTo(x0-nx, y0-ny)
+	rasterizer.ClosePath()
+}
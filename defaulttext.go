@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// defaultText renders the text of images without ?text=.
+var defaultText = parseDefaultText(config.defaultText)
+
+// TextContext is what the DEFAULT_TEXT template can use.
+type TextContext struct {
+	Width  int
+	Height int
+	Ratio  string
+	Format string
+	DPI    float64
+	Seed   string
+}
+
+func parseDefaultText(text string) *template.Template {
+	fallback := template.Must(template.New("default").Parse("{{.Width}}x{{.Height}}"))
+	if text == "" {
+		return fallback
+	}
+	parsed, err := template.New("default").Parse(text)
+	if err != nil {
+		log.Printf("Failed to parse DEFAULT_TEXT, using WxH: %v", err)
+		return fallback
+	}
+	return parsed
+}
+
+func (i *Image) textContext() TextContext {
+	return TextContext{
+		Width:  i.width,
+		Height: i.height,
+		Ratio:  aspectRatio(i.width, i.height),
+		Format: i.format,
+		DPI:    i.resolution(),
+		Seed:   i.seed,
+	}
+}
+
+// renderDefaultText falls back to WxH when the template fails, so a bad
+// template never breaks rendering.
+func (i *Image) renderDefaultText() string {
+	var text strings.Builder
+	if err := defaultText.Execute(&text, i.textContext()); err != nil {
+		return fmt.Sprintf("%dx%d", i.width, i.height)
+	}
+	return shapeText(text.String())
+}
+
+// expandTokens replaces {w}, {h}, {ratio} and {format} in user text. {{
+// and }} are literal braces, and unknown tokens are kept as they are.
+func (i *Image) expandTokens(text string) string {
+	if !strings.ContainsAny(text, "{}") {
+		return text
+	}
+	tokens := map[string]string{
+		"w":      strconv.Itoa(i.width),
+		"h":      strconv.Itoa(i.height),
+		"ratio":  aspectRatio(i.width, i.height),
+		"format": i.format,
+	}
+
+	var expanded strings.Builder
+	for len(text) > 0 {
+		switch {
+		case strings.HasPrefix(text, "{{"), strings.HasPrefix(text, "}}"):
+			expanded.WriteByte(text[0])
+			text = text[2:]
+		case text[0] == '{':
+			name, rest, ok := strings.Cut(text[1:], "}")
+			if value, known := tokens[name]; ok && known {
+				expanded.WriteString(value)
+				text = rest
+				continue
+			}
+			expanded.WriteByte('{')
+			text = text[1:]
+		default:
+			expanded.WriteByte(text[0])
+			text = text[1:]
+		}
+	}
+	return expanded.String()
+}
+
+// aspectRatio reduces width and height, so 1920x1080 is "16:9".
+func aspectRatio(width, height int) string {
+	a, b := width, height
+	for b != 0 {
+		a, b = b, a%b
+	}
+	if a == 0 {
+		return "0:0"
+	}
+	return fmt.Sprintf("%d:%d", width/a, height/a)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+
+	colors "github.com/gitkumi/placeholder/color"
+)
+
+// imageDescription is what ?format=json returns: the parameters after
+// defaults, clamping and validation, as the renderer would use them.
+type imageDescription struct {
+	Width            int      `json:"width"`
+	Height           int      `json:"height"`
+	Text             string   `json:"text"`
+	TextPosition     string   `json:"textPos"`
+	FontSize         float64  `json:"fontSize"`
+	FontWeight       string   `json:"fontWeight"`
+	Hinting          string   `json:"hinting"`
+	Supersample      int      `json:"supersample"`
+	FontStyle        string   `json:"fontStyle"`
+	LineHeight       float64  `json:"lineHeight,omitempty"`
+	MaxLines         int      `json:"maxLines,omitempty"`
+	Ellipsis         bool     `json:"ellipsis"`
+	Hyphens          bool     `json:"hyphens"`
+	Background       string   `json:"bg"`
+	Foreground       string   `json:"fg"`
+	ColorErrors      []string `json:"colorErrors,omitempty"`
+	Seed             string   `json:"seed,omitempty"`
+	Identicon        bool     `json:"identicon"`
+	Direction        string   `json:"dir"`
+	Orientation      string   `json:"orientation"`
+	TextRotate       float64  `json:"textRotate"`
+	Watermark        string   `json:"watermark,omitempty"`
+	WatermarkOpacity float64  `json:"watermarkOpacity,omitempty"`
+	Ribbon           string   `json:"ribbon,omitempty"`
+	RibbonColor      string   `json:"ribbonColor,omitempty"`
+	RibbonPosition   string   `json:"ribbonPos,omitempty"`
+	RibbonZ          string   `json:"ribbonZ,omitempty"`
+	Noise            float64  `json:"noise"`
+	Style            string   `json:"style"`
+	Grid             string   `json:"grid,omitempty"`
+	Logo             string   `json:"logo,omitempty"`
+	LogoPosition     string   `json:"logoPos,omitempty"`
+	LogoScale        float64  `json:"logoScale,omitempty"`
+	Filters          string   `json:"filter,omitempty"`
+	Overlays         []string `json:"overlay,omitempty"`
+	Guides           []string `json:"guides,omitempty"`
+	DPI              float64  `json:"dpi"`
+	PageWidth        float64  `json:"pageWidth,omitempty"`
+	PageHeight       float64  `json:"pageHeight,omitempty"`
+	Reproducible     bool     `json:"reproducible"`
+	Markup           bool     `json:"markup"`
+	Compression      string   `json:"compression"`
+	Meta             bool     `json:"meta"`
+	Animation        string   `json:"anim,omitempty"`
+	FPS              int      `json:"fps,omitempty"`
+	Frames           int      `json:"frames,omitempty"`
+	Colors           int      `json:"colors,omitempty"`
+
+	// Texts are the blocks besides text.
+	Texts []TextBlock `json:"texts,omitempty"`
+	// Layers are the plugin layers.
+	Layers []string `json:"layers,omitempty"`
+	// Tenant is the tenant whose defaults apply.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func (i *Image) describe() imageDescription {
+	description := imageDescription{
+		Width:            i.width,
+		Height:           i.height,
+		Text:             i.text,
+		TextPosition:     i.textPosition,
+		Texts:            i.blocks,
+		Layers:           i.pluginNames,
+		Tenant:           i.tenant,
+		FontSize:         i.fontSize,
+		FontWeight:       i.fontWeight,
+		Hinting:          i.hinting,
+		Supersample:      i.supersample,
+		FontStyle:        i.fontStyle,
+		LineHeight:       i.lineHeight,
+		MaxLines:         i.maxLines,
+		Ellipsis:         i.ellipsis,
+		Hyphens:          i.hyphens,
+		Background:       colors.Hex(i.bg),
+		Foreground:       colors.Hex(i.fg),
+		ColorErrors:      i.colorErrors,
+		Seed:             i.seed,
+		Identicon:        i.identicon,
+		Direction:        i.direction,
+		Orientation:      i.orientation,
+		TextRotate:       i.textRotate,
+		Watermark:        i.watermark.text,
+		WatermarkOpacity: i.watermark.opacity,
+		Ribbon:           i.ribbon.text,
+		RibbonPosition:   i.ribbon.position,
+		Noise:            i.noise,
+		Style:            i.boxStyle,
+		Logo:             i.logo,
+		LogoPosition:     i.logoPosition,
+		LogoScale:        i.logoScale,
+		Filters:          i.filters.String(),
+		Overlays:         i.overlays,
+		Guides:           i.guides,
+		DPI:              i.resolution(),
+		Reproducible:     i.reproducible,
+		Markup:           i.markup,
+		Compression:      i.compression,
+		Meta:             i.meta,
+		Animation:        i.anim,
+		FPS:              i.fps,
+		Frames:           i.frameCount,
+		Colors:           i.colors,
+	}
+	if i.columns > 0 {
+		description.Grid = fmt.Sprintf("%dx%d", i.columns, i.rows)
+	}
+	if i.ribbon.text != "" {
+		description.RibbonColor = colors.Hex(i.ribbon.color)
+		description.RibbonZ = ternary(i.ribbon.below, "below", "above")
+	}
+	if i.pageWidth > 0 {
+		description.PageWidth, description.PageHeight = i.pageSize()
+	}
+	return description
+}
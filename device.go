@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/vector"
+)
+
+// Device describes a frame drawn around a screen of width x height pixels.
+// Phones and tablets get a bezel, browsers a title and address bar.
+type Device struct {
+	width        int
+	height       int
+	kind         string
+	bezel        int
+	radius       float32
+	screenRadius float32
+	// camera is "island", "punch" or empty.
+	camera string
+}
+
+var devices = map[string]Device{
+	"iphone-15":  {width: 393, height: 852, kind: "phone", bezel: 14, radius: 62, screenRadius: 50, camera: "island"},
+	"pixel-8":    {width: 412, height: 915, kind: "phone", bezel: 14, radius: 50, screenRadius: 38, camera: "punch"},
+	"ipad":       {width: 820, height: 1180, kind: "tablet", bezel: 28, radius: 44, screenRadius: 18},
+	"desktop":    {width: 1440, height: 900, kind: "browser", radius: 10},
+	"browser":    {width: 1280, height: 720, kind: "browser", radius: 10},
+	"mobile-web": {width: 390, height: 664, kind: "browser", radius: 10},
+}
+
+// browserChrome is the height of the title bar, browserPadding the width of
+// the window border.
+const browserChrome, browserPadding = 40, 1
+
+var (
+	frameColor    = color.RGBA{0x1C, 0x1C, 0x1E, 0xFF}
+	chromeColor   = color.RGBA{0xE5, 0xE7, 0xEB, 0xFF}
+	addressColor  = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	trafficLights = []color.RGBA{{0xFF, 0x5F, 0x57, 0xFF}, {0xFE, 0xBC, 0x2E, 0xFF}, {0x28, 0xC8, 0x40, 0xFF}}
+)
+
+// deviceHandler renders a placeholder, sized to the device's screen and
+// styled by the usual query parameters, inside the device frame.
+func deviceHandler(c *gin.Context) {
+	model := c.Param("model")
+	device, ok := devices[model]
+	if !ok {
+		problem(c, http.StatusNotFound, "unknown_device", fmt.Sprintf("Unknown device %q.", model))
+		return
+	}
+
+	c.Set("format", "png")
+	img, err := parseImage(c, fmt.Sprintf("%dx%d", device.width, device.height))
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	// The frame is always a still PNG, with transparent corners.
+	img.format, img.anim = "png", ""
+
+	serveRender(c, renderKey("device|"+model+"|"+img.cacheKey()), "image/png", func(ctx context.Context, w io.Writer) error {
+		defer img.release()
+		if err := img.apply(ctx); err != nil {
+			return err
+		}
+		return img.encodeRGBA(&contextWriter{ctx, w}, device.frame(img.data))
+	})
+}
+
+// frame returns screen drawn inside the device.
+func (d Device) frame(screen *image.RGBA) *image.RGBA {
+	if d.kind == "browser" {
+		return d.browserFrame(screen)
+	}
+
+	bounds := image.Rect(0, 0, d.width+2*d.bezel, d.height+2*d.bezel)
+	img := image.NewRGBA(bounds)
+	fillRoundedRect(img, bounds, d.radius, frameColor)
+
+	screenRect := screen.Bounds().Add(image.Pt(d.bezel, d.bezel))
+	mask := image.NewAlpha(screen.Bounds())
+	fillRoundedRect(mask, mask.Bounds(), d.screenRadius, color.Alpha{0xFF})
+	draw.DrawMask(img, screenRect, screen, image.Point{}, mask, image.Point{}, draw.Over)
+
+	center := float32(bounds.Dx()) / 2
+	top := float32(d.bezel)
+	switch d.camera {
+	case "island":
+		fillRoundedRect(img, image.Rect(int(center)-62, int(top)+11, int(center)+62, int(top)+47), 18, color.RGBA{0, 0, 0, 0xFF})
+	case "punch":
+		fillCircle(img, center, top+24, 12, color.RGBA{0, 0, 0, 0xFF})
+	}
+	return img
+}
+
+func (d Device) browserFrame(screen *image.RGBA) *image.RGBA {
+	width := d.width + 2*browserPadding
+	height := d.height + browserChrome + browserPadding
+	bounds := image.Rect(0, 0, width, height)
+
+	img := image.NewRGBA(bounds)
+	fillRoundedRect(img, bounds, d.radius, chromeColor)
+	for n, light := range trafficLights {
+		fillCircle(img, float32(20+n*20), float32(browserChrome)/2, 6, light)
+	}
+	address := image.Rect(ternary(width > 400, 90, 80), 8, width-ternary(width > 400, 90, 12), browserChrome-8)
+	fillRoundedRect(img, address, 6, addressColor)
+
+	// Only the bottom corners of the page are rounded, by the window.
+	screenRect := screen.Bounds().Add(image.Pt(browserPadding, browserChrome))
+	mask := image.NewAlpha(bounds)
+	fillRoundedRect(mask, bounds.Inset(browserPadding), d.radius-float32(browserPadding), color.Alpha{0xFF})
+	draw.DrawMask(img, screenRect, screen, image.Point{}, mask, screenRect.Min, draw.Over)
+	return img
+}
+
+// fillRoundedRect fills rect, with corners rounded to radius, anti-aliased.
+func fillRoundedRect(dst draw.Image, rect image.Rectangle, radius float32, c color.Color) {
+	bounds := dst.Bounds()
+	rasterizer := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	x0, y0 := float32(rect.Min.X-bounds.Min.X), float32(rect.Min.Y-bounds.Min.Y)
+	x1, y1 := x0+float32(rect.Dx()), y0+float32(rect.Dy())
+
+	// Cubic Bézier handles this far along the tangents approximate a
+	// quarter circle.
+	const kappa = 0.5523
+	k := radius * (1 - kappa)
+	rasterizer.MoveTo(x0+radius, y0)
+	rasterizer.LineTo(x1-radius, y0)
+	rasterizer.CubeTo(x1-k, y0, x1, y0+k, x1, y0+radius)
+	rasterizer.LineTo(x1, y1-radius)
+	rasterizer.CubeTo(x1, y1-k, x1-k, y1, x1-radius, y1)
+	rasterizer.LineTo(x0+radius, y1)
+	rasterizer.CubeTo(x0+k, y1, x0, y1-k, x0, y1-radius)
+	rasterizer.LineTo(x0, y0+radius)
+	rasterizer.CubeTo(x0, y0+k, x0+k, y0, x0+radius, y0)
+	rasterizer.ClosePath()
+	rasterizer.Draw(dst, bounds, &image.Uniform{c}, image.Point{})
+}
+
+func fillCircle(dst draw.Image, cx, cy, radius float32, c color.Color) {
+	rect := image.Rect(int(cx-radius), int(cy-radius), int(cx+radius+0.5), int(cy+radius+0.5))
+	fillRoundedRect(dst, rect, radius, c)
+}
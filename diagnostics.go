@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setDiagnostics sends the cost of a render when DIAGNOSTIC_HEADERS is set:
+// whether the cache had the output, how long it took to get it and its size.
+// Server-Timing shows the time in the network panel of browsers.
+func setDiagnostics(c *gin.Context, cache string, start time.Time, size int) {
+	if !config.diagnosticHeaders {
+		return
+	}
+	milliseconds := strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', 1, 64)
+	c.Header("X-Cache", cache)
+	c.Header("X-Render-Time", milliseconds+"ms")
+	c.Header("X-Image-Bytes", strconv.Itoa(size))
+	c.Header("Server-Timing", "render;dur="+milliseconds)
+}
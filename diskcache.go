@@ -0,0 +1,194 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskCache stores encoded images in a directory, evicting the least recently
+// used files once the directory grows past maxBytes. Recency is kept in the
+// file modification times so it survives restarts. Only files named like
+// cache keys are the cache's, so other files in the directory are left alone.
+type DiskCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	total    int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type diskEntry struct {
+	key  string
+	size int64
+}
+
+func newDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	cache := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	for _, file := range files {
+		name := file.Name()
+		if key, _, ok := strings.Cut(name, "."); ok && isDiskCacheKey(key) && filepath.Ext(name) == ".tmp" {
+			// Left behind by an interrupted write.
+			removeCacheFile(filepath.Join(dir, name))
+			continue
+		}
+		if info, err := file.Info(); err == nil && info.Mode().IsRegular() && isDiskCacheKey(name) {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(a, b int) bool {
+		return infos[a].ModTime().After(infos[b].ModTime())
+	})
+	for _, info := range infos {
+		cache.entries[info.Name()] = cache.order.PushBack(&diskEntry{info.Name(), info.Size()})
+		cache.total += info.Size()
+	}
+
+	cache.mu.Lock()
+	cache.evict()
+	cache.mu.Unlock()
+	return cache, nil
+}
+
+func (d *DiskCache) get(key string) ([]byte, bool) {
+	d.mu.Lock()
+	element, ok := d.entries[key]
+	if ok {
+		d.order.MoveToFront(element)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(d.dir, key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// put writes to a temporary file and renames it into place, so readers never
+// see a partially written image.
+func (d *DiskCache) put(key string, data []byte) error {
+	tmp, err := os.CreateTemp(d.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		removeCacheFile(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		removeCacheFile(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(d.dir, key)); err != nil {
+		removeCacheFile(tmp.Name())
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if element, ok := d.entries[key]; ok {
+		entry := element.Value.(*diskEntry)
+		d.total -= entry.size
+		entry.size = int64(len(data))
+		d.order.MoveToFront(element)
+	} else {
+		d.entries[key] = d.order.PushFront(&diskEntry{key, int64(len(data))})
+	}
+	d.total += int64(len(data))
+	d.evict()
+	return nil
+}
+
+func (d *DiskCache) remove(key string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	element, ok := d.entries[key]
+	if !ok {
+		return false, nil
+	}
+	d.drop(element)
+	return true, nil
+}
+
+func (d *DiskCache) purge() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	count := d.order.Len()
+	for d.order.Len() > 0 {
+		d.drop(d.order.Back())
+	}
+	return count, nil
+}
+
+// drop forgets an entry and deletes its file. Callers must hold the lock.
+func (d *DiskCache) drop(element *list.Element) {
+	entry := element.Value.(*diskEntry)
+	d.order.Remove(element)
+	delete(d.entries, entry.key)
+	d.total -= entry.size
+	removeCacheFile(filepath.Join(d.dir, entry.key))
+}
+
+// evict removes the least recently used files. Callers must hold the lock.
+func (d *DiskCache) evict() {
+	for d.total > d.maxBytes && d.order.Len() > 0 {
+		d.drop(d.order.Back())
+	}
+}
+
+// isDiskCacheKey reports whether name looks like a cache key, the hex of a
+// sha256 sum.
+func isDiskCacheKey(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+	for _, r := range name {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// removeCacheFile deletes a file of the cache. A file that is gone already
+// is fine, but other failures leave files that count against no limit.
+func removeCacheFile(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove %s from the cache: %v", path, err)
+	}
+}
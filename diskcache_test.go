@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiskCacheLeavesOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	key := strings.Repeat("ab", 32)
+	files := map[string]string{
+		key:                          "image",
+		key + ".123.tmp":             "partial",
+		"README":                     "notes",
+		"backup.tmp":                 "other",
+		strings.Repeat("AB", 32):     "upper case",
+		strings.Repeat("ab", 32)[1:]: "short",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache, err := newDiskCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := cache.get(key); !ok || string(data) != "image" {
+		t.Errorf("get(%s) = %q, %v", key, data, ok)
+	}
+	if _, err := os.Stat(filepath.Join(dir, key+".123.tmp")); !os.IsNotExist(err) {
+		t.Error("interrupted write was kept")
+	}
+
+	if count, err := cache.purge(); err != nil || count != 1 {
+		t.Errorf("purge() = %d, %v, want 1", count, err)
+	}
+	for name := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != (name != key && name != key+".123.tmp") {
+			t.Errorf("%s exists = %v after purge", name, exists)
+		}
+	}
+}
+
+func TestDiskCacheEvicts(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := newDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{strings.Repeat("1", 64), strings.Repeat("2", 64), strings.Repeat("3", 64)}
+	for _, key := range keys[:2] {
+		if err := cache.put(key, bytes.Repeat([]byte{1}, 4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Using the first makes the second the least recently used.
+	cache.get(keys[0])
+	if err := cache.put(keys[2], bytes.Repeat([]byte{1}, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, false, true} {
+		if _, ok := cache.get(keys[i]); ok != want {
+			t.Errorf("get(key %d) = %v, want %v", i, ok, want)
+		}
+		if _, err := os.Stat(filepath.Join(dir, keys[i])); (err == nil) != want {
+			t.Errorf("file of key %d exists = %v, want %v", i, err == nil, want)
+		}
+	}
+
+	// The files are found again after a restart.
+	reopened, err := newDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.total != 8 || len(reopened.entries) != 2 {
+		t.Errorf("reopened with %d entries of %d bytes, want 2 of 8", len(reopened.entries), reopened.total)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorImage is the PNG served in place of a failed render. It is drawn
+// once and small, so an <img> shows that something went wrong instead of
+// the browser's broken image icon.
+var errorImage = sync.OnceValue(func() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 150, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0xF2, 0xDE, 0xDE, 0xFF}}, image.Point{}, draw.Src)
+	if regularFontErr == nil {
+		drawRegion(img, textRegion{image.Rect(0, 36, 150, 72), 24, 1, "center"}, "error", regularFont, color.RGBA{0xA9, 0x44, 0x42, 0xFF})
+	}
+	var buf bytes.Buffer
+	pngEncoder.Encode(&buf, img)
+	return buf.Bytes()
+})
+
+// wantsErrorImage reports whether a failed render should respond with the
+// error image rather than JSON. ?onerror=image or ?onerror=json decide,
+// and otherwise <img> requests, which accept images first, get the image.
+func wantsErrorImage(c *gin.Context) bool {
+	switch c.Query("onerror") {
+	case "image":
+		return true
+	case "json":
+		return false
+	}
+	return strings.HasPrefix(c.GetHeader("Accept"), "image/")
+}
+
+// respondError responds to a failed render with status and either the
+// error image or a problem.
+func respondError(c *gin.Context, status int, code, message string) {
+	if wantsErrorImage(c) {
+		c.Data(status, "image/png", errorImage())
+		return
+	}
+	problem(c, status, code, message)
+}
+
+// recoverRender turns a panic in a render route into a failed render, so
+// the client gets the error image or JSON like for any other failure.
+func recoverRender(c *gin.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Render panicked: %v\n%s", err, debug.Stack())
+			if c.Writer.Written() {
+				abortResponse(c)
+				return
+			}
+			c.Abort()
+			respondError(c, http.StatusInternalServerError, "render_failed", "Failed to create an image.")
+		}
+	}()
+	c.Next()
+}
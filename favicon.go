@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// icoSizes are the sizes bundled into favicon.ico.
+var icoSizes = []int{16, 32, 48}
+
+// faviconSizes are the PNG sizes browsers and platforms ask for.
+var faviconSizes = map[int]bool{16: true, 32: true, 48: true, 64: true, 96: true, 128: true, 180: true, 192: true, 256: true, 512: true}
+
+// appleTouchIconSize is the size iOS uses for home screen icons.
+const appleTouchIconSize = 180
+
+type Favicon struct {
+	// size is 0 for a multi-size ICO.
+	size int
+	icon Avatar
+}
+
+// faviconHandler serves a multi-size ICO, or a PNG when ?size= is given.
+func faviconHandler(c *gin.Context) {
+	size := 0
+	if value := c.Query("size"); value != "" {
+		size, _ = strconv.Atoi(value)
+		if !faviconSizes[size] {
+			problem(c, http.StatusBadRequest, "invalid_size", "Size should be one of 16, 32, 48, 64, 96, 128, 180, 192, 256 or 512.")
+			return
+		}
+	}
+	serveFavicon(c, size, false)
+}
+
+// appleTouchIconHandler serves a 180x180 PNG. iOS draws its own rounded
+// corners, so the icon is always an opaque square.
+func appleTouchIconHandler(c *gin.Context) {
+	serveFavicon(c, appleTouchIconSize, true)
+}
+
+func serveFavicon(c *gin.Context, size int, opaque bool) {
+	text := []rune(c.DefaultQuery("text", "?"))
+	if len(text) > 2 {
+		text = text[:2]
+	}
+	bg, fg := seedColors(string(text))
+	favicon := &Favicon{
+		size: size,
+		icon: Avatar{
+			initials:  shapeText(string(text)),
+			bg:        parseColor(c.Query("bg"), bg),
+			fg:        parseColor(c.Query("fg"), fg),
+			fontScale: ternary(len(text) == 1, 0.7, 0.5),
+		},
+	}
+	if !opaque {
+		favicon.icon.circle, _ = strconv.ParseBool(c.Query("circle"))
+	}
+
+	serveRender(c, favicon.cacheKey(), ternary(size == 0, "image/x-icon", "image/png"), favicon.render)
+}
+
+func (f *Favicon) cacheKey() string {
+	spec := fmt.Sprintf("favicon|%d|%q|%v|%v|%v", f.size, f.icon.initials, f.icon.bg, f.icon.fg, f.icon.circle)
+	return renderKey(spec)
+}
+
+func (f *Favicon) render(ctx context.Context, w io.Writer) error {
+	if f.size > 0 {
+		icon := f.icon
+		icon.size = f.size
+		return icon.render(ctx, w)
+	}
+
+	// Each size is drawn separately rather than scaled down, so small icons
+	// get hinted text.
+	icons := make([]*image.RGBA, len(icoSizes))
+	for n, size := range icoSizes {
+		icon := f.icon
+		icon.size = size
+		img, err := icon.draw()
+		if err != nil {
+			return err
+		}
+		icons[n] = img
+	}
+	return encodeICO(&contextWriter{ctx, w}, icons)
+}
+
+// encodeICO writes an ICO file with PNG compressed images, which every
+// browser since Windows Vista era supports.
+func encodeICO(w io.Writer, images []*image.RGBA) error {
+	pngs := make([][]byte, len(images))
+	for n, img := range images {
+		buffer := new(bytes.Buffer)
+		if err := pngEncoder.Encode(buffer, img); err != nil {
+			return err
+		}
+		pngs[n] = buffer.Bytes()
+	}
+
+	// The directory needs the size of every image, so they are encoded
+	// before anything is written.
+	buffer := new(bytes.Buffer)
+	// ICONDIR: reserved, type 1 for icons, and the image count.
+	binary.Write(buffer, binary.LittleEndian, [3]uint16{0, 1, uint16(len(images))})
+
+	offset := 6 + 16*len(images)
+	for n, img := range images {
+		// Sizes of 256 are stored as 0.
+		width, height := uint8(img.Rect.Dx()), uint8(img.Rect.Dy())
+		entry := struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{width, height, 0, 0, 1, 32, uint32(len(pngs[n])), uint32(offset)}
+		binary.Write(buffer, binary.LittleEndian, entry)
+		offset += len(pngs[n])
+	}
+	for _, data := range pngs {
+		buffer.Write(data)
+	}
+	_, err := buffer.WriteTo(w)
+	return err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+
+	_ "golang.org/x/image/webp"
+)
+
+// fetchedImages keeps decoded remote images, so a popular logo is only
+// downloaded once.
+var fetchedImages = newSizedLRUCache[string, image.Image](config.fetchCacheSize, config.fetchCacheBytes, imageBytes)
+
+var fetchClient = &http.Client{
+	Timeout: config.fetchTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: config.fetchTimeout,
+			Control: checkFetchAddress,
+		}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return checkFetchURL(req.URL)
+	},
+}
+
+// checkFetchURL only allows http and https URLs on the FETCH_ALLOWED_HOSTS
+// allowlist. An empty allowlist disables fetching.
+func checkFetchURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return badRequest("fetch_not_allowed", "Unsupported URL scheme %q.", u.Scheme)
+	}
+	for _, host := range config.fetchAllowedHosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return nil
+		}
+	}
+	return badRequest("fetch_not_allowed", "Host %q is not allowed.", u.Hostname())
+}
+
+// blockedFetchPrefixes are ranges the checks of net.IP miss: carrier-grade
+// NAT, and NAT64, which reaches IPv4 addresses, private ones included,
+// through IPv6.
+var blockedFetchPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// checkFetchAddress refuses to connect to loopback, private, link local,
+// shared and NAT64 addresses, unless FETCH_ALLOW_PRIVATE is set. It runs
+// after DNS resolution, so an allowed host can't be pointed at the internal
+// network.
+func checkFetchAddress(network, address string, _ syscall.RawConn) error {
+	if config.fetchAllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return fmt.Errorf("address %s is not allowed", address)
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	for _, prefix := range blockedFetchPrefixes {
+		if prefix.Contains(addr.Unmap()) {
+			return fmt.Errorf("address %s is not allowed", address)
+		}
+	}
+	return nil
+}
+
+// imageBytes is about how much memory a decoded image takes.
+func imageBytes(img image.Image) int64 {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return int64(len(img.Pix))
+	case *image.NRGBA:
+		return int64(len(img.Pix))
+	case *image.Gray:
+		return int64(len(img.Pix))
+	case *image.Paletted:
+		return int64(len(img.Pix))
+	case *image.YCbCr:
+		return int64(len(img.Y) + len(img.Cb) + len(img.Cr))
+	}
+	bounds := img.Bounds()
+	return 8 * int64(bounds.Dx()) * int64(bounds.Dy())
+}
+
+// errUpstream marks fetches that failed on the side of the remote server, or
+// on the way to it, rather than this one.
+var errUpstream = errors.New("upstream failed")
+
+func upstreamError(err error) error {
+	return fmt.Errorf("%w: %w", errUpstream, err)
+}
+
+// fetchImage downloads and decodes the image at rawURL, refusing files over
+// FETCH_MAX_BYTES and images larger than the largest canvas.
+func fetchImage(ctx context.Context, rawURL string) (image.Image, error) {
+	if img, ok := fetchedImages.get(rawURL); ok {
+		return img, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFetchURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, upstreamError(fmt.Errorf("fetching %s: %s", u.Redacted(), resp.Status))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, config.fetchMaxBytes+1))
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	if int64(len(data)) > config.fetchMaxBytes {
+		return nil, upstreamError(fmt.Errorf("fetching %s: larger than %d bytes", u.Redacted(), config.fetchMaxBytes))
+	}
+
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	fetchedImages.add(rawURL, img)
+	return img, nil
+}
+
+// decodeImage checks the dimensions in the header before decoding, so a
+// small file can't expand into a huge bitmap.
+func decodeImage(data []byte) (image.Image, error) {
+	header, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if header.Width > config.maxSize || header.Height > config.maxSize {
+		return nil, fmt.Errorf("image is %dx%d, larger than %d pixels", header.Width, header.Height, config.maxSize)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// loadPresets reads name=path pairs, e.g. "acme=logos/acme.png". Files that
+// fail to load are logged and skipped.
+func loadPresets(pairs []string) map[string]image.Image {
+	presets := map[string]image.Image{}
+	for _, pair := range pairs {
+		name, path, ok := strings.Cut(pair, "=")
+		if !ok {
+			log.Printf("Invalid preset %q, expected name=path", pair)
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Failed to read preset %s: %v", name, err)
+			continue
+		}
+		img, err := decodeImage(data)
+		if err != nil {
+			log.Printf("Failed to decode preset %s: %v", name, err)
+			continue
+		}
+		presets[name] = img
+	}
+	return presets
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckFetchAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"::ffff:100.64.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b:1::1", false},
+		{"::1", false},
+	}
+	for _, test := range tests {
+		err := checkFetchAddress("tcp", net.JoinHostPort(test.address, "443"), nil)
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: got %v, want allowed %v", test.address, err, test.allowed)
+		}
+	}
+}
+
+func TestProxyUpstreamFailure(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+	defer func(hosts []string, private bool) {
+		config.fetchAllowedHosts, config.fetchAllowPrivate = hosts, private
+	}(config.fetchAllowedHosts, config.fetchAllowPrivate)
+	config.fetchAllowedHosts, config.fetchAllowPrivate = []string{"127.0.0.1"}, true
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerRoutes(r, nil, nil)
+	w := request(r, http.MethodGet, "/proxy/300x200?src="+url.QueryEscape(upstream.URL+"/missing.png"), "", nil)
+	var body struct{ Code string }
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusBadGateway || body.Code != "upstream_failed" {
+		t.Errorf("missing source got %d %q, want 502 upstream_failed", w.Code, body.Code)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// parallelFillPixels is the canvas size from which fills are split across
+// cores. Below it, starting goroutines costs more than it saves.
+const parallelFillPixels = 1 << 20
+
+// fillBands calls fill with horizontal bands of img that cover it, one per
+// core when the image is large enough. fill must only write to its band and
+// must not depend on the band boundaries, so the output is the same on any
+// number of cores.
+func fillBands(img *image.RGBA, fill func(band *image.RGBA)) {
+	bounds := img.Bounds()
+	workers := runtime.GOMAXPROCS(0)
+	if workers == 1 || bounds.Dx()*bounds.Dy() < parallelFillPixels {
+		fill(img)
+		return
+	}
+
+	height := (bounds.Dy() + workers - 1) / workers
+	var wg sync.WaitGroup
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += height {
+		band := img.SubImage(image.Rect(bounds.Min.X, y, bounds.Max.X, min(y+height, bounds.Max.Y))).(*image.RGBA)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fill(band)
+		}()
+	}
+	wg.Wait()
+}
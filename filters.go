@@ -0,0 +1,227 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	colors "github.com/gitkumi/placeholder/color"
+)
+
+// Filters is a post-processing pipeline, applied in order after everything
+// else is drawn.
+type Filters []filterStep
+
+// filterStep is one stage of the pipeline. spec describes the stage and its
+// arguments for cache keys.
+type filterStep struct {
+	spec  string
+	apply func(img *image.RGBA)
+}
+
+// filterFuncs are the filters that can be chained by name in ?filter=.
+var filterFuncs = map[string]func(img *image.RGBA){
+	"grayscale": grayscale,
+	"sepia":     sepia,
+	"invert":    invert,
+}
+
+func (i *Image) setFilters(filter, brightness, contrast, duotone string) {
+	i.filters = parseFilters(filter, brightness, contrast)
+	if shadow, highlight, ok := parseDuotone(duotone); ok {
+		i.filters = append(i.filters, filterStep{"duotone:" + duotone, func(img *image.RGBA) {
+			duotoneMap(img, shadow, highlight)
+		}})
+	}
+}
+
+// parseFilters chains the comma separated filter names, then the brightness
+// and contrast adjustments. Unknown names and invalid amounts are ignored.
+func parseFilters(filter, brightness, contrast string) Filters {
+	var filters Filters
+	for _, name := range strings.Split(filter, ",") {
+		if apply, ok := filterFuncs[name]; ok {
+			filters = append(filters, filterStep{name, apply})
+		}
+	}
+	if amount, err := strconv.ParseFloat(brightness, 64); err == nil && amount >= 0 && amount != 1 {
+		filters = append(filters, filterStep{"brightness:" + brightness, func(img *image.RGBA) {
+			mapChannels(img, func(v float64) float64 { return v * amount })
+		}})
+	}
+	if amount, err := strconv.ParseFloat(contrast, 64); err == nil && amount >= 0 && amount != 1 {
+		filters = append(filters, filterStep{"contrast:" + contrast, func(img *image.RGBA) {
+			mapChannels(img, func(v float64) float64 { return (v-127.5)*amount + 127.5 })
+		}})
+	}
+	return filters
+}
+
+func (f Filters) apply(img *image.RGBA) {
+	for _, step := range f {
+		step.apply(img)
+	}
+}
+
+func (f Filters) String() string {
+	specs := make([]string, len(f))
+	for n, step := range f {
+		specs[n] = step.spec
+	}
+	return strings.Join(specs, ",")
+}
+
+// grayscale replaces every pixel with its Rec. 709 luma.
+func grayscale(img *image.RGBA) {
+	for offset := 0; offset+3 < len(img.Pix); offset += 4 {
+		r, g, b := int(img.Pix[offset]), int(img.Pix[offset+1]), int(img.Pix[offset+2])
+		y := uint8((2126*r + 7152*g + 722*b) / 10000)
+		img.Pix[offset], img.Pix[offset+1], img.Pix[offset+2] = y, y, y
+	}
+}
+
+// sepia applies the classic sepia tone matrix.
+func sepia(img *image.RGBA) {
+	mapStraight(img, func(r, g, b uint8) (uint8, uint8, uint8) {
+		fr, fg, fb := float64(r), float64(g), float64(b)
+		return clampChannel(0.393*fr + 0.769*fg + 0.189*fb),
+			clampChannel(0.349*fr + 0.686*fg + 0.168*fb),
+			clampChannel(0.272*fr + 0.534*fg + 0.131*fb)
+	})
+}
+
+func invert(img *image.RGBA) {
+	mapStraight(img, func(r, g, b uint8) (uint8, uint8, uint8) {
+		return 255 - r, 255 - g, 255 - b
+	})
+}
+
+// mapStraight runs fn on the color of every pixel without its alpha
+// premultiplied, and premultiplies the result again, so translucent pixels
+// keep colors no brighter than their alpha. Transparent pixels are skipped.
+func mapStraight(img *image.RGBA, fn func(r, g, b uint8) (uint8, uint8, uint8)) {
+	for offset := 0; offset+3 < len(img.Pix); offset += 4 {
+		pix := img.Pix[offset : offset+4 : offset+4]
+		a := uint32(pix[3])
+		switch a {
+		case 0:
+		case 0xFF:
+			pix[0], pix[1], pix[2] = fn(pix[0], pix[1], pix[2])
+		default:
+			r, g, b := fn(unpremultiply(pix[0], a), unpremultiply(pix[1], a), unpremultiply(pix[2], a))
+			pix[0], pix[1], pix[2] = premultiply(r, a), premultiply(g, a), premultiply(b, a)
+		}
+	}
+}
+
+func unpremultiply(v uint8, a uint32) uint8 {
+	return uint8(min((uint32(v)*0xFF+a/2)/a, 0xFF))
+}
+
+func premultiply(v uint8, a uint32) uint8 {
+	return uint8((uint32(v)*a + 0x7F) / 0xFF)
+}
+
+// parseDuotone reads two comma separated hex colors, the shadow and the
+// highlight of the ramp.
+func parseDuotone(value string) (color.RGBA, color.RGBA, bool) {
+	shadowHex, highlightHex, ok := strings.Cut(value, ",")
+	if !ok {
+		return color.RGBA{}, color.RGBA{}, false
+	}
+	shadow, err := colors.ParseHex(shadowHex)
+	if err != nil {
+		return color.RGBA{}, color.RGBA{}, false
+	}
+	highlight, err := colors.ParseHex(highlightHex)
+	if err != nil {
+		return color.RGBA{}, color.RGBA{}, false
+	}
+	return shadow, highlight, true
+}
+
+// duotoneMap replaces every pixel with the color at its luma on a ramp from
+// shadow to highlight.
+func duotoneMap(img *image.RGBA, shadow, highlight color.RGBA) {
+	var ramp [256][3]uint8
+	for y := range ramp {
+		t := float64(y) / 255
+		ramp[y] = [3]uint8{
+			clampChannel(float64(shadow.R) + t*(float64(highlight.R)-float64(shadow.R))),
+			clampChannel(float64(shadow.G) + t*(float64(highlight.G)-float64(shadow.G))),
+			clampChannel(float64(shadow.B) + t*(float64(highlight.B)-float64(shadow.B))),
+		}
+	}
+	mapStraight(img, func(r, g, b uint8) (uint8, uint8, uint8) {
+		y := (2126*int(r) + 7152*int(g) + 722*int(b)) / 10000
+		return ramp[y][0], ramp[y][1], ramp[y][2]
+	})
+}
+
+// mapChannels runs the red, green and blue channels through fn. fn is only
+// evaluated once per channel value.
+func mapChannels(img *image.RGBA, fn func(v float64) float64) {
+	var table [256]uint8
+	for v := range table {
+		table[v] = clampChannel(fn(float64(v)))
+	}
+	mapStraight(img, func(r, g, b uint8) (uint8, uint8, uint8) {
+		return table[r], table[g], table[b]
+	})
+}
+
+func clampChannel(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// blur approximates a gaussian blur with three box blurs of the given
+// radius, each done horizontally and then vertically.
+func blur(img *image.RGBA, radius int) {
+	if radius <= 0 {
+		return
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	line := make([]uint8, 4*ternary(width > height, width, height))
+	for pass := 0; pass < 3; pass++ {
+		for y := 0; y < height; y++ {
+			boxBlurLine(img.Pix[y*img.Stride:], 4, width, radius, line)
+		}
+		for x := 0; x < width; x++ {
+			boxBlurLine(img.Pix[4*x:], img.Stride, height, radius, line)
+		}
+	}
+}
+
+// boxBlurLine blurs count pixels that are step bytes apart with a running
+// sum, clamping at the edges. scratch holds a copy of the line.
+func boxBlurLine(pix []uint8, step, count, radius int, scratch []uint8) {
+	for n := 0; n < count; n++ {
+		copy(scratch[4*n:4*n+4], pix[n*step:n*step+4])
+	}
+
+	window := 2*radius + 1
+	var sum [4]int
+	for n := -radius; n <= radius; n++ {
+		p := 4 * clamp(n, 0, count-1)
+		for c := 0; c < 4; c++ {
+			sum[c] += int(scratch[p+c])
+		}
+	}
+	for n := 0; n < count; n++ {
+		for c := 0; c < 4; c++ {
+			pix[n*step+c] = uint8(sum[c] / window)
+		}
+		outgoing := 4 * clamp(n-radius, 0, count-1)
+		incoming := 4 * clamp(n+radius+1, 0, count-1)
+		for c := 0; c < 4; c++ {
+			sum[c] += int(scratch[incoming+c]) - int(scratch[outgoing+c])
+		}
+	}
+}
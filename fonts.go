@@ -0,0 +1,234 @@
+package main
+
+import (
+	"image"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomediumitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/unicode/norm"
+)
+
+var regularFont, regularFontErr = freetype.ParseFont(goregular.TTF)
+
+// fontKey indexes the font registry.
+type fontKey struct {
+	family string
+	weight string
+	style  string
+}
+
+func (k fontKey) String() string {
+	return k.family + "-" + k.weight + "-" + k.style
+}
+
+// fontRegistry holds the bundled faces. Every family has a regular normal
+// face, which lookupFont falls back to.
+var fontRegistry = registerFonts(map[fontKey][]byte{
+	{"go", "regular", "normal"}: goregular.TTF,
+	{"go", "regular", "italic"}: goitalic.TTF,
+	{"go", "medium", "normal"}:  gomedium.TTF,
+	{"go", "medium", "italic"}:  gomediumitalic.TTF,
+	{"go", "bold", "normal"}:    gobold.TTF,
+	{"go", "bold", "italic"}:    gobolditalic.TTF,
+})
+
+func registerFonts(ttfs map[fontKey][]byte) map[fontKey]*truetype.Font {
+	fonts := map[fontKey]*truetype.Font{}
+	for key, ttf := range ttfs {
+		parsed, err := freetype.ParseFont(ttf)
+		if err != nil {
+			log.Printf("Failed to parse font %s: %v", key, err)
+			continue
+		}
+		fonts[key] = parsed
+	}
+	return fonts
+}
+
+// lookupFont returns the closest registered face: the exact match, then the
+// normal style of the weight, then the regular weight in the style, then
+// regular normal.
+func lookupFont(key fontKey) (*truetype.Font, fontKey) {
+	for _, candidate := range []fontKey{
+		key,
+		{key.family, key.weight, "normal"},
+		{key.family, "regular", key.style},
+		{key.family, "regular", "normal"},
+		{"go", "regular", "normal"},
+	} {
+		if parsed, ok := fontRegistry[candidate]; ok {
+			return parsed, candidate
+		}
+	}
+	return regularFont, fontKey{"go", "regular", "normal"}
+}
+
+// setFontVariant reads ?fontWeight=regular|medium|bold and
+// ?fontStyle=normal|italic.
+func (i *Image) setFontVariant(weight, style string) {
+	i.fontWeight = fontWeight(weight)
+	i.fontStyle = ternary(style == "italic" || style == "oblique", "italic", "normal")
+}
+
+// fontKey returns the face of the text, in the fonts of the tenant when it
+// has some.
+func (i *Image) fontKey() fontKey {
+	return fontKey{ternary(i.fontFamily != "", i.fontFamily, "go"), i.fontWeight, i.fontStyle}
+}
+
+// fontWeight maps ?fontWeight= to a registered weight. CSS numbers are
+// accepted, and anything unknown is regular.
+func fontWeight(value string) string {
+	switch value {
+	case "medium", "bold":
+		return value
+	}
+	if weight, err := strconv.Atoi(value); err == nil {
+		switch {
+		case weight >= 600:
+			return "bold"
+		case weight >= 500:
+			return "medium"
+		}
+	}
+	return "regular"
+}
+
+// fallbackFonts are tried in order for characters missing from the primary
+// font, e.g. Noto Sans for CJK or Noto Emoji.
+var fallbackFonts = loadFonts(config.fontFallbacks)
+
+func loadFonts(paths []string) []*truetype.Font {
+	var fonts []*truetype.Font
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Failed to read font %s: %v", path, err)
+			continue
+		}
+		parsed, err := freetype.ParseFont(data)
+		if err != nil {
+			log.Printf("Failed to parse font %s: %v", path, err)
+			continue
+		}
+		fonts = append(fonts, parsed)
+	}
+	return fonts
+}
+
+// glyphCacheBytes bounds the glyph cache of a face. truetype allocates a
+// mask as large as the biggest glyph for each of its entries up front, so
+// faces of large sizes get fewer entries.
+const glyphCacheBytes = 16 << 20
+
+// glyphBytes returns the size of the glyph masks of parsed at size, the
+// bounds of its largest glyph rounded out to pixels as truetype does.
+func glyphBytes(parsed *truetype.Font, size, dpi float64) int {
+	bounds := parsed.Bounds(fixed.Int26_6(0.5 + size*dpi*64/72))
+	left, right := int(bounds.Min.X)>>6, int(bounds.Max.X+63)>>6
+	top, bottom := -int(bounds.Max.Y)>>6, -int(bounds.Min.Y-63)>>6
+	return (right - left) * (bottom - top)
+}
+
+// withGlyphCache returns options with as many glyph cache entries, up to
+// truetype's default of 512, as fit in glyphCacheBytes.
+func withGlyphCache(parsed *truetype.Font, options *truetype.Options) *truetype.Options {
+	sized := *options
+	dpi := ternary(sized.DPI > 0, sized.DPI, 72)
+	size := ternary(sized.Size > 0, sized.Size, 12)
+	perGlyph := max(glyphBytes(parsed, size, dpi), 1)
+	sized.GlyphCacheEntries = 512
+	for sized.GlyphCacheEntries > 1 && sized.GlyphCacheEntries*perGlyph > glyphCacheBytes {
+		sized.GlyphCacheEntries /= 2
+	}
+	return &sized
+}
+
+// newFace returns a face for the primary font that falls back to the
+// configured fonts for missing characters.
+func newFace(primary *truetype.Font, options *truetype.Options) font.Face {
+	face := truetype.NewFace(primary, withGlyphCache(primary, options))
+	fallbacks := currentFallbackFonts()
+	if len(fallbacks) == 0 {
+		return face
+	}
+
+	chain := &fallbackFace{fonts: []*truetype.Font{primary}, faces: []font.Face{face}}
+	for _, fallback := range fallbacks {
+		chain.fonts = append(chain.fonts, fallback)
+		chain.faces = append(chain.faces, truetype.NewFace(fallback, withGlyphCache(fallback, options)))
+	}
+	return chain
+}
+
+// fallbackFace draws each character with the first font that has a glyph
+// for it. Metrics come from the primary font so lines stay consistent.
+type fallbackFace struct {
+	fonts []*truetype.Font
+	faces []font.Face
+}
+
+func (f *fallbackFace) faceFor(r rune) font.Face {
+	for i, parsed := range f.fonts {
+		if parsed.Index(r) != 0 {
+			return f.faces[i]
+		}
+	}
+	return f.faces[0]
+}
+
+func (f *fallbackFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.faceFor(r).Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.faceFor(r).GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.faceFor(r).GlyphAdvance(r)
+}
+
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	if face := f.faceFor(r0); face == f.faceFor(r1) {
+		return face.Kern(r0, r1)
+	}
+	return 0
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
+
+// shapeText prepares text for glyph lookup. Combining sequences are composed
+// so precomposed glyphs are used, emoji presentation selectors and joiners,
+// which have no glyphs of their own, are dropped, and Arabic letters get
+// their joined forms.
+func shapeText(text string) string {
+	return shapeArabic(strings.Map(func(r rune) rune {
+		switch r {
+		case '\uFE0E', '\uFE0F', '\u200D':
+			return -1
+		}
+		return r
+	}, norm.NFC.String(text)))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The fuzz targets only check that malformed input never panics. Run one
+// with go test -run ^$ -fuzz FuzzImageHandler .
+
+func FuzzSetSize(f *testing.F) {
+	for _, size := range []string{"600x400", "300", "a4", "85x55mm", "8.5inx11in", "x", "0x0", "-1x-1", "1x2x3", "99999999999999999999"} {
+		f.Add(size)
+	}
+	f.Fuzz(func(t *testing.T, size string) {
+		img := &Image{}
+		if err := img.setSize(size); err != nil {
+			return
+		}
+		if img.width < config.minSize || img.width > config.maxSize || img.height < config.minSize || img.height > config.maxSize {
+			t.Errorf("setSize(%q) = %dx%d, outside the configured bounds", size, img.width, img.height)
+		}
+	})
+}
+
+func FuzzParseFontSize(f *testing.F) {
+	for _, size := range []string{"", "24", "0", "-5", "1e309", "NaN", "Inf", "0x10"} {
+		f.Add(size)
+	}
+	f.Fuzz(func(t *testing.T, size string) {
+		parseFontSize(size, 30)
+	})
+}
+
+func FuzzParseColor(f *testing.F) {
+	for _, value := range []string{"", "fff", "#0c79ed", "f0c8", "0000007f", "rgb(1, 2, 3)", "hsl(120 50% 50% / 0.5)", "random", "auto", "#"} {
+		f.Add(value)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		parseColor(value, color.RGBA{0xD4, 0xD4, 0xD4, 0xFF})
+	})
+}
+
+// FuzzImageHandler sends the fuzzed size and query to the image route.
+// Sizes are capped so every input renders quickly.
+func FuzzImageHandler(f *testing.F) {
+	for _, seed := range []struct{ size, query string }{
+		{"600x400", ""},
+		{"300", "text=Hello&fontSize=40&bg=000&fg=fff"},
+		{"a4", "dpi=10&format=json"},
+		{"200x100", "seed=x&identicon=1&filter=blur,grayscale&overlay=ruler"},
+		{"200x100", "markup=1&text=%2A%2Abold%2A%2A%0A%23%20h&lineHeight=2&maxLines=1&ellipsis=1&hyphens=1"},
+		{"200x100", "grid=3x3&guides=thirds&shadow=1,1&outline=2,f00&tracking=-3"},
+		{"200x100", "format=blurhash&noise=1&orientation=vertical&dir=rtl"},
+		{"200x100", "palette=pastel&scheme=dark&fontWeight=900&fontStyle=italic"},
+		{"0", "text=Hello&fontSize=4000&fg=ff="},
+		{"400", "text=Hi&fontSize=3000&text2=Caption&text2Size=1e9"},
+		{"200x100", `texts=[{"text":"Caption","size":5000}]`},
+	} {
+		f.Add(seed.size, seed.query)
+	}
+
+	defer func(maxSize int) { config.maxSize = maxSize }(config.maxSize)
+	config.maxSize = 256
+
+	r := gin.New()
+	r.GET("/:size", imageHandler)
+	f.Fuzz(func(t *testing.T, size, query string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = "/" + size
+		req.URL.RawQuery = query
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code >= http.StatusInternalServerError && w.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET /%s?%s responded %d: %s", size, query, w.Code, w.Body)
+		}
+	})
+}
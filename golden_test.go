@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata/golden")
+
+// Pixels count as different when a channel is off by more than
+// goldenChannelTolerance, and an image fails when more than
+// goldenPixelTolerance of its pixels differ. This lets antialiasing and
+// rounding move without hiding a moved line or a wrong color.
+const (
+	goldenChannelTolerance = 8
+	goldenPixelTolerance   = 0.001
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// goldenSizes and goldenCases are rendered as a matrix, every case at every
+// size, with reproducible=1 so the output doesn't depend on the platform.
+var goldenSizes = []string{"300x200", "200x300"}
+
+var goldenCases = []struct {
+	name  string
+	query string
+}{
+	{"default", ""},
+	{"wrapped", "text=The quick brown fox jumps over the lazy dog&fontSize=28"},
+	{"no-descenders", "text=AAA AAA&fontSize=40"},
+	{"descenders", "text=ggg ggg&fontSize=40"},
+	{"line-height", "text=One two three four&fontSize=40&lineHeight=1.6"},
+	{"ellipsis", "text=The quick brown fox jumps over the lazy dog&fontSize=40&maxLines=2&ellipsis=1"},
+	{"long-word", "text=https://example.com/a/very/long/path&fontSize=28&hyphens=1"},
+	{"colors", "bg=0c79ed&fg=fff"},
+	{"seed", "seed=placeholder"},
+	{"identicon", "seed=placeholder&identicon=1"},
+	{"dark", "scheme=dark"},
+	{"bold-italic", "text=Bold&fontWeight=bold&fontStyle=italic"},
+	{"markup", "markup=1&text=# **Big**\n//quick// fox&fontSize=28"},
+	{"effects", "text=Shadow&shadow=2,2&outline=1,000&tracking=4"},
+	{"supersample", "text=Small text&fontSize=12&hinting=none&supersample=2"},
+	{"rotate", "text=SAMPLE&textRotate=-30"},
+	{"watermark", "text=Mockup&watermark=DRAFT&watermarkOpacity=0.3"},
+	{"ribbon", "text=Preview&ribbon=BETA&ribbonColor=e11"},
+	{"texts", "text=Title&textPos=top&text2=Subtitle&text2Size=18&text2Pos=bottom"},
+	{"vertical", "text=Vertical&orientation=vertical"},
+	{"cross", "style=cross"},
+	{"grid", "grid=2x2"},
+	{"overlays", "overlay=ruler,grid:50&guides=thirds,center"},
+	{"filters", "seed=placeholder&filter=grayscale,invert"},
+	{"duotone", "filter=duotone&duotone=1e3a8a,fbbf24"},
+	{"noise", "noise=0.5"},
+}
+
+// TestGolden compares renders with the images in testdata/golden. Run
+// go test -update . after an intended change to the output and review the
+// new images before committing them.
+func TestGolden(t *testing.T) {
+	for _, size := range goldenSizes {
+		for _, test := range goldenCases {
+			name := fmt.Sprintf("%s-%s", test.name, size)
+			t.Run(name, func(t *testing.T) {
+				got := renderTestImage(t, size, test.query+"&reproducible=1")
+				compareGolden(t, filepath.Join("testdata", "golden", name+".png"), got)
+			})
+		}
+	}
+}
+
+// TestReproducibleBytes checks that reproducible renders encode to the same
+// bytes every time, metadata included.
+func TestReproducibleBytes(t *testing.T) {
+	for _, query := range []string{"", "bg=random", "text=Small&supersample=2&dpi=150", "noise=0.5&colors=16"} {
+		t.Run(query, func(t *testing.T) {
+			first := encodeTestImage(t, "300x200", query+"&reproducible=1")
+			second := encodeTestImage(t, "300x200", query+"&reproducible=1")
+			if !bytes.Equal(first, second) {
+				t.Errorf("renders of %q differ", query)
+			}
+		})
+	}
+}
+
+// encodeTestImage renders and encodes a placeholder.
+func encodeTestImage(t *testing.T, size, query string) []byte {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/"+size, nil)
+	c.Request.URL.RawQuery = encodeTestQuery(query)
+	img, err := parseImage(c, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := img.apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := img.generate(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// renderTestImage renders a placeholder the way imageHandler does.
+func renderTestImage(t *testing.T, size, query string) *image.RGBA {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/"+size, nil)
+	c.Request.URL.RawQuery = encodeTestQuery(query)
+	img, err := parseImage(c, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := img.apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return img.data
+}
+
+// encodeTestQuery escapes the values of a readable query like
+// "text=a b&fontSize=20".
+func encodeTestQuery(query string) string {
+	var pairs []string
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		pairs = append(pairs, name+"="+url.QueryEscape(value))
+	}
+	return strings.Join(pairs, "&")
+}
+
+func compareGolden(t *testing.T, path string, got *image.RGBA) {
+	t.Helper()
+	if *update {
+		writeTestPNG(t, path, got)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v, run go test -update . to create it", err)
+	}
+	defer file.Close()
+	want, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !want.Bounds().Eq(got.Bounds()) {
+		t.Fatalf("size is %v, want %v", got.Bounds(), want.Bounds())
+	}
+
+	bounds := got.Bounds()
+	diff := image.NewRGBA(bounds)
+	different := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if pixelDistance(want.At(x, y), got.At(x, y)) > goldenChannelTolerance {
+				different++
+				diff.Set(x, y, color.RGBA{0xFF, 0x00, 0xFF, 0xFF})
+			} else {
+				diff.Set(x, y, got.At(x, y))
+			}
+		}
+	}
+
+	if ratio := float64(different) / float64(bounds.Dx()*bounds.Dy()); ratio > goldenPixelTolerance {
+		out := filepath.Join(os.TempDir(), "placeholder-golden", filepath.Base(path))
+		writeTestPNG(t, strings.TrimSuffix(out, ".png")+".got.png", got)
+		writeTestPNG(t, strings.TrimSuffix(out, ".png")+".diff.png", diff)
+		t.Errorf("%d pixels (%.2f%%) differ from %s, see %s.diff.png, run go test -update . if the change is intended",
+			different, ratio*100, path, strings.TrimSuffix(out, ".png"))
+	}
+}
+
+// pixelDistance is the largest difference of a channel, in 8 bits.
+func pixelDistance(a, b color.Color) int {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	distance := 0
+	for _, d := range []int{
+		int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8),
+		int(b1>>8) - int(b2>>8), int(a1>>8) - int(a2>>8),
+	} {
+		distance = max(distance, d, -d)
+	}
+	return distance
+}
+
+func writeTestPNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"image"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+var hintings = map[string]font.Hinting{
+	"none":     font.HintingNone,
+	"vertical": font.HintingVertical,
+	"full":     font.HintingFull,
+}
+
+// setHinting reads ?hinting=none|vertical|full. Full hinting snaps glyphs
+// to the pixel grid, which keeps small text sharp but uneven.
+func (i *Image) setHinting(hinting string) {
+	i.hinting = "full"
+	if _, ok := hintings[hinting]; ok {
+		i.hinting = hinting
+	}
+}
+
+// setSupersample reads ?supersample=2, which draws the text at 2 to 4 times
+// the size and scales it down, for smoother edges. The larger canvas counts
+// against MAX_PIXELS.
+func (i *Image) setSupersample(value string) error {
+	i.supersample = 1
+	if factor, err := strconv.Atoi(value); err == nil {
+		i.supersample = clamp(factor, 1, 4)
+	}
+	return checkPixels(i.width*i.supersample, i.height*i.supersample)
+}
+
+// drawSupersampled draws the text on a canvas supersample times the size
+// of dst, and scales it down over dst.
+func (i *Image) drawSupersampled(ctx context.Context, dst *image.RGBA) error {
+	scale := i.supersample
+	large := *i
+	large.supersample = 1
+	large.width, large.height = dst.Bounds().Dx()*scale, dst.Bounds().Dy()*scale
+	large.fontSize = i.fontSize * float64(scale)
+	large.blocks = make([]TextBlock, len(i.blocks))
+	for n, block := range i.blocks {
+		block.Size *= float64(scale)
+		large.blocks[n] = block
+	}
+	large.style.shadowX, large.style.shadowY = i.style.shadowX*scale, i.style.shadowY*scale
+	large.style.outlineWidth = i.style.outlineWidth * scale
+	large.style.tracking = i.style.tracking * fixed.Int26_6(scale)
+
+	layer := newPooledRGBA(image.Rect(0, 0, large.width, large.height))
+	defer releaseRGBA(layer)
+	if err := large.drawText(ctx, layer); err != nil {
+		return err
+	}
+	draw.BiLinear.Scale(dst, dst.Bounds(), layer, layer.Bounds(), draw.Over, nil)
+	return nil
+}
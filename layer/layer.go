@@ -0,0 +1,91 @@
+// Package layer registers plugin layers, custom drawing steps that requests
+// add to an image with ?layers=name.
+package layer
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Layer is a step of a render, drawn over the layers below it.
+type Layer interface {
+	Draw(ctx context.Context, dst *image.RGBA) error
+}
+
+// Image describes the image a layer is made for, after every other
+// parameter of the request is applied.
+type Image struct {
+	Width      int
+	Height     int
+	Background color.RGBA
+	Foreground color.RGBA
+	Text       string
+	Seed       string
+}
+
+// Factory makes the layer of a plugin for an image. param reads the options
+// of the layer from the request, such as ?frameColor=. It only reads the
+// options declared with Register, and is empty for any other name.
+type Factory func(img Image, param func(name string) string) (Layer, error)
+
+// plugin is a registered layer.
+type plugin struct {
+	factory Factory
+	params  []string
+}
+
+var (
+	mu      sync.RWMutex
+	plugins = map[string]plugin{}
+)
+
+// Register adds a plugin layer that requests turn on with ?layers=name, with
+// the options it reads. Every declared option is part of the cache key,
+// whether the factory reads it or not. Plugins call it in init. It panics
+// when the name is taken, like database/sql.Register.
+func Register(name string, factory Factory, params ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("layer: Register factory is nil")
+	}
+	if name == "" || strings.Contains(name, ",") {
+		panic(fmt.Sprintf("layer: invalid name %q", name))
+	}
+	if _, ok := plugins[name]; ok {
+		panic(fmt.Sprintf("layer: Register called twice for %q", name))
+	}
+	for _, param := range params {
+		if param == "" {
+			panic(fmt.Sprintf("layer: empty option name for %q", name))
+		}
+	}
+	plugins[name] = plugin{factory, slices.Clone(params)}
+}
+
+// Lookup returns the factory of a registered layer and the options it was
+// registered with.
+func Lookup(name string) (Factory, []string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := plugins[name]
+	return p.factory, slices.Clone(p.params), ok
+}
+
+// Names returns the registered layers in order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,44 @@
+package layer
+
+import (
+	"context"
+	"image"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// registered keeps the test repeatable with -count, as layers can't be
+// unregistered.
+var registered sync.Once
+
+type blank struct{}
+
+func (blank) Draw(ctx context.Context, dst *image.RGBA) error { return nil }
+
+func TestRegister(t *testing.T) {
+	factory := func(img Image, param func(string) string) (Layer, error) { return blank{}, nil }
+	registered.Do(func() { Register("test-blank", factory, "blankColor") })
+	if _, params, ok := Lookup("test-blank"); !ok {
+		t.Error("registered layer wasn't found")
+	} else if !slices.Equal(params, []string{"blankColor"}) {
+		t.Errorf("params = %v, want [blankColor]", params)
+	}
+	if _, _, ok := Lookup("test-missing"); ok {
+		t.Error("unregistered layer was found")
+	}
+	if !slices.Contains(Names(), "test-blank") {
+		t.Errorf("Names() = %v, want test-blank", Names())
+	}
+
+	for _, name := range []string{"test-blank", "", "a,b"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q didn't panic", name)
+				}
+			}()
+			Register(name, factory)
+		}()
+	}
+}
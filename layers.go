@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/draw"
+
+	"github.com/gitkumi/placeholder/layer"
+)
+
+// Layer is a step of a render, the same as the layers of plugins.
+type Layer = layer.Layer
+
+// layers returns the layers of the image from the bottom up: the
+// background, patterns, the text, the logo and the marks over it, plugin
+// layers, then filters. Overlays come last so filters don't change their
+// colors.
+func (i *Image) layers() []Layer {
+	layers := []Layer{backgroundLayer{i}, patternLayer{i}}
+	if i.ribbon.below {
+		layers = append(layers, ribbonLayer{i})
+	}
+	layers = append(layers, textLayer{i}, logoLayer{i})
+	if !i.ribbon.below {
+		layers = append(layers, ribbonLayer{i})
+	}
+	layers = append(append(layers, watermarkLayer{i}), i.plugins...)
+	return append(layers, filterLayer{i}, overlayLayer{i})
+}
+
+// backgroundLayer fills the image with bg and adds noise.
+type backgroundLayer struct{ *Image }
+
+func (l backgroundLayer) Draw(ctx context.Context, dst *image.RGBA) error {
+	fillBands(dst, func(band *image.RGBA) {
+		draw.Draw(band, band.Bounds(), &image.Uniform{l.bg}, image.Point{}, draw.Src)
+	})
+	l.applyNoise(dst)
+	return nil
+}
+
+// patternLayer draws the identicon and the cross of the wireframe style.
+type patternLayer struct{ *Image }
+
+func (l patternLayer) Draw(ctx context.Context, dst *image.RGBA) error {
+	if l.identicon {
+		l.drawIdenticon(dst)
+	}
+	if l.boxStyle == "cross" {
+		drawCross(dst, l.fg)
+	}
+	return nil
+}
+
+// textLayer draws the text, or the labels of the cells of a grid.
+type textLayer struct{ *Image }
+
+func (l textLayer) Draw(ctx context.Context, dst *image.RGBA) error {
+	switch {
+	case l.columns > 0:
+		return l.drawCells(ctx, dst)
+	case l.anim == "spinner":
+		// The spinner is drawn in place of the text, frame by frame.
+		return nil
+	case l.orientation == "vertical" || l.textRotate != 0:
+		// Turned text is drawn on a transparent layer first.
+		width, height := l.width, l.height
+		if l.orientation == "vertical" {
+			width, height = height, width
+		}
+		layer := newPooledRGBA(image.Rect(0, 0, width, height))
+		defer releaseRGBA(layer)
+		if err := l.drawText(ctx, layer); err != nil {
+			return err
+		}
+		text := layer
+		if l.orientation == "vertical" {
+			text = rotateClockwise(layer)
+		}
+		if l.textRotate != 0 {
+			drawRotated(dst, text, l.textRotate)
+		} else {
+			draw.Draw(dst, dst.Bounds(), text, image.Point{}, draw.Over)
+		}
+		return nil
+	default:
+		return l.drawText(ctx, dst)
+	}
+}
+
+// logoLayer draws the logo in its corner.
+type logoLayer struct{ *Image }
+
+func (l logoLayer) Draw(ctx context.Context, dst *image.RGBA) error {
+	if l.logo == "" {
+		return nil
+	}
+	return l.drawLogo(ctx, dst)
+}
+
+// ribbonLayer draws the ribbon across its corner.
+type ribbonLayer struct{ *Image }
+
+func (l ribbonLayer) Draw(ctx context.Context, dst *image.RGBA) error {
+	l.drawRibbon(dst)
+	return nil
+}
+
+// watermarkLayer tiles the watermark over the image.
+type watermarkLayer struct{ *Image }
+
+func (l watermarkLayer) Draw(ctx context.Context, dst *image.RGBA) error {
+	l.drawWatermark(dst)
+	return nil
+}
+
+// filterLayer runs the filters over everything below it.
+type filterLayer struct{ *Image }
+
+func (l filterLayer) Draw(ctx context.Context, dst *image.RGBA) error {
+	l.filters.apply(dst)
+	return nil
+}
+
+// overlayLayer draws the measurement overlays and guides.
+type overlayLayer struct{ *Image }
+
+func (l overlayLayer) Draw(ctx context.Context, dst *image.RGBA) error {
+	l.drawOverlays(dst)
+	l.drawGuides(dst)
+	return nil
+}
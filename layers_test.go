@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"runtime"
+	"testing"
+
+	"github.com/gitkumi/placeholder/layer"
+)
+
+func TestLayerOrder(t *testing.T) {
+	indexOf := func(layers []Layer, want Layer) int {
+		for n, layer := range layers {
+			if layer == want {
+				return n
+			}
+		}
+		return -1
+	}
+	for _, below := range []bool{false, true} {
+		img := &Image{ribbon: Ribbon{below: below}}
+		layers := img.layers()
+		ribbon, text := indexOf(layers, ribbonLayer{img}), indexOf(layers, textLayer{img})
+		if ribbon < 0 || text < 0 || (ribbon < text) != below {
+			t.Errorf("below=%v: ribbon is layer %d and text is layer %d", below, ribbon, text)
+		}
+		if last := layers[len(layers)-1]; last != (overlayLayer{img}) {
+			t.Errorf("below=%v: last layer is %T, want overlays", below, last)
+		}
+	}
+}
+
+func TestBackgroundLayer(t *testing.T) {
+	bg := color.RGBA{0x0C, 0x79, 0xED, 0xFF}
+	dst := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	if err := (backgroundLayer{&Image{bg: bg}}).Draw(context.Background(), dst); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []image.Point{{0, 0}, {39, 29}, {20, 15}} {
+		if got := dst.RGBAAt(p.X, p.Y); got != bg {
+			t.Errorf("pixel at %v is %v, want %v", p, got, bg)
+		}
+	}
+}
+
+func init() {
+	layer.Register("test-fill", func(img layer.Image, param func(string) string) (layer.Layer, error) {
+		return fillLayer{parseColor(param("fillColor"), img.Foreground)}, nil
+	}, "fillColor")
+}
+
+// fillLayer is a plugin layer that fills the image with a color.
+type fillLayer struct{ color color.RGBA }
+
+func (l fillLayer) Draw(ctx context.Context, dst *image.RGBA) error {
+	for n := 0; n < len(dst.Pix); n += 4 {
+		dst.Pix[n], dst.Pix[n+1], dst.Pix[n+2], dst.Pix[n+3] = l.color.R, l.color.G, l.color.B, l.color.A
+	}
+	return nil
+}
+
+func TestPluginLayers(t *testing.T) {
+	params := map[string]string{"fillColor": "0c79ed"}
+	img := &Image{width: 40, height: 30}
+	if err := img.setLayers("test-fill", func(name string) string { return params[name] }); err != nil {
+		t.Fatal(err)
+	}
+	if err := img.apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer img.release()
+	if got, want := img.data.RGBAAt(20, 15), (color.RGBA{0x0C, 0x79, 0xED, 0xFF}); got != want {
+		t.Errorf("pixel is %v, want %v", got, want)
+	}
+
+	// The options a layer reads are part of the cache key.
+	key := img.cacheKey()
+	params["fillColor"] = "e11"
+	if err := img.setLayers("test-fill", func(name string) string { return params[name] }); err != nil {
+		t.Fatal(err)
+	}
+	if img.cacheKey() == key {
+		t.Error("changing fillColor kept the cache key")
+	}
+
+	// Options the layer didn't declare aren't.
+	key = img.cacheKey()
+	params["fillOpacity"] = "0.5"
+	if err := img.setLayers("test-fill", func(name string) string { return params[name] }); err != nil {
+		t.Fatal(err)
+	}
+	if img.cacheKey() != key {
+		t.Error("an undeclared option changed the cache key")
+	}
+
+	if err := img.setLayers("missing", func(string) string { return "" }); err == nil {
+		t.Error("unregistered layer was accepted")
+	}
+}
+
+func TestNoiseIgnoresText(t *testing.T) {
+	render := func(img *Image) []byte {
+		dst := image.NewRGBA(image.Rect(0, 0, img.width, img.height))
+		if err := (backgroundLayer{img}).Draw(context.Background(), dst); err != nil {
+			t.Fatal(err)
+		}
+		return dst.Pix
+	}
+	bg := color.RGBA{0x80, 0x80, 0x80, 0xFF}
+	plain := render(&Image{width: 40, height: 30, bg: bg, noise: 0.5, text: "one", format: "png"})
+	other := render(&Image{width: 40, height: 30, bg: bg, noise: 0.5, text: "two", format: "gif", meta: true})
+	if !bytes.Equal(plain, other) {
+		t.Error("the grain changed with the text and format")
+	}
+}
+
+func TestIdenticonSameOnAnyCores(t *testing.T) {
+	img := &Image{width: 1500, height: 1000, seed: "placeholder", identicon: true, fg: color.RGBA{0x0C, 0x79, 0xED, 0xFF}}
+	draw := func(procs int) []byte {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		dst := image.NewRGBA(image.Rect(0, 0, img.width, img.height))
+		if err := (patternLayer{img}).Draw(context.Background(), dst); err != nil {
+			t.Fatal(err)
+		}
+		return dst.Pix
+	}
+	if !bytes.Equal(draw(1), draw(7)) {
+		t.Error("identicon drawn in bands differs from the one drawn at once")
+	}
+}
+
+func TestFiltersKeepAlpha(t *testing.T) {
+	for _, filter := range []string{"invert", "sepia", "grayscale"} {
+		dst := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		// Half transparent red, premultiplied.
+		dst.Pix = []uint8{0x80, 0x00, 0x00, 0x80}
+		parseFilters(filter, "", "").apply(dst)
+		got := dst.RGBAAt(0, 0)
+		if got.A != 0x80 || got.R > got.A || got.G > got.A || got.B > got.A {
+			t.Errorf("%s made %v, brighter than its alpha", filter, got)
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	dst.Pix = []uint8{0x80, 0x00, 0x00, 0x80}
+	invert(dst)
+	if got, want := dst.RGBAAt(0, 0), (color.RGBA{0x00, 0x80, 0x80, 0x80}); got != want {
+		t.Errorf("inverted half transparent red is %v, want %v", got, want)
+	}
+}
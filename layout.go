@@ -0,0 +1,183 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// textLayout holds the wrapped lines of a text and where each one is drawn.
+// It only depends on the font, size, text and canvas, so renders that differ
+// by colors or effects can share it.
+type textLayout struct {
+	lines []layoutLine
+}
+
+type layoutLine struct {
+	text string
+	dot  fixed.Point26_6
+}
+
+type layoutKey struct {
+	font      string
+	fontSize  float64
+	hinting   font.Hinting
+	tracking  fixed.Int26_6
+	direction string
+	text      string
+	width     int
+	height    int
+	// position is top, bottom or center, which is the default.
+	position string
+
+	lineHeight float64
+	maxLines   int
+	ellipsis   bool
+	hyphens    bool
+}
+
+var layouts = newLRUCache[layoutKey, textLayout](config.layoutCacheSize)
+
+func cachedLayout(key layoutKey, drawer *font.Drawer) textLayout {
+	if layout, ok := layouts.get(key); ok {
+		return layout
+	}
+	layout := layoutText(key, drawer)
+	layouts.add(key, layout)
+	return layout
+}
+
+// layoutText wraps the text and places every baseline a fixed distance
+// apart. The block is centered from the first line's ascent to the last
+// line's descent, so lines without descenders or capitals don't change the
+// spacing, or placed against the top or bottom padding.
+func layoutText(key layoutKey, drawer *font.Drawer) textLayout {
+	padding := 30
+	maxWidth := fixed.I(key.width - padding)
+	lines := wrapText(key.text, drawer, key.tracking, maxWidth, key.hyphens)
+	lines = truncateLines(key, drawer, lines, maxWidth, fixed.I(key.height-padding))
+
+	metrics := drawer.Face.Metrics()
+	advance := lineAdvance(key, drawer)
+	height := advance*fixed.Int26_6(max(len(lines)-1, 0)) + metrics.Ascent + metrics.Descent
+	y := (fixed.I(key.height)-height)/2 + metrics.Ascent
+	switch key.position {
+	case "top":
+		y = fixed.I(padding/2) + metrics.Ascent
+	case "bottom":
+		y = fixed.I(key.height-padding/2) - height + metrics.Ascent
+	}
+
+	layout := textLayout{lines: make([]layoutLine, 0, len(lines))}
+	for _, line := range lines {
+		layout.lines = append(layout.lines, layoutLine{
+			text: visualOrder(line, key.direction),
+			dot:  fixed.Point26_6{X: (fixed.I(key.width) - measureString(drawer, line, key.tracking)) / 2, Y: y},
+		})
+		y += advance
+	}
+	return layout
+}
+
+// lineAdvance is the distance between baselines. lineHeight is relative to
+// the font size like in CSS, otherwise lines are a fifth taller than the
+// font's ascent and descent.
+func lineAdvance(key layoutKey, drawer *font.Drawer) fixed.Int26_6 {
+	if key.lineHeight > 0 {
+		return fixed.Int26_6(key.lineHeight * key.fontSize * 64)
+	}
+	metrics := drawer.Face.Metrics()
+	return (metrics.Ascent + metrics.Descent) * 6 / 5
+}
+
+// truncateLines drops the lines past maxLines. With ellipsis, the lines that
+// fit the height are kept when maxLines is not set, and the last kept line
+// ends in "…".
+func truncateLines(key layoutKey, drawer *font.Drawer, lines []string, maxWidth, maxHeight fixed.Int26_6) []string {
+	maxLines := key.maxLines
+	if maxLines == 0 && key.ellipsis {
+		metrics := drawer.Face.Metrics()
+		maxLines = max(1, 1+int((maxHeight-metrics.Ascent-metrics.Descent)/lineAdvance(key, drawer)))
+	}
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return lines
+	}
+
+	lines = lines[:maxLines]
+	if key.ellipsis {
+		lines[maxLines-1] = ellipsize(drawer, lines[maxLines-1], key.tracking, maxWidth)
+	}
+	return lines
+}
+
+// wrapText compares widths in 26.6 fixed point so line breaks never depend on
+// floating point rounding. Words wider than maxWidth, like URLs and hashes,
+// are broken between characters, with a hyphen at each break when hyphens
+// is set.
+func wrapText(text string, drawer *font.Drawer, tracking, maxWidth fixed.Int26_6, hyphens bool) []string {
+	var lines []string
+	var currentLine string
+
+	words := strings.Fields(text)
+
+	for _, word := range words {
+		testLine := currentLine
+		if len(testLine) > 0 {
+			testLine += " "
+		}
+		testLine += word
+		if measureString(drawer, testLine, tracking) > maxWidth {
+			if len(currentLine) > 0 {
+				lines = append(lines, currentLine)
+			}
+			currentLine = word
+			if measureString(drawer, word, tracking) > maxWidth {
+				parts := breakWord(drawer, word, tracking, maxWidth, hyphens)
+				lines = append(lines, parts[:len(parts)-1]...)
+				currentLine = parts[len(parts)-1]
+			}
+		} else {
+			if len(currentLine) > 0 {
+				currentLine += " "
+			}
+			currentLine += word
+		}
+	}
+
+	if len(currentLine) > 0 {
+		lines = append(lines, currentLine)
+	}
+
+	return lines
+}
+
+// breakWord splits word into parts that fit maxWidth. Every part has at
+// least one character, so a canvas narrower than a character still ends.
+func breakWord(drawer *font.Drawer, word string, tracking, maxWidth fixed.Int26_6, hyphens bool) []string {
+	suffix := ternary(hyphens, "-", "")
+	var parts []string
+	runes := []rune(word)
+	for len(runes) > 0 {
+		n := 1
+		for n < len(runes) && measureString(drawer, string(runes[:n+1])+suffix, tracking) <= maxWidth {
+			n++
+		}
+		if n == len(runes) {
+			parts = append(parts, string(runes))
+			break
+		}
+		parts = append(parts, string(runes[:n])+suffix)
+		runes = runes[n:]
+	}
+	return parts
+}
+
+// ellipsize shortens line until it fits maxWidth with an ellipsis appended.
+func ellipsize(drawer *font.Drawer, line string, tracking, maxWidth fixed.Int26_6) string {
+	runes := []rune(strings.TrimRight(line, " "))
+	for len(runes) > 0 && measureString(drawer, string(runes)+"…", tracking) > maxWidth {
+		runes = []rune(strings.TrimRight(string(runes[:len(runes)-1]), " "))
+	}
+	return string(runes) + "…"
+}
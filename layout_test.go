@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// newTestDrawer returns a drawer with the regular face at size.
+func newTestDrawer(t testing.TB, size float64) *font.Drawer {
+	t.Helper()
+	if regularFontErr != nil {
+		t.Fatal(regularFontErr)
+	}
+	img := &Image{fontSize: size, reproducible: true}
+	parsed, _ := lookupFont(img.fontKey())
+	return &font.Drawer{Face: newFace(parsed, img.faceOptions())}
+}
+
+func TestLayoutBaselines(t *testing.T) {
+	drawer := newTestDrawer(t, 40)
+	// Lines without descenders, without ascenders and with both.
+	key := layoutKey{text: "AAA ggg AgA", width: 120, height: 300, fontSize: 40}
+	layout := layoutText(key, drawer)
+	if len(layout.lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(layout.lines))
+	}
+
+	advance := layout.lines[1].dot.Y - layout.lines[0].dot.Y
+	if got := layout.lines[2].dot.Y - layout.lines[1].dot.Y; got != advance {
+		t.Errorf("baselines are %v and %v apart, want equal spacing", advance, got)
+	}
+
+	metrics := drawer.Face.Metrics()
+	top := layout.lines[0].dot.Y - metrics.Ascent
+	bottom := fixed.I(key.height) - (layout.lines[2].dot.Y + metrics.Descent)
+	if diff := top - bottom; diff < -1 || diff > 1 {
+		t.Errorf("block has %v above and %v below, want it centered", top, bottom)
+	}
+}
+
+func TestLayoutLineHeight(t *testing.T) {
+	drawer := newTestDrawer(t, 40)
+	key := layoutKey{text: "one two", width: 100, height: 300, fontSize: 40, lineHeight: 1.5}
+	layout := layoutText(key, drawer)
+	if len(layout.lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(layout.lines))
+	}
+	if got, want := layout.lines[1].dot.Y-layout.lines[0].dot.Y, fixed.I(60); got != want {
+		t.Errorf("baselines are %v apart, want %v", got, want)
+	}
+}
+
+func TestWrapTextLongWords(t *testing.T) {
+	drawer := newTestDrawer(t, 20)
+	maxWidth := fixed.I(120)
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"url", "see https://example.com/a/very/long/path/to/some/resource?query=1"},
+		{"hash", "sha256 e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"narrow", "WWWWWWWW"},
+	}
+	for _, test := range tests {
+		for _, hyphens := range []bool{false, true} {
+			lines := wrapText(test.text, drawer, 0, maxWidth, hyphens)
+			if len(lines) < 2 {
+				t.Errorf("%s: got %q, want the long word broken", test.name, lines)
+			}
+			for n, line := range lines {
+				if width := measureString(drawer, line, 0); width > maxWidth {
+					t.Errorf("%s: line %q is %v wide, want at most %v", test.name, line, width, maxWidth)
+				}
+				broken := n < len(lines)-1 && !strings.Contains(test.text, line+" ")
+				if hyphens && broken && !strings.HasSuffix(line, "-") {
+					t.Errorf("%s: broken line %q does not end in a hyphen", test.name, line)
+				}
+			}
+
+			joined := strings.Join(lines, " ")
+			if hyphens {
+				joined = strings.ReplaceAll(joined, "- ", "")
+			}
+			if got, want := strings.ReplaceAll(joined, " ", ""), strings.ReplaceAll(test.text, " ", ""); got != want {
+				t.Errorf("%s: lines %q lose characters", test.name, lines)
+			}
+		}
+	}
+}
+
+func TestBreakWordNarrowCanvas(t *testing.T) {
+	drawer := newTestDrawer(t, 40)
+	parts := breakWord(drawer, "abc", 0, fixed.I(1), true)
+	if want := []string{"a-", "b-", "c"}; strings.Join(parts, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", parts, want)
+	}
+}
+
+func TestOutlineStopsWhenCanceled(t *testing.T) {
+	style := parseTextStyle("", "50", "")
+	if style.outlineWidth != maxOutlineWidth {
+		t.Errorf("outline width is %d, want it capped at %d", style.outlineWidth, maxOutlineWidth)
+	}
+	drawer := newTestDrawer(t, 40)
+	drawer.Dst = image.NewRGBA(image.Rect(0, 0, 300, 100))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	line := layoutLine{"outline", fixed.P(10, 60)}
+	if err := style.draw(ctx, drawer, line, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled outline returned %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errSaturated = errors.New("render queue is full")
+
+// RenderLimiter bounds how many renders run at once. Requests beyond that
+// wait in a bounded queue, and are turned away once the queue is full.
+type RenderLimiter struct {
+	slots   chan struct{}
+	queue   chan struct{}
+	timeout time.Duration
+}
+
+func newRenderLimiter(concurrency, queueSize int, timeout time.Duration) *RenderLimiter {
+	return &RenderLimiter{
+		slots:   make(chan struct{}, concurrency),
+		queue:   make(chan struct{}, concurrency+queueSize),
+		timeout: timeout,
+	}
+}
+
+func (l *RenderLimiter) acquire(done <-chan struct{}) error {
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return errSaturated
+	}
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		<-l.queue
+		return errSaturated
+	case <-done:
+		<-l.queue
+		return errors.New("request canceled")
+	}
+}
+
+func (l *RenderLimiter) release() {
+	<-l.slots
+	<-l.queue
+}
+
+// renderLimiterKey is the context key of the limiter limitRenders sets.
+const renderLimiterKey = "renderLimiter"
+
+// limitRenders has the renders of a route take a slot of limiter. The slot
+// is taken by the shared render rather than the handler, so it is held for
+// as long as the render runs, and not by cache hits or requests waiting on
+// a render.
+func limitRenders(limiter *RenderLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(renderLimiterKey, limiter)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"image"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+var logoPresets = loadPresets(config.logoPresets)
+
+// setLogo accepts the name of a LOGO_PRESETS entry or a URL on the fetch
+// allowlist. The logo is placed in a corner, or the center, and scaled to a
+// fraction of the image width.
+func (i *Image) setLogo(logo, position, scale string) error {
+	if logo == "" {
+		return nil
+	}
+	if _, ok := logoPresets[logo]; !ok {
+		u, err := url.Parse(logo)
+		if err != nil || u.Scheme == "" {
+			return badRequest("unknown_logo", "Unknown logo %q.", logo)
+		}
+		if err := checkFetchURL(u); err != nil {
+			return err
+		}
+	}
+	i.logo = logo
+
+	switch position {
+	case "tl", "tr", "bl", "br", "c":
+		i.logoPosition = position
+	default:
+		i.logoPosition = "br"
+	}
+
+	i.logoScale = 0.2
+	if value, err := strconv.ParseFloat(scale, 64); err == nil && value > 0 && value <= 1 {
+		i.logoScale = value
+	}
+	return nil
+}
+
+func (i *Image) drawLogo(ctx context.Context, dst *image.RGBA) error {
+	logo, ok := logoPresets[i.logo]
+	if !ok {
+		var err error
+		if logo, err = fetchImage(ctx, i.logo); err != nil {
+			return err
+		}
+	}
+
+	bounds := logo.Bounds()
+	width := clamp(int(float64(i.width)*i.logoScale), 1, i.width)
+	height := clamp(width*bounds.Dy()/bounds.Dx(), 1, i.height)
+	margin := ternary(i.width < i.height, i.width, i.height) / 40
+
+	var x, y int
+	switch i.logoPosition {
+	case "tl":
+		x, y = margin, margin
+	case "tr":
+		x, y = i.width-width-margin, margin
+	case "bl":
+		x, y = margin, i.height-height-margin
+	case "br":
+		x, y = i.width-width-margin, i.height-height-margin
+	case "c":
+		x, y = (i.width-width)/2, (i.height-height)/2
+	}
+
+	draw.CatmullRom.Scale(dst, image.Rect(x, y, x+width, y+height), logo, bounds, draw.Over, nil)
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// thumbnail scales img down so its longest side is at most size pixels.
+func thumbnail(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width >= height {
+		width, height = size, clamp(height*size/width, 1, size)
+	} else {
+		width, height = clamp(width*size/height, 1, size), size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// blurhash encodes img with 4x3 components, see https://blurha.sh.
+func blurhash(img image.Image) string {
+	const componentsX, componentsY = 4, 3
+
+	small := thumbnail(img, 64)
+	width, height := small.Bounds().Dx(), small.Bounds().Dy()
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(width)) *
+						math.Cos(math.Pi*float64(j*y)/float64(height))
+					pixel := small.RGBAAt(x, y)
+					factor[0] += basis * srgbToLinear(pixel.R)
+					factor[1] += basis * srgbToLinear(pixel.G)
+					factor[2] += basis * srgbToLinear(pixel.B)
+				}
+			}
+			normalisation := ternary(i == 0 && j == 0, 1.0, 2.0)
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(base83((componentsX-1)+(componentsY-1)*9, 1))
+
+	maximum := 0.0
+	for _, factor := range factors[1:] {
+		for _, value := range factor {
+			maximum = math.Max(maximum, math.Abs(value))
+		}
+	}
+	quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(maximum*166-0.5))))
+	maximumValue := float64(quantisedMaximum+1) / 166
+	hash.WriteString(base83(quantisedMaximum, 1))
+
+	dc := factors[0]
+	hash.WriteString(base83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, factor := range factors[1:] {
+		var quantised [3]int
+		for c, value := range factor {
+			quantised[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(base83(quantised[0]*19*19+quantised[1]*19+quantised[2], 2))
+	}
+
+	return hash.String()
+}
+
+// lqip returns a tiny blurred PNG of img as a JSON data URI payload.
+func lqip(img image.Image) ([]byte, error) {
+	small := thumbnail(img, 16)
+	blurred := boxBlur(small)
+
+	buffer := new(bytes.Buffer)
+	if err := pngEncoder.Encode(buffer, blurred); err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	return json.Marshal(map[string]any{
+		"width":   bounds.Dx(),
+		"height":  bounds.Dy(),
+		"dataURI": "data:image/png;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes()),
+	})
+}
+
+// boxBlur averages every pixel with its 3x3 neighbourhood.
+func boxBlur(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var sum [4]int
+			count := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if !(image.Point{x + dx, y + dy}.In(bounds)) {
+						continue
+					}
+					pixel := src.RGBAAt(x+dx, y+dy)
+					sum[0] += int(pixel.R)
+					sum[1] += int(pixel.G)
+					sum[2] += int(pixel.B)
+					sum[3] += int(pixel.A)
+					count++
+				}
+			}
+			offset := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
+
+func base83(value, length int) string {
+	encoded := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		encoded[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(encoded)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a fixed-size, concurrency-safe least recently used cache.
+// Caches made with newSizedLRUCache are bounded by the bytes of their
+// values too.
+type lruCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[K]*list.Element
+
+	maxBytes int64
+	bytes    int64
+	cost     func(V) int64
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+	bytes int64
+}
+
+func newLRUCache[K comparable, V any](size int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		size:    size,
+		order:   list.New(),
+		entries: map[K]*list.Element{},
+	}
+}
+
+// newSizedLRUCache returns a cache of at most size values and maxBytes, as
+// measured by cost. Values larger than maxBytes aren't kept.
+func newSizedLRUCache[K comparable, V any](size int, maxBytes int64, cost func(V) int64) *lruCache[K, V] {
+	c := newLRUCache[K, V](size)
+	c.maxBytes, c.cost = maxBytes, cost
+	return c
+}
+
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return element.Value.(*lruEntry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *lruCache[K, V]) add(key K, value V) {
+	if c.size <= 0 {
+		return
+	}
+
+	var bytes int64
+	if c.cost != nil {
+		if bytes = c.cost(value); bytes > c.maxBytes {
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry[K, V])
+		c.bytes += bytes - entry.bytes
+		entry.value, entry.bytes = value, bytes
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key, value, bytes})
+		c.bytes += bytes
+	}
+	for c.order.Len() > c.size || (c.cost != nil && c.bytes > c.maxBytes) {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		entry := oldest.Value.(*lruEntry[K, V])
+		delete(c.entries, entry.key)
+		c.bytes -= entry.bytes
+	}
+}
+
+func (c *lruCache[K, V]) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[K]*list.Element{}
+	c.bytes = 0
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestSizedLRUCache(t *testing.T) {
+	c := newSizedLRUCache[string, []byte](10, 100, func(v []byte) int64 { return int64(len(v)) })
+	c.add("a", make([]byte, 40))
+	c.add("b", make([]byte, 40))
+	c.get("a")
+	c.add("c", make([]byte, 40))
+	if _, ok := c.get("b"); ok {
+		t.Error("the least recently used value was kept over the byte limit")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("a recently used value was evicted")
+	}
+	c.add("huge", make([]byte, 101))
+	if _, ok := c.get("huge"); ok {
+		t.Error("a value over the byte limit was kept")
+	}
+	if c.bytes != 80 {
+		t.Errorf("cache counts %d bytes, want 80", c.bytes)
+	}
+}
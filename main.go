@@ -2,181 +2,685 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
 	"image/png"
+	"io"
 	"log"
+	"math"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
 	"golang.org/x/image/font"
-	"golang.org/x/image/font/gofont/goregular"
-	"golang.org/x/image/math/fixed"
+
+	colors "github.com/gitkumi/placeholder/color"
 )
 
-var environment = os.Getenv("ENVIRONMENT")
+var renderCache Cache
 
 type Image struct {
-	width    int
-	height   int
-	text     string
-	fontSize float64
-	bg       color.RGBA
-	fg       color.RGBA
-	data     *image.RGBA
+	width       int
+	height      int
+	text        string
+	fontSize    float64
+	fontWeight  string
+	fontStyle   string
+	lineHeight  float64
+	maxLines    int
+	ellipsis    bool
+	hyphens     bool
+	bg          color.RGBA
+	fg          color.RGBA
+	style       TextStyle
+	direction   string
+	orientation string
+	textRotate  float64
+	watermark   Watermark
+	ribbon      Ribbon
+	hinting     string
+	supersample int
+	meta        bool
+	noise       float64
+	format      string
+	seed        string
+	identicon   bool
+	boxStyle    string
+	columns     int
+	rows        int
+	data        *image.RGBA
+
+	// textPosition places text, blocks are more texts around it.
+	textPosition string
+	blocks       []TextBlock
+
+	// plugins are the layers of ?layers=, and pluginParams the options
+	// they declare.
+	plugins      []Layer
+	pluginNames  []string
+	pluginParams url.Values
+
+	// tenant names the tenant of the request, and fontFamily is the family
+	// of its fonts.
+	tenant     string
+	fontFamily string
+
+	logo         string
+	logoPosition string
+	logoScale    float64
+
+	dpi        float64
+	pageWidth  float64
+	pageHeight float64
+
+	filters  Filters
+	overlays []string
+	guides   []string
+
+	reproducible bool
+	markup       bool
+
+	compression string
+	colors      int
+
+	anim       string
+	fps        int
+	frameCount int
+	// cycleFrom is the background of the first frame of anim=cycle.
+	cycleFrom color.RGBA
+
+	scheme string
+
+	// maxSize is the largest width or height the caller may render.
+	maxSize int
+
+	// swatch is the palette pick, bg and fg are what gets drawn.
+	swatch *Swatch
+
+	// colorErrors explains colors that fell back to the default.
+	colorErrors []string
 }
 
 func main() {
-	r := gin.Default()
-	r.GET("/:size", imageHandler)
-	port := ternary(environment == "production", ":8080", ":3000")
-	if err := r.Run(port); err != nil {
+	var addrs []string
+	flag.Func("addr", "Address to listen on, such as :3000, 127.0.0.1:3000 or the path of a Unix socket. Repeat to listen on several.", func(addr string) error {
+		addrs = append(addrs, addr)
+		return nil
+	})
+	worker := flag.Bool("worker", false, "Render jobs from WORKER_QUEUE instead of serving HTTP.")
+	flag.Parse()
+	if len(addrs) > 0 {
+		config.addrs = addrs
+	}
+
+	cache, err := newCache()
+	if err != nil {
+		log.Fatal(err)
+	}
+	renderCache = cache
+
+	gin.SetMode(config.ginMode)
+	r := gin.New()
+	switch config.accessLog {
+	case "text":
+		r.Use(gin.Logger())
+	case "json":
+		r.Use(accessLog())
+	case "off":
+	default:
+		log.Fatalf("Unknown ACCESS_LOG %q, use text, json or off", config.accessLog)
+	}
+	r.Use(gin.Recovery())
+	r.NoRoute(func(c *gin.Context) {
+		problem(c, http.StatusNotFound, "not_found", "No endpoint matches the URL.")
+	})
+	// Without trusted proxies, the client IP is the address of the
+	// connection, and X-Forwarded-For is ignored.
+	if err := r.SetTrustedProxies(config.trustedProxies); err != nil {
+		log.Fatal(err)
+	}
+	if config.securityHeaders {
+		r.Use(securityHeaders())
+	}
+	r.Use(cors())
+	var limiter *RateLimiter
+	if config.rateLimitRPS > 0 {
+		limiter = newRateLimiter(config.rateLimitRPS, config.rateLimitBurst)
+		r.Use(rateLimit(limiter))
+	}
+	if config.chaos {
+		r.Use(chaos)
+	}
+
+	var renders *RenderLimiter
+	if config.renderConcurrency > 0 {
+		renders = newRenderLimiter(config.renderConcurrency, config.renderQueue, config.renderQueueTimeout)
+	}
+
+	registerRoutes(r, limiter, renders)
+
+	if config.pprof {
+		registerPprof(r)
+	}
+
+	if config.watchAssets {
+		if err := watchAssets(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *worker {
+		if err := runWorker(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if config.prewarmFile != "" {
+		if err := prewarm(config.prewarmFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := serve(r); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// registerRoutes adds every route of the server to r, with renders
+// limited by renders. Previews take tokens of limiter for every frame.
+func registerRoutes(r *gin.Engine, limiter *RateLimiter, renders *RenderLimiter) {
+	r.GET("/", playgroundHandler)
+	r.GET("/playground/*filepath", playgroundAssetHandler)
+	// Signatures are checked first, so forged URLs don't use up quotas.
+	render := r.Group("")
+	if config.urlSigningKey != "" {
+		render.Use(requireSignature)
+	}
+	render.Use(authenticate)
+	registerRenderRoutes(render, limitRenders(renders))
+	render.GET("/snippet/:size", snippetHandler)
+	// Pairs hand out signed URLs, so they need a signature like snippets.
+	render.GET("/pair/:size", pairHandler)
+	// Sets are checked as a whole, so their renders skip the middleware.
+	sets := gin.New()
+	registerRenderRoutes(sets, limitRenders(renders))
+	render.GET("/set/:size", setHandler(sets))
+	// Previews render any spec, which signed URLs are there to prevent.
+	if config.urlSigningKey == "" {
+		previews := gin.New()
+		previews.Use(authenticate)
+		registerRenderRoutes(previews, limitRenders(renders))
+		r.GET("/ws/preview", previewHandler(previews, limiter))
+		// Neither are bodies covered by signatures.
+		render.POST("/render", limitRenders(renders), renderHandler)
+	}
+	r.GET("/openapi.json", openapiHandler)
+
+	collection := r.Group("/collections")
+	// Anyone with a key can save a spec, so renders of saved specs need
+	// signatures like any other render.
+	renderCollection := []gin.HandlerFunc{recoverRender, authenticate, limitRenders(renders), renderCollectionHandler}
+	if config.urlSigningKey != "" {
+		renderCollection = append([]gin.HandlerFunc{requireSignature}, renderCollection...)
+	}
+	collection.Match(getAndHead, "/:id", renderCollection...)
+	collection.Use(requireCollectionKey)
+	collection.GET("", listCollectionHandler)
+	collection.POST("", saveCollectionHandler)
+	collection.GET("/export", exportCollectionHandler)
+	collection.DELETE("/:id", deleteCollectionHandler)
+
+	admin := r.Group("/admin", requireAdmin)
+	admin.GET("/audit", auditHandler)
+	admin.GET("/metrics", gin.WrapH(expvar.Handler()))
+	admin.DELETE("/cache", purgeCacheHandler)
+	admin.GET("/sign", signHandler)
+}
+
+// getAndHead are the methods of image routes. HEAD renders the image, or
+// reads it from the cache, and only sends the headers.
+var getAndHead = []string{http.MethodGet, http.MethodHead}
+
+// registerRenderRoutes adds the routes that render images, each behind
+// limit. Panics in them are recovered as failed renders.
+func registerRenderRoutes(r gin.IRoutes, limit gin.HandlerFunc) {
+	r.Use(recoverRender)
+	r.Match(getAndHead, "/:size", limit, imageHandler)
+	r.Match(getAndHead, "/blurhash/:size", limit, blurhashHandler)
+	r.Match(getAndHead, "/avatar/:size", limit, avatarHandler)
+	r.Match(getAndHead, "/qr/:size", limit, qrHandler)
+	r.Match(getAndHead, "/barcode/:size", limit, barcodeHandler)
+	r.Match(getAndHead, "/og/:template", limit, ogHandler)
+	r.Match(getAndHead, "/t/:name", limit, templateHandler)
+	r.Match(getAndHead, "/photo/:size", limit, photoHandler)
+	r.Match(getAndHead, "/proxy/:size", limit, proxyHandler)
+	r.Match(getAndHead, "/device/:model", limit, deviceHandler)
+	r.Match(getAndHead, "/chart/:size", limit, chartHandler)
+	r.Match(getAndHead, "/progress/:size", limit, progressHandler)
+	r.Match(getAndHead, "/video/:size", limit, videoHandler)
+	r.Match(getAndHead, "/favicon", limit, faviconHandler)
+	r.Match(getAndHead, "/favicon.ico", limit, faviconHandler)
+	r.Match(getAndHead, "/apple-touch-icon.png", limit, appleTouchIconHandler)
+}
+
 func imageHandler(c *gin.Context) {
-	img := &Image{}
-	img.setSize(c.Param("size"))
-	img.setFont(c.Query("fontSize"))
-	img.setText(c.Query("text"))
-	img.setColors(c.Query("bg"), c.Query("fg"))
-	err := img.apply()
+	img, err := parseImage(c, c.Param("size"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create an image."})
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
 		return
 	}
-	bytes, err := img.generate()
+	serveImage(c, img)
+}
+
+// serveImage responds with the parsed image, or its description for
+// ?format=json.
+func serveImage(c *gin.Context, img *Image) {
+	if img.format == "json" {
+		c.JSON(http.StatusOK, img.describe())
+		return
+	}
+
+	serveRender(c, img.cacheKey(), img.contentType(), func(ctx context.Context, w io.Writer) error {
+		defer img.release()
+		if err := img.apply(ctx); err != nil {
+			return err
+		}
+		return img.generate(ctx, w)
+	})
+}
+
+// parseImage reads the placeholder parameters from the query string.
+func parseImage(c *gin.Context, size string) (*Image, error) {
+	return imageFromSpec(c, RenderSpec{Size: size, Params: c.Request.URL.Query()})
+}
+
+// imageFromSpec reads the placeholder parameters from a spec. Headers like
+// Accept still come from the request.
+func imageFromSpec(c *gin.Context, spec RenderSpec) (*Image, error) {
+	img := &Image{maxSize: maxSizeFor(c)}
+	if tenant := tenantFor(c); tenant != nil {
+		img.tenant, img.fontFamily = tenant.name, tenant.family
+		spec = tenant.withDefaults(spec)
+	}
+	img.setDPI(spec.get("dpi"))
+	if err := img.setSize(spec.Size); err != nil {
+		return nil, err
+	}
+	if err := img.setSupersample(spec.get("supersample")); err != nil {
+		return nil, err
+	}
+	img.setFont(spec.get("fontSize"))
+	img.setHinting(spec.get("hinting"))
+	img.setFontVariant(spec.get("fontWeight"), spec.get("fontStyle"))
+	img.setLines(spec.get("lineHeight"), spec.get("maxLines"), spec.get("ellipsis"), spec.get("hyphens"))
+	img.setSeed(spec.get("seed"), spec.get("identicon"))
+	// Reproducible renders pick colors and encoder settings differently.
+	img.setReproducible(spec.get("reproducible"))
+	img.setDirection(spec.get("dir"))
+	img.setOrientation(spec.get("orientation"))
+	img.setTextRotate(spec.get("textRotate"))
+	img.setNoise(spec.get("noise"))
+	if err := img.setScheme(spec.get("scheme"), c.GetHeader(schemeHint)); err != nil {
+		return nil, err
+	}
+	if spec.get("scheme") == "auto" {
+		c.Header("Accept-CH", schemeHint)
+		c.Writer.Header().Add("Vary", schemeHint)
+	}
+	if err := img.setPalette(spec.get("palette")); err != nil {
+		return nil, err
+	}
+	img.setColors(spec.get("bg"), spec.get("fg"))
+	if config.strict && len(img.colorErrors) > 0 {
+		return nil, badRequest("invalid_color", "%s", strings.Join(img.colorErrors, " "))
+	}
+	if spec.get("bg") == "random" {
+		// Echo the pick so callers can pin it with ?bg=.
+		c.Header("X-Placeholder-Bg", strings.TrimPrefix(colors.Hex(img.bg), "#"))
+	}
+	img.setStyle(spec.get("shadow"), spec.get("outline"), spec.get("tracking"))
+	img.setBoxStyle(spec.get("style"))
+	if err := img.setLogo(spec.get("logo"), spec.get("logoPos"), spec.get("logoScale")); err != nil {
+		return nil, err
+	}
+	img.setFilters(spec.get("filter"), spec.get("brightness"), spec.get("contrast"), spec.get("duotone"))
+	img.setCells(spec.get("grid"))
+	img.setOverlay(spec.get("overlay"))
+	img.setGuides(spec.get("guides"))
+	img.setMarkup(spec.get("markup"))
+	img.setCompression(spec.get("compression"), spec.get("colors"))
+	img.setMeta(spec.get("meta"))
+	format := c.GetString("format")
+	if spec.has("format") {
+		format = spec.get("format")
+	}
+	if format == "" {
+		format = negotiateFormat(c.GetHeader("Accept"))
+		c.Writer.Header().Add("Vary", "Accept")
+	}
+	if err := img.setFormat(format); err != nil {
+		return nil, err
+	}
+	if err := img.setAnimation(spec.get("anim"), spec.get("fps"), spec.get("duration")); err != nil {
+		return nil, err
+	}
+	// The default text can show the format, so it is set last.
+	text, err := sanitizeTexts(spec.get("text"), spec.get("watermark"), spec.get("ribbon"))
+	if err != nil {
+		return nil, err
+	}
+	img.setText(text[0])
+	img.setTextPosition(spec.get("textPos"))
+	blocks, err := parseTextBlocks(spec.get, spec.get("texts"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode the image."})
+		return nil, err
+	}
+	for n := range blocks {
+		sanitized, err := sanitizeTexts(blocks[n].Text)
+		if err != nil {
+			return nil, err
+		}
+		blocks[n].Text = sanitized[0]
+	}
+	img.setTextBlocks(blocks)
+	if err := img.checkFontSizes(); err != nil {
+		return nil, err
+	}
+	img.setWatermark(text[1], spec.get("watermarkOpacity"))
+	img.setRibbon(text[2], spec.get("ribbonColor"), spec.get("ribbonPos"), spec.get("ribbonZ"))
+	// Plugins see the image as the other parameters left it.
+	if err := img.setLayers(spec.get("layers"), spec.get); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// inflight shares renders between the requests for the same cache key.
+var inflight renderGroup
+
+// serveRender responds with the cached output for key, or renders straight
+// to the response. Requests for a key that is already being rendered wait
+// for that render and respond with its output. The render goes on while
+// any of them waits, and is canceled once they have all gone away.
+func serveRender(c *gin.Context, key, contentType string, render func(ctx context.Context, w io.Writer) error) {
+	start := time.Now()
+	if renderCache != nil {
+		if bytes, ok := renderCache.get(key); ok {
+			setDiagnostics(c, "HIT", start, len(bytes))
+			sendOutput(c, contentType, bytes)
+			return
+		}
+	}
+
+	call, leader := inflight.join(key)
+	var stream *responseStream
+	if leader {
+		// The diagnostic headers and the Content-Length of HEAD responses
+		// need the whole output before the response starts, so it isn't
+		// streamed.
+		buffered := config.diagnosticHeaders || c.Request.Method == http.MethodHead
+		stream = &responseStream{c: c, contentType: contentType, buffered: buffered}
+		value, _ := c.Get(renderLimiterKey)
+		limiter, _ := value.(*RenderLimiter)
+		go inflight.run(c.Request.Context(), key, call, limiter, stream, render)
+	}
+	select {
+	case <-c.Request.Context().Done():
+		// The client went away, there is nobody to respond to.
+		if stream != nil {
+			stream.detach()
+		}
+		inflight.leave(key, call)
+		c.Abort()
+		return
+	case <-call.done:
+	}
+
+	if stream != nil && stream.started {
+		if call.err != nil {
+			log.Printf("Failed to stream image: %v", call.err)
+			abortResponse(c)
+		}
+		return
+	}
+	if call.err != nil {
+		renderError(c, call.err, "Failed to create an image.")
 		return
 	}
-	c.Data(http.StatusOK, "image/png", bytes)
+	// The request waited for another one's render, the output is buffered,
+	// or it is empty like the BlurHash of a blank image.
+	setDiagnostics(c, "MISS", start, len(call.output))
+	sendOutput(c, contentType, call.output)
 }
 
-func (i *Image) setSize(size string) {
-	dimensions := strings.Split(size, "x")
-	i.width, i.height = parseDimensions(dimensions)
+// sendOutput responds with a rendered image. HEAD requests only get the
+// headers, with the length the image would have.
+func sendOutput(c *gin.Context, contentType string, output []byte) {
+	if c.Request.Method == http.MethodHead {
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Length", strconv.Itoa(len(output)))
+		c.Status(http.StatusOK)
+		return
+	}
+	c.Data(http.StatusOK, contentType, output)
+}
+
+// blurhashHandler serves /blurhash/:size, a shorthand for ?format=blurhash.
+func blurhashHandler(c *gin.Context) {
+	c.Set("format", "blurhash")
+	imageHandler(c)
+}
+
+// cacheKey hashes the parsed parameters. Every parameter that affects the
+// output must be part of the key.
+func (i *Image) cacheKey() string {
+	spec := fmt.Sprintf("image|%d|%d|%q|%s|%v|%v|%v|%v|%v|%v|%v|%v|%v|%q|%v|%v|%q|%v|%v|%v|%v|%v|%v|%d|%d|%v|%v|%v|%v|%s|%s|%v|%d|%v|%v|%s|%d|%v|%v|%v|%s|%d|%v|%s|%d|%d|%v|%s|%s",
+		i.width, i.height, i.text, i.textPosition, i.blocks, i.fontSize, i.bg, i.fg, i.style, i.direction, i.orientation, i.noise, i.format,
+		i.seed, i.identicon, i.reproducible, i.logo, i.logoPosition, i.logoScale, i.filters, i.overlays, i.boxStyle, i.guides, i.columns, i.rows, i.dpi, i.pageWidth, i.pageHeight, i.markup, i.fontWeight, i.fontStyle, i.lineHeight, i.maxLines, i.ellipsis, i.hyphens, i.compression, i.colors, i.textRotate, i.watermark, i.ribbon, i.hinting, i.supersample, i.meta, i.anim, i.fps, i.frameCount, i.pluginNames, i.pluginParams.Encode(), i.fontFamily)
+	return renderKey(spec)
+}
+
+func renderError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, errSaturated):
+		c.Header("Retry-After", "1")
+		respondError(c, http.StatusServiceUnavailable, "server_busy", "Server is busy, try again later.")
+	case errors.Is(err, context.DeadlineExceeded):
+		respondError(c, http.StatusServiceUnavailable, "render_timeout", "Rendering took too long.")
+	case errors.Is(err, errUpstream):
+		respondError(c, http.StatusBadGateway, "upstream_failed", "Failed to fetch the source image.")
+	default:
+		respondError(c, http.StatusInternalServerError, "render_failed", message)
+	}
 }
 
-func parseDimensions(dimensions []string) (int, int) {
+func (i *Image) setSize(size string) error {
+	var width, height int
+	var err error
+	if mmWidth, mmHeight, ok := paperSize(size); ok {
+		width, height = i.setPhysicalSize(mmWidth/mmPerInch, mmHeight/mmPerInch)
+	} else if inWidth, inHeight, ok := physicalSize(size); ok {
+		width, height = i.setPhysicalSize(inWidth, inHeight)
+	} else {
+		width, height, err = parseDimensions(strings.Split(size, "x"))
+	}
+	if err != nil && config.strict {
+		return err
+	}
+
+	maxSize := ternary(i.maxSize > 0, i.maxSize, config.maxSize)
+	if config.strict {
+		if err := checkBounds(width, height, maxSize); err != nil {
+			return err
+		}
+	}
+
+	i.width = clamp(width, config.minSize, maxSize)
+	i.height = clamp(height, config.minSize, maxSize)
+	return checkPixels(i.width, i.height)
+}
+
+// setPhysicalSize records the page size of an image given in inches, and
+// returns its size in pixels at the image's resolution.
+func (i *Image) setPhysicalSize(inWidth, inHeight float64) (int, int) {
+	i.pageWidth, i.pageHeight = inWidth*72, inHeight*72
+	return int(math.Round(inWidth * i.resolution())), int(math.Round(inHeight * i.resolution()))
+}
+
+func parseDimensions(dimensions []string) (int, int, error) {
 	width, height := 150, 150
+	var invalid error
 	switch len(dimensions) {
 	case 2:
 		w, err := strconv.Atoi(dimensions[0])
 		if err == nil {
 			width = w
+		} else {
+			invalid = err
 		}
 		h, err := strconv.Atoi(dimensions[1])
 		if err == nil {
 			height = h
+		} else {
+			invalid = err
 		}
 	case 1:
 		s, err := strconv.Atoi(dimensions[0])
 		if err == nil {
 			width = s
 			height = s
+		} else {
+			invalid = err
 		}
+	default:
+		invalid = errors.New("too many dimensions")
 	}
-	return clamp(width, 150, 3000), clamp(height, 150, 3000)
-}
-
-func (i *Image) setColors(hexBg, hexFg string) {
-	i.bg = parseHexColor(hexBg, color.RGBA{0xD4, 0xD4, 0xD4, 0xFF})
-	i.fg = parseHexColor(hexFg, color.RGBA{0x73, 0x73, 0x73, 0xFF})
-}
-
-func parseHexColor(hex string, defaultColor color.RGBA) color.RGBA {
-	if len(hex) == 0 {
-		return defaultColor
+	if invalid != nil {
+		return width, height, badRequest("invalid_size", "Invalid size %q, expected WIDTHxHEIGHT or SIZE.", strings.Join(dimensions, "x"))
 	}
+	return width, height, nil
+}
 
-	if hex[0] == '#' {
-		hex = hex[1:]
-	}
-
-	if len(hex) >= 3 && len(hex) <= 8 {
-		if len(hex) == 3 {
-			hex = strings.Repeat(hex, 2)
-		}
-
-		if rgba, err := hexToRGBA(hex); err == nil {
-			return rgba
-		}
+func checkBounds(width, height, maxSize int) error {
+	if width < config.minSize || width > maxSize || height < config.minSize || height > maxSize {
+		return badRequest("size_out_of_range", "Size %dx%d is out of range, width and height must be between %d and %d.", width, height, config.minSize, maxSize)
 	}
-
-	return defaultColor
+	return nil
 }
 
-func hexToRGBA(hex string) (color.RGBA, error) {
-	// Remove the '#' symbol if it's included
-	if hex[0] == '#' {
-		hex = hex[1:]
+// setColors reads bg and fg. Without fg, or with fg=auto, the text color
+// is derived from the background so it stays readable, except for palette
+// and seeded colors which come as a pair.
+func (i *Image) setColors(bg, fg string) {
+	defaultBg := i.schemeBackground()
+	var pairFg color.RGBA
+	paired := true
+	switch {
+	case i.swatch != nil:
+		defaultBg, pairFg = i.swatch.bg, i.swatch.fg
+	case i.seed != "" && i.scheme == "dark":
+		defaultBg, pairFg = darkSeedColors(i.seed)
+	case i.seed != "":
+		defaultBg, pairFg = seedColors(i.seed)
+	default:
+		paired = false
 	}
-
-	if len(hex) <= 2 || len(hex) >= 9 {
-		return color.RGBA{}, errors.New("Hex should be 3-8 characters.")
+	if bg == "random" && i.reproducible {
+		// The seed stands in for chance, so the pick stays the same.
+		i.bg, _ = seedColors(i.seed)
+	} else if bg == "random" {
+		i.bg = randomColor()
+	} else {
+		i.bg = parseColor(bg, defaultBg)
 	}
 
-	if len(hex) == 3 {
-		var duplicated strings.Builder
+	switch {
+	case fg == "" && paired && bg == "":
+		i.fg = pairFg
+	case fg == "" || fg == "auto":
+		i.fg = contrastingColor(i.bg)
+	default:
+		i.fg = parseColor(fg, contrastingColor(i.bg))
+	}
 
-		for _, char := range hex {
-			duplicated.WriteString(string(char))
-			duplicated.WriteString(string(char))
+	// Invalid colors fall back to the default, ?format=json says why.
+	i.colorErrors = nil
+	for _, value := range []string{ternary(bg == "random", "", bg), ternary(fg == "auto", "", fg)} {
+		if _, err := colors.Parse(value); value != "" && err != nil {
+			i.colorErrors = append(i.colorErrors, err.Error())
 		}
-
-		hex = duplicated.String()
 	}
+}
 
-	r, err := strconv.ParseUint(hex[0:2], 16, 0)
-	if err != nil {
-		return color.RGBA{}, err
+// parseColor reads a hex, rgb() or hsl() color, and returns defaultColor
+// when value is empty or invalid.
+func parseColor(value string, defaultColor color.RGBA) color.RGBA {
+	if value == "" {
+		return defaultColor
 	}
-
-	g, err := strconv.ParseUint(hex[2:4], 16, 0)
-	if err != nil {
-		return color.RGBA{}, err
+	if c, err := colors.Parse(value); err == nil {
+		return c
 	}
+	return defaultColor
+}
 
-	b, err := strconv.ParseUint(hex[4:6], 16, 0)
-	if err != nil {
-		return color.RGBA{}, err
+func (i *Image) setText(text string) {
+	if len(text) > 0 {
+		i.text = shapeText(i.expandTokens(text))
+	} else if !i.identicon {
+		i.text = i.renderDefaultText()
 	}
+}
 
-	// 255 alpha as default
-	a := uint64(255)
-	if len(hex) == 8 {
-		alpha, err := strconv.ParseUint(hex[6:10], 16, 0)
-
-		if err != nil {
-			return color.RGBA{}, err
-		}
+func (i *Image) setFont(font string) {
+	i.fontSize = i.clampFontSize(parseFontSize(font, float64(i.width)/5))
+}
 
-		a = alpha
+// checkFontSizes budgets the faces of the text and its blocks before any
+// is built.
+func (i *Image) checkFontSizes() error {
+	size := i.fontSize
+	for _, block := range i.blocks {
+		size = math.Max(size, block.Size)
 	}
-
-	return color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: uint8(a)}, nil
+	return checkFontSize(size, i.supersample)
 }
 
-func (i *Image) setText(text string) {
-	if len(text) > 0 {
-		i.text = text
-	} else {
-		i.text = fmt.Sprintf("%dx%d", i.width, i.height)
+// clampFontSize bounds a font size by the longer side of the canvas, as
+// larger text can't be seen and its faces take memory with the square of
+// the size. Sizes that aren't positive fall back to the default.
+func (i *Image) clampFontSize(size float64) float64 {
+	if !(size > 0) {
+		size = float64(i.width) / 5
 	}
+	return math.Min(size, float64(max(i.width, i.height)))
 }
 
-func (i *Image) setFont(font string) {
-	i.fontSize = parseFontSize(font, float64(i.width)/5)
+// setLines reads ?lineHeight=1.4, relative to the font size, ?maxLines=3,
+// ?ellipsis=1, which ends truncated text in "…", and ?hyphens=1, which marks
+// where long words are broken.
+func (i *Image) setLines(lineHeight, maxLines, ellipsis, hyphens string) {
+	i.lineHeight, i.maxLines = 0, 0
+	if v, err := strconv.ParseFloat(lineHeight, 64); err == nil && v > 0 {
+		i.lineHeight = math.Min(v, 10)
+	}
+	if v, err := strconv.Atoi(maxLines); err == nil && v > 0 {
+		i.maxLines = v
+	}
+	i.ellipsis, _ = strconv.ParseBool(ellipsis)
+	i.hyphens, _ = strconv.ParseBool(hyphens)
 }
 
 func parseFontSize(font string, defaultSize float64) float64 {
@@ -186,101 +690,240 @@ func parseFontSize(font string, defaultSize float64) float64 {
 	return defaultSize
 }
 
-func (i *Image) apply() error {
-	img := image.NewRGBA(image.Rect(0, 0, i.width, i.height))
-	draw.Draw(img, img.Bounds(), &image.Uniform{i.bg}, image.Point{}, draw.Src)
+// setReproducible reads ?reproducible=1. DETERMINISTIC makes every render
+// reproducible.
+func (i *Image) setReproducible(value string) {
+	i.reproducible, _ = strconv.ParseBool(value)
+	i.reproducible = i.reproducible || config.deterministic
+}
 
-	// Add text
-	fontFace, err := freetype.ParseFont(goregular.TTF)
-	if err != nil {
-		return errors.New("Cannot parse font.")
-	}
+// faceOptions pins every rasterization option, with full hinting unless
+// ?hinting= says otherwise. Reproducible renders also
+// skip hinting and snap the font size to 1/64 of a point, so the output only
+// depends on integer math.
+func (i *Image) faceOptions() *truetype.Options {
+	return i.faceOptionsFor(i.fontSize)
+}
 
-	fontDrawer := &font.Drawer{
-		Dst: img,
-		Src: &image.Uniform{i.fg},
-		Face: truetype.NewFace(fontFace, &truetype.Options{
-			Size:    i.fontSize,
-			Hinting: font.HintingFull,
-		}),
+// faceOptionsFor returns the face options of the image at another size.
+func (i *Image) faceOptionsFor(size float64) *truetype.Options {
+	options := &truetype.Options{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
 	}
-
-	padding := 30
-	lines := wrapText(i.text, fontDrawer, float64(i.width-padding))
-
-	totalTextHeight := fixed.I(0)
-	for _, line := range lines {
-		textBounds, _ := fontDrawer.BoundString(line)
-		textHeight := textBounds.Max.Y - textBounds.Min.Y
-		textHeight = textHeight + (textHeight / 5)  // add space between lines
-		totalTextHeight += textHeight
+	if hinting, ok := hintings[i.hinting]; ok {
+		options.Hinting = hinting
+	}
+	if i.reproducible {
+		// The explicit conversion stops the compiler from fusing the
+		// multiply and add, which changes rounding on arm64.
+		options.Size = math.Floor(float64(size*64)+0.5) / 64
+		options.Hinting = font.HintingNone
 	}
+	return options
+}
 
-	// Calculate the starting yPosition to center the text vertically
-	yPosition := (fixed.I(img.Rect.Max.Y) - totalTextHeight) / 2
+// apply renders the layers of the image into i.data.
+func (i *Image) apply(ctx context.Context) error {
+	img := newPooledRGBA(image.Rect(0, 0, i.width, i.height))
+	for _, layer := range i.layers() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := layer.Draw(ctx, img); err != nil {
+			return err
+		}
+	}
+	i.data = img
 
-	// Draw each line of text
-	for _, line := range lines {
-		textBounds, _ := fontDrawer.BoundString(line)
-		xPosition := (fixed.I(img.Rect.Max.X) - fontDrawer.MeasureString(line)) / 2
-		textHeight := textBounds.Max.Y - textBounds.Min.Y 
-		textHeight = textHeight + (textHeight / 5)  // add space between lines
+	return nil
+}
 
-		// Adjust yPosition for each line
-		yPosition += textHeight
+// release returns the pixels of the rendered image to the buffer pool.
+func (i *Image) release() {
+	releaseRGBA(i.data)
+	i.data = nil
+}
 
-		fontDrawer.Dot = fixed.Point26_6{
-			X: xPosition,
-			Y: yPosition,
+func (i *Image) drawText(ctx context.Context, dst *image.RGBA) error {
+	if i.supersample > 1 {
+		return i.drawSupersampled(ctx, dst)
+	}
+	if regularFontErr != nil {
+		return errors.New("Cannot parse font.")
+	}
+	var err error
+	if i.markup {
+		err = i.drawRichText(ctx, dst)
+	} else {
+		err = i.drawBlock(ctx, dst, TextBlock{i.text, i.fontSize, i.textPosition})
+	}
+	for _, block := range i.blocks {
+		if err != nil {
+			return err
 		}
-
-		fontDrawer.DrawString(line)
+		err = i.drawBlock(ctx, dst, block)
 	}
+	return err
+}
 
-	i.data = img
+// drawBlock lays out and draws a block of text in the style of the image.
+func (i *Image) drawBlock(ctx context.Context, dst *image.RGBA, block TextBlock) error {
+	fontFace, face := lookupFont(i.fontKey())
 
+	options := i.faceOptionsFor(block.Size)
+	fontDrawer := &font.Drawer{
+		Dst:  dst,
+		Src:  &image.Uniform{i.fg},
+		Face: newFace(fontFace, options),
+	}
+
+	width, height := dst.Bounds().Dx(), dst.Bounds().Dy()
+	key := layoutKey{face.String(), options.Size, options.Hinting, i.style.tracking, i.direction, block.Text, width, height,
+		block.Position, i.lineHeight, i.maxLines, i.ellipsis, i.hyphens}
+	for _, line := range cachedLayout(key, fontDrawer).lines {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := i.style.draw(ctx, fontDrawer, line, i.fg); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func wrapText(text string, drawer *font.Drawer, maxWidth float64) []string {
-	var lines []string
-	var currentLine string
-	var currentWidth float64
+func (i *Image) setFormat(format string) error {
+	switch format {
+	case "blurhash", "lqip", "datauri", "datauri-json", "pdf", "json", "apng", "gif":
+		i.format = format
+	case "avif":
+		if !avifSupported {
+			return badRequest("unsupported_format", "AVIF is not supported by this server.")
+		}
+		i.format = format
+	default:
+		i.format = "png"
+	}
+	return nil
+}
 
-	words := strings.Fields(text)
+func (i *Image) contentType() string {
+	switch i.format {
+	case "blurhash", "datauri":
+		return "text/plain; charset=utf-8"
+	case "lqip", "datauri-json":
+		return "application/json; charset=utf-8"
+	case "pdf":
+		return "application/pdf"
+	case "avif":
+		return "image/avif"
+	case "apng":
+		return "image/apng"
+	case "gif":
+		return "image/gif"
+	default:
+		return "image/png"
+	}
+}
 
-	for _, word := range words {
-		testLine := currentLine
-		if len(testLine) > 0 {
-			testLine += " "
+// generate encodes the rendered image to w in the requested format.
+func (i *Image) generate(ctx context.Context, w io.Writer) error {
+	w = &contextWriter{ctx, w}
+	switch i.format {
+	case "blurhash":
+		_, err := io.WriteString(w, blurhash(i.data))
+		return err
+	case "lqip":
+		data, err := lqip(i.data)
+		if err != nil {
+			return err
 		}
-		testLine += word
-		currentWidth = float64(drawer.MeasureString(testLine) / 64.0)
-
-		if currentWidth > maxWidth {
-			if len(currentLine) > 0 {
-				lines = append(lines, currentLine)
-			}
-			currentLine = word
-		} else {
-			if len(currentLine) > 0 {
-				currentLine += " "
-			}
-			currentLine += word
+		_, err = w.Write(data)
+		return err
+	case "pdf":
+		width, height := i.pageSize()
+		data, err := encodePDF(i.data, width, height)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "avif":
+		return encodeAVIF(w, i.data)
+	case "apng":
+		return i.encodeAPNG(ctx, w)
+	case "gif":
+		return i.encodeGIF(ctx, w)
+	case "datauri", "datauri-json":
+		buffer := new(bytes.Buffer)
+		if err := i.encodePNG(buffer); err != nil {
+			return err
+		}
+		uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes())
+		if i.format == "datauri" {
+			_, err := io.WriteString(w, uri)
+			return err
+		}
+		data, err := json.Marshal(map[string]any{"width": i.width, "height": i.height, "dataURI": uri})
+		if err != nil {
+			return err
 		}
+		_, err = w.Write(data)
+		return err
+	}
+	return i.encodePNG(w)
+}
+
+// encodePNG encodes the rendered image as a PNG with its metadata.
+func (i *Image) encodePNG(w io.Writer) error {
+	if chunks := i.pngChunks(); len(chunks) > 0 {
+		w = &insertWriter{w: w, at: ihdrEnd, insert: chunks}
+	}
+	return i.encodeRGBA(w, i.data)
+}
+
+// encodeRGBA encodes img, the image or a frame around it, as a PNG with the
+// compression and colors of the request.
+func (i *Image) encodeRGBA(w io.Writer, img *image.RGBA) error {
+	if i.colors > 0 {
+		return i.encoder().Encode(w, quantize(img, i.colors))
 	}
+	return i.encoder().Encode(w, img)
+}
 
-	if len(currentLine) > 0 {
-		lines = append(lines, currentLine)
+// pngChunks returns the chunks that follow the IHDR of PNGs: the resolution
+// and the metadata.
+func (i *Image) pngChunks() []byte {
+	var chunks []byte
+	if i.physical() {
+		chunks = append(chunks, physChunk(i.resolution())...)
+	}
+	if i.meta {
+		// The time is only recorded with METADATA_TIME, as it makes every
+		// render of the same image differ. Reproducible renders never have it.
+		created := ternary(config.metadataTime && !i.reproducible, time.Now(), time.Time{})
+		chunks = append(chunks, metadataChunks(i.parameters(), created)...)
 	}
+	return chunks
+}
+
+// pngEncoder encodes the PNGs of routes without ?compression=, like charts
+// and QR codes, with PNG_COMPRESSION.
+var pngEncoder = &png.Encoder{CompressionLevel: compressionLevels[config.pngCompression]}
 
-	return lines
+// contextWriter fails writes once its context is done, which aborts an
+// encoder that is still running.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
 }
 
-func (i *Image) generate() ([]byte, error) {
-	buffer := new(bytes.Buffer)
-	err := png.Encode(buffer, i.data)
-	return buffer.Bytes(), err
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
 }
 
 func clamp(value, min, max int) int {
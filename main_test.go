@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCancelledRequestKeepsSharedRender(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var renders atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	render := func(ctx context.Context, w io.Writer) error {
+		if renders.Add(1) == 1 {
+			close(started)
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		_, err := w.Write([]byte("image"))
+		return err
+	}
+	serve := func(ctx context.Context) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/300x200", nil).WithContext(ctx)
+		serveRender(c, "shared-render", "image/png", render)
+		return w
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serve(ctx) }()
+	<-started
+	second := make(chan *httptest.ResponseRecorder)
+	go func() { second <- serve(context.Background()) }()
+	// Give the second request time to wait for the render.
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	<-first
+	close(release)
+	w := <-second
+	if w.Code != http.StatusOK || w.Body.String() != "image" {
+		t.Errorf("waiting request got %d %q, want 200 image", w.Code, w.Body)
+	}
+	if n := renders.Load(); n != 1 {
+		t.Errorf("rendered %d times, want once", n)
+	}
+}
+
+func TestSharedRenderCanceledWhenEveryoneLeaves(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	started, canceled := make(chan struct{}), make(chan struct{})
+	render := func(ctx context.Context, w io.Writer) error {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/300x200", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		serveRender(c, "abandoned-render", "image/png", render)
+		close(done)
+	}()
+	<-started
+	cancel()
+	<-done
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("render kept running after its only client went away")
+	}
+}
+
+func TestSharedRenderHoldsLimiterSlot(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := newRenderLimiter(1, 0, 10*time.Millisecond)
+	started, release := make(chan struct{}), make(chan struct{})
+	render := func(ctx context.Context, w io.Writer) error {
+		close(started)
+		<-release
+		_, err := w.Write([]byte("image"))
+		return err
+	}
+	serve := func(ctx context.Context, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/300x200", nil).WithContext(ctx)
+		c.Set(renderLimiterKey, limiter)
+		serveRender(c, key, "image/png", render)
+		return w
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serve(ctx, "slow-render") }()
+	<-started
+	cancel()
+	<-first
+	// The first client is gone, but its render still holds the only slot.
+	if w := serve(context.Background(), "other-render"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d while the slot was taken, want 503", w.Code)
+	}
+	close(release)
+}
+
+func TestSharedRenderStreamsToFirstRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/300x200", nil)
+	serveRender(c, "streamed-render", "image/png", func(ctx context.Context, w io.Writer) error {
+		_, err := w.Write([]byte("image"))
+		return err
+	})
+	if w.Code != http.StatusOK || w.Body.String() != "image" || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("got %d %q %q, want 200 image/png image", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxMetadataBytes keeps the parameters within a single JPEG segment.
+const maxMetadataBytes = 60000
+
+// setMeta reads ?meta=0, which leaves the metadata out.
+func (i *Image) setMeta(value string) {
+	i.meta = true
+	if meta, err := strconv.ParseBool(value); err == nil {
+		i.meta = meta
+	}
+}
+
+// parameters returns the parsed parameters as JSON, as /describe shows
+// them.
+func (i *Image) parameters() string {
+	data, _ := json.Marshal(i.describe())
+	return string(data)
+}
+
+// metadataChunks returns PNG chunks that record SERVICE_NAME as the
+// software, the time of the render unless it is zero, and the parameters as
+// the description. The description is an iTXt chunk, as the text can be in any language and
+// tEXt only holds Latin-1.
+func metadataChunks(parameters string, created time.Time) []byte {
+	var chunks []byte
+	chunks = append(chunks, pngChunk("tEXt", []byte("Software\x00"+config.serviceName))...)
+	if !created.IsZero() {
+		chunks = append(chunks, pngChunk("tEXt", []byte("Creation Time\x00"+created.UTC().Format(http.TimeFormat)))...)
+	}
+	// The keyword is followed by the compression flag and method, and the
+	// empty language tag and translated keyword.
+	chunks = append(chunks, pngChunk("iTXt", []byte("Description\x00\x00\x00\x00\x00"+truncateMetadata(parameters)))...)
+	return chunks
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	chunk := make([]byte, 8, 8+len(data)+4)
+	binary.BigEndian.PutUint32(chunk[0:], uint32(len(data)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// exifSegment returns a JPEG APP1 segment with the same fields as
+// metadataChunks, as the ImageDescription, Software and DateTime tags.
+// DateTime is left out when created is zero.
+func exifSegment(parameters string, created time.Time) []byte {
+	type entry struct {
+		tag   uint16
+		value string
+	}
+	entries := []entry{
+		{0x010E, truncateMetadata(parameters)},
+		{0x0131, config.serviceName},
+	}
+	if !created.IsZero() {
+		entries = append(entries, entry{0x0132, created.UTC().Format("2006:01:02 15:04:05")})
+	}
+
+	// The big-endian TIFF header is followed by the one directory, and
+	// the values that don't fit in its 4 byte fields.
+	tiff := []byte("MM\x00\x2A\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(len(entries)))
+	var values []byte
+	valuesStart := len(tiff) + len(entries)*12 + 4
+	for _, e := range entries {
+		value := append([]byte(e.value), 0)
+		tiff = binary.BigEndian.AppendUint16(tiff, e.tag)
+		tiff = binary.BigEndian.AppendUint16(tiff, 2) // ASCII
+		tiff = binary.BigEndian.AppendUint32(tiff, uint32(len(value)))
+		if len(value) <= 4 {
+			tiff = append(tiff, append(value, make([]byte, 4-len(value))...)...)
+			continue
+		}
+		tiff = binary.BigEndian.AppendUint32(tiff, uint32(valuesStart+len(values)))
+		values = append(values, value...)
+	}
+	tiff = binary.BigEndian.AppendUint32(tiff, 0) // No next directory.
+	tiff = append(tiff, values...)
+
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+6+len(tiff)))
+	segment = append(segment, "Exif\x00\x00"...)
+	return append(segment, tiff...)
+}
+
+// truncateMetadata cuts parameters that don't fit in a JPEG segment.
+func truncateMetadata(parameters string) string {
+	if len(parameters) <= maxMetadataBytes {
+		return parameters
+	}
+	return string(bytes.ToValidUTF8([]byte(parameters[:maxMetadataBytes]), nil))
+}
+
+// withExif inserts the EXIF segment right after the start of image marker
+// of a JPEG written to w.
+func withExif(w io.Writer, parameters string) io.Writer {
+	created := ternary(config.metadataTime && !config.deterministic, time.Now(), time.Time{})
+	return &insertWriter{w: w, at: 2, insert: exifSegment(parameters, created)}
+}
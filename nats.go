@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsQueueGroup spreads the jobs of a subject over every worker
+// subscribed to it, instead of sending each job to all of them.
+const natsQueueGroup = "placeholder-workers"
+
+// NATSQueue receives and sends messages on a NATS subject, with the text
+// protocol of core NATS. Core NATS delivers at most once, so jobs sent while
+// no worker is connected are lost.
+type NATSQueue struct {
+	server  *url.URL
+	subject string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newNATSQueue connects to a subject given like nats://host:4222/renders,
+// with a user and password or a token in the URL when the server needs
+// them.
+func newNATSQueue(subject *url.URL) *NATSQueue {
+	return &NATSQueue{server: subject, subject: strings.TrimPrefix(subject.Path, "/")}
+}
+
+// connect dials the server when there's no connection yet, and subscribes
+// when subscribe is set.
+func (q *NATSQueue) connect(subscribe bool) error {
+	if q.conn != nil {
+		return nil
+	}
+	host := q.server.Host
+	if q.server.Port() == "" {
+		host = net.JoinHostPort(host, "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	// The server greets with INFO.
+	if _, err := reader.ReadString('\n'); err != nil {
+		conn.Close()
+		return err
+	}
+
+	options := map[string]any{"verbose": false, "pedantic": false, "name": config.serviceName}
+	if user := q.server.User; user != nil {
+		if password, ok := user.Password(); ok {
+			options["user"], options["pass"] = user.Username(), password
+		} else {
+			options["auth_token"] = user.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+	commands := "CONNECT " + string(connect) + "\r\n"
+	if subscribe {
+		commands += fmt.Sprintf("SUB %s %s 1\r\n", q.subject, natsQueueGroup)
+	}
+	if _, err := io.WriteString(conn, commands+"PING\r\n"); err != nil {
+		conn.Close()
+		return err
+	}
+	// Errors like a failed authorization arrive before the PONG.
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "PONG") {
+		conn.Close()
+		return fmt.Errorf("NATS refused the connection: %s", strings.TrimSpace(line))
+	}
+	q.conn, q.reader = conn, reader
+	return nil
+}
+
+// close drops the connection, so the next call reconnects.
+func (q *NATSQueue) close() {
+	if q.conn != nil {
+		q.conn.Close()
+		q.conn, q.reader = nil, nil
+	}
+}
+
+func (q *NATSQueue) receive(ctx context.Context) ([]delivery, error) {
+	if err := q.connect(true); err != nil {
+		return nil, err
+	}
+	// Closing the connection ends a read that waits for messages.
+	conn := q.conn
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		line, err := q.reader.ReadString('\n')
+		if err != nil {
+			q.close()
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			q.mu.Lock()
+			_, err = io.WriteString(q.conn, "PONG\r\n")
+			q.mu.Unlock()
+			if err != nil {
+				q.close()
+				return nil, err
+			}
+		case "-ERR":
+			q.close()
+			return nil, fmt.Errorf("NATS error: %s", strings.Join(fields[1:], " "))
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <size>, then the payload.
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				q.close()
+				return nil, fmt.Errorf("malformed NATS message: %q", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(q.reader, payload); err != nil {
+				q.close()
+				return nil, err
+			}
+			return []delivery{{body: payload[:size], ack: func() error { return nil }}}, nil
+		}
+	}
+}
+
+func (q *NATSQueue) publish(body []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.connect(false); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(q.conn, "PUB %s %d\r\n%s\r\n", q.subject, len(body), body)
+	if err != nil {
+		q.close()
+	}
+	return err
+}
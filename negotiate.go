@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// negotiateFormat picks the output format from an Accept header, for
+// requests without ?format=. PNG is the fallback every client takes, AVIF is
+// only sent to clients that list it, as browsers send */* whether or not they
+// can decode it. There is no WebP encoder, so image/webp gets a PNG.
+func negotiateFormat(accept string) string {
+	if avifSupported {
+		avif := acceptQuality(accept, "image/avif", false)
+		if avif > 0 && avif >= acceptQuality(accept, "image/png", true) {
+			return "avif"
+		}
+	}
+	return "png"
+}
+
+// acceptQuality returns the q value an Accept header gives a media type. An
+// exact match takes precedence over image/* and */*, which only count when
+// wildcards is set.
+func acceptQuality(accept, mediaType string, wildcards bool) float64 {
+	exact, wildcard, all := -1.0, -1.0, -1.0
+	for _, entry := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if key == "q" {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case mediaType:
+			exact = quality
+		case "image/*":
+			wildcard = quality
+		case "*/*":
+			all = quality
+		}
+	}
+
+	switch {
+	case exact >= 0:
+		return exact
+	case wildcards && wildcard >= 0:
+		return wildcard
+	case wildcards && all >= 0:
+		return all
+	}
+	return 0
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// objectGetTimeout bounds reads of the cache on the request path, so a slow
+// bucket falls back to rendering instead of holding the request.
+const objectGetTimeout = 2 * time.Second
+
+// ObjectCache stores encoded images in an S3 compatible bucket, so every
+// replica behind a load balancer shares the same renders.
+type ObjectCache struct {
+	client    *http.Client
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+}
+
+func newObjectCache(endpoint, region string) (*ObjectCache, error) {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if config.cacheBucket == "" {
+		return nil, errors.New("CACHE_BUCKET is required for object storage caches")
+	}
+
+	return &ObjectCache{
+		client:    &http.Client{Timeout: 10 * time.Second},
+		endpoint:  parsed,
+		region:    region,
+		bucket:    config.cacheBucket,
+		prefix:    config.cachePrefix,
+		accessKey: config.cacheAccessKey,
+		secretKey: config.cacheSecretKey,
+	}, nil
+}
+
+func (o *ObjectCache) get(key string) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), objectGetTimeout)
+	defer cancel()
+	response, err := o.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		log.Printf("Failed to read %s from object storage: %v", key, err)
+		return nil, false
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false
+	default:
+		log.Printf("Failed to read %s from object storage: %s", key, response.Status)
+		return nil, false
+	}
+	limit := maxCachedBytes()
+	data, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		log.Printf("Failed to read %s from object storage: %v", key, err)
+		return nil, false
+	}
+	if int64(len(data)) > limit {
+		log.Printf("Ignoring %s in object storage, it is larger than any render", key)
+		return nil, false
+	}
+	return data, true
+}
+
+// maxCachedBytes is the size of the largest image a render can produce, an
+// uncompressed RGBA canvas of MAX_PIXELS with room for the container around
+// it. Objects in the bucket that are larger weren't written by a render.
+func maxCachedBytes() int64 {
+	pixels := int64(config.maxPixels)
+	if pixels <= 0 {
+		pixels = 1 << 25
+	}
+	return 4*pixels + 1<<20
+}
+
+func (o *ObjectCache) put(key string, data []byte) error {
+	return o.store(key, data, "application/octet-stream")
+}
+
+// store uploads an object with its Content-Type, for objects that are
+// served from the bucket.
+func (o *ObjectCache) store(key string, data []byte, contentType string) error {
+	response, err := o.request(context.Background(), http.MethodPut, "/"+o.bucket+"/"+o.prefix+key, nil, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("object storage responded with %s", response.Status)
+	}
+	return nil
+}
+
+// remove deletes an object. S3 also answers deletes of missing objects with
+// a 204, so only backends that answer with a 404, like GCS, report them.
+func (o *ObjectCache) remove(key string) (bool, error) {
+	response, err := o.do(context.Background(), http.MethodDelete, key, nil)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("object storage responded with %s", response.Status)
+	}
+}
+
+// purge deletes every object under the prefix, a page of keys at a time.
+// It refuses to when the prefix would take the whole bucket or the outputs
+// of workers with it.
+func (o *ObjectCache) purge() (int, error) {
+	if o.prefix == "" {
+		return 0, errors.New("refusing to purge the whole bucket, set CACHE_PREFIX")
+	}
+	if strings.HasPrefix(config.workerPrefix, o.prefix) {
+		return 0, fmt.Errorf("refusing to purge %q, it holds the worker outputs under %q", o.prefix, config.workerPrefix)
+	}
+	count := 0
+	token := ""
+	for {
+		page, err := o.list(token)
+		if err != nil {
+			return count, err
+		}
+		for _, object := range page.Contents {
+			if _, err := o.remove(strings.TrimPrefix(object.Key, o.prefix)); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if !page.IsTruncated {
+			return count, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+type objectList struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns a page of ListObjectsV2.
+func (o *ObjectCache) list(token string) (*objectList, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {o.prefix}}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	response, err := o.request(context.Background(), http.MethodGet, "/"+o.bucket+"/", query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("object storage responded with %s", response.Status)
+	}
+	page := &objectList{}
+	return page, xml.NewDecoder(response.Body).Decode(page)
+}
+
+func (o *ObjectCache) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	return o.request(ctx, method, "/"+o.bucket+"/"+o.prefix+key, nil, body, "")
+}
+
+func (o *ObjectCache) request(ctx context.Context, method, path string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	target := *o.endpoint
+	target.Path = path
+	target.RawQuery = canonicalQuery(query)
+
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", ternary(contentType != "", contentType, "application/octet-stream"))
+	}
+	signV4(request, body, time.Now().UTC(), awsCredentials{o.region, "s3", o.accessKey, o.secretKey})
+	return o.client.Do(request)
+}
+
+// awsCredentials are what signV4 needs to sign a request to one service.
+type awsCredentials struct {
+	region    string
+	service   string
+	accessKey string
+	secretKey string
+}
+
+// signV4 adds an AWS Signature Version 4 authorization header. The host and
+// every X-Amz header are signed.
+func signV4(request *http.Request, body []byte, now time.Time, credentials awsCredentials) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{"host"}
+	headers := []string{"host:" + request.URL.Host}
+	for name := range request.Header {
+		if strings.HasPrefix(name, "X-Amz-") {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names[1:] {
+		headers = append(headers, name+":"+strings.TrimSpace(request.Header.Get(name)))
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		strings.Join(headers, "\n"),
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + credentials.region + "/" + credentials.service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.secretKey), date)
+	key = hmacSHA256(key, credentials.region)
+	key = hmacSHA256(key, credentials.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes a query string the way Signature Version 4 expects,
+// sorted by name and with spaces as %20.
+func canonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Social cards use the size Open Graph and Twitter both recommend.
+const ogWidth, ogHeight = 1200, 630
+
+// textRegion is a box that text is wrapped into, aligned left, center or
+// right. Text that needs more than maxLines lines is cut off with an
+// ellipsis.
+type textRegion struct {
+	rect     image.Rectangle
+	size     float64
+	maxLines int
+	align    string
+}
+
+type ogTemplate struct {
+	bg       color.RGBA
+	fg       color.RGBA
+	accent   color.RGBA
+	title    textRegion
+	subtitle textRegion
+	footer   textRegion
+	// accentBar is drawn in the accent color, usually along an edge.
+	accentBar image.Rectangle
+	// logo is the box for the logo badge, left of the footer.
+	logo image.Rectangle
+}
+
+var ogTemplates = map[string]ogTemplate{
+	"default": {
+		bg:        color.RGBA{0x1F, 0x29, 0x37, 0xFF},
+		fg:        color.RGBA{0xF9, 0xFA, 0xFB, 0xFF},
+		accent:    color.RGBA{0x63, 0x66, 0xF1, 0xFF},
+		title:     textRegion{image.Rect(100, 110, 1100, 350), 64, 3, "left"},
+		subtitle:  textRegion{image.Rect(100, 370, 1100, 460), 34, 2, "left"},
+		footer:    textRegion{image.Rect(180, 520, 1100, 560), 28, 1, "left"},
+		accentBar: image.Rect(0, 0, 24, ogHeight),
+		logo:      image.Rect(100, 505, 160, 565),
+	},
+	"centered": {
+		bg:        color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+		fg:        color.RGBA{0x11, 0x18, 0x27, 0xFF},
+		accent:    color.RGBA{0xF5, 0x9E, 0x0B, 0xFF},
+		title:     textRegion{image.Rect(120, 150, 1080, 400), 68, 3, "center"},
+		subtitle:  textRegion{image.Rect(120, 420, 1080, 500), 32, 2, "center"},
+		footer:    textRegion{image.Rect(120, 540, 1080, 580), 26, 1, "center"},
+		accentBar: image.Rect(0, ogHeight-16, ogWidth, ogHeight),
+		logo:      image.Rect(560, 50, 640, 130),
+	},
+	"minimal": {
+		bg:       color.RGBA{0xF3, 0xF4, 0xF6, 0xFF},
+		fg:       color.RGBA{0x37, 0x41, 0x51, 0xFF},
+		accent:   color.RGBA{0x10, 0xB9, 0x81, 0xFF},
+		title:    textRegion{image.Rect(80, 80, 1120, 400), 80, 3, "left"},
+		subtitle: textRegion{image.Rect(80, 430, 1120, 500), 32, 1, "left"},
+		footer:   textRegion{image.Rect(150, 545, 1120, 585), 26, 1, "left"},
+		logo:     image.Rect(80, 530, 130, 580),
+	},
+}
+
+type OGCard struct {
+	template string
+	title    string
+	subtitle string
+	footer   string
+	logo     string
+	bg       color.RGBA
+	fg       color.RGBA
+	accent   color.RGBA
+}
+
+func ogHandler(c *gin.Context) {
+	name := c.Param("template")
+	template, ok := ogTemplates[name]
+	if !ok {
+		problem(c, http.StatusNotFound, "unknown_template", fmt.Sprintf("Unknown template %q.", name))
+		return
+	}
+	texts, err := sanitizeTexts(c.Query("title"), c.Query("subtitle"), c.Query("footer"))
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	card := &OGCard{
+		template: name,
+		title:    shapeText(texts[0]),
+		subtitle: shapeText(texts[1]),
+		footer:   shapeText(texts[2]),
+		logo:     c.Query("logo"),
+		bg:       parseColor(c.Query("bg"), template.bg),
+		fg:       parseColor(c.Query("fg"), template.fg),
+		accent:   parseColor(c.Query("accent"), template.accent),
+	}
+
+	serveRender(c, card.cacheKey(), "image/png", card.render)
+}
+
+func (o *OGCard) cacheKey() string {
+	spec := fmt.Sprintf("og|%s|%q|%q|%q|%q|%v|%v|%v",
+		o.template, o.title, o.subtitle, o.footer, o.logo, o.bg, o.fg, o.accent)
+	return renderKey(spec)
+}
+
+func (o *OGCard) render(ctx context.Context, w io.Writer) error {
+	if regularFontErr != nil {
+		return regularFontErr
+	}
+	template := ogTemplates[o.template]
+
+	img := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{o.bg}, image.Point{}, draw.Src)
+	draw.Draw(img, template.accentBar, &image.Uniform{o.accent}, image.Point{}, draw.Src)
+
+	drawRegion(img, template.title, o.title, regularFont, o.fg)
+	drawRegion(img, template.subtitle, o.subtitle, regularFont, o.fg)
+	drawRegion(img, template.footer, o.footer, regularFont, o.fg)
+	if o.logo != "" {
+		drawBadge(img, template.logo, initials(o.logo), o.accent, o.bg)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return pngEncoder.Encode(&contextWriter{ctx, w}, img)
+}
+
+// drawRegion wraps text into region, top aligned.
+func drawRegion(img *image.RGBA, region textRegion, text string, face *truetype.Font, fg color.RGBA) {
+	if text == "" {
+		return
+	}
+
+	drawer := &font.Drawer{
+		Dst: img,
+		Src: &image.Uniform{fg},
+		Face: newFace(face, &truetype.Options{
+			Size:    region.size,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		}),
+	}
+
+	maxWidth := fixed.I(region.rect.Dx())
+	lines := wrapText(text, drawer, 0, maxWidth, false)
+	if len(lines) > region.maxLines {
+		lines = lines[:region.maxLines]
+		lines[len(lines)-1] = ellipsize(drawer, lines[len(lines)-1], 0, maxWidth)
+	}
+
+	lineHeight := fixed.Int26_6(region.size * 1.2 * 64)
+	drawer.Dot.Y = fixed.I(region.rect.Min.Y) + drawer.Face.Metrics().Ascent
+	for _, line := range lines {
+		line = visualOrder(line, "auto")
+		drawer.Dot.X = fixed.I(region.rect.Min.X)
+		switch region.align {
+		case "center":
+			drawer.Dot.X += (maxWidth - drawer.MeasureString(line)) / 2
+		case "right":
+			drawer.Dot.X += maxWidth - drawer.MeasureString(line)
+		}
+		drawer.DrawString(line)
+		drawer.Dot.Y += lineHeight
+	}
+}
+
+// drawBadge draws text centered in a circle filling rect.
+func drawBadge(img *image.RGBA, rect image.Rectangle, text string, bg, fg color.RGBA) {
+	size := ternary(rect.Dx() < rect.Dy(), rect.Dx(), rect.Dy())
+	draw.DrawMask(img, rect, &image.Uniform{bg}, image.Point{}, circleMask(size), image.Point{}, draw.Over)
+
+	drawer := &font.Drawer{
+		Dst: img,
+		Src: &image.Uniform{fg},
+		Face: newFace(regularFont, &truetype.Options{
+			Size:    float64(size) * 0.42,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		}),
+	}
+	bounds, advance := drawer.BoundString(text)
+	drawer.Dot = fixed.Point26_6{
+		X: fixed.I(rect.Min.X) + (fixed.I(size)-advance)/2,
+		Y: fixed.I(rect.Min.Y) + (fixed.I(size)-(bounds.Max.Y-bounds.Min.Y))/2 - bounds.Min.Y,
+	}
+	drawer.DrawString(text)
+}
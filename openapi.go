@@ -0,0 +1,255 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gitkumi/placeholder/layer"
+)
+
+// parameter describes one query or path parameter of a route.
+type parameter struct {
+	name        string
+	in          string
+	kind        string
+	description string
+	enum        []string
+}
+
+// endpoint describes a public rendering route for the OpenAPI document.
+type endpoint struct {
+	path         string
+	summary      string
+	contentTypes []string
+	parameters   []parameter
+}
+
+func query(name, kind, description string, enum ...string) parameter {
+	return parameter{name, "query", kind, description, enum}
+}
+
+func path(name, description string, enum ...string) parameter {
+	return parameter{name, "path", "string", description, enum}
+}
+
+// imageParameters are the query parameters parseImage reads.
+func imageParameters() []parameter {
+	formats := []string{"png", "blurhash", "lqip", "datauri", "datauri-json", "apng", "gif", "pdf", "json"}
+	if avifSupported {
+		formats = append(formats, "avif")
+	}
+	return []parameter{
+		query("text", "string", "Text to draw, defaults to the size."),
+		query("textPos", "string", "Where the text sits.", "top", "center", "bottom"),
+		query("text2", "string", "Another block of text, like a caption. text3 and text4 work the same."),
+		query("text2Size", "number", "Font size of text2, half of fontSize by default."),
+		query("text2Pos", "string", "Where text2 sits, bottom by default.", "top", "center", "bottom"),
+		query("texts", "string", `Blocks of text as JSON, like [{"text": "Caption", "size": 18, "pos": "bottom"}].`),
+		query("fontWeight", "string", "Font weight, CSS numbers are mapped to the closest.", "regular", "medium", "bold"),
+		query("fontStyle", "string", "Font style.", "normal", "italic"),
+		query("lineHeight", "number", "Distance between baselines relative to the font size."),
+		query("maxLines", "integer", "Drops lines past this count."),
+		query("ellipsis", "boolean", "Ends truncated text in an ellipsis, and truncates at the canvas height without maxLines."),
+		query("hyphens", "boolean", "Adds a hyphen where words too wide for a line are broken."),
+		query("markup", "boolean", "Reads **bold**, //italic// and # or ## heading lines in text."),
+		query("fontSize", "number", "Font size in points, defaults to a fifth of the width."),
+		query("hinting", "string", "Glyph hinting, full by default.", sortedKeys(hintings)...),
+		query("supersample", "integer", "Draws the text 2 to 4 times larger and scales it down."),
+		query("bg", "string", "Background color as hex."),
+		query("fg", "string", "Text color as hex."),
+		query("seed", "string", "Derives stable colors from any string."),
+		query("scheme", "string", "Default colors for light or dark mode, auto follows Sec-CH-Prefers-Color-Scheme.", "light", "dark", "auto"),
+		query("palette", "string", "Picks colors from a palette, by the seed when given.", paletteNames()...),
+		query("identicon", "boolean", "Draws an identicon from the seed instead of text."),
+		query("dir", "string", "Text direction.", "auto", "ltr", "rtl"),
+		query("orientation", "string", "Text orientation.", "horizontal", "vertical"),
+		query("textRotate", "number", "Degrees to turn the text clockwise around the center, negative for counterclockwise."),
+		query("noise", "number", "Film grain from 0 to 1."),
+		query("shadow", "string", "Text shadow as x,y[,color]."),
+		query("outline", "string", "Text outline as width[,color], with a width up to 8."),
+		query("tracking", "number", "Extra space between letters in pixels."),
+		query("style", "string", "Box style.", "plain", "cross"),
+		query("logo", "string", "A LOGO_PRESETS name or an allowlisted URL."),
+		query("logoPos", "string", "Logo position.", "tl", "tr", "bl", "br", "c"),
+		query("logoScale", "number", "Logo width as a fraction of the image width."),
+		query("watermark", "string", "Text repeated diagonally across the image."),
+		query("watermarkOpacity", "number", "Watermark opacity from 0 to 1, 0.15 by default."),
+		query("ribbon", "string", "Label of a diagonal ribbon across a corner."),
+		query("ribbonColor", "string", "Ribbon color, e11d48 by default."),
+		query("ribbonPos", "string", "Corner of the ribbon.", sortedKeys(ribbonAngles)...),
+		query("ribbonZ", "string", "Draws the ribbon over or under the text.", "above", "below"),
+		query("filter", "string", "Comma separated filters.", sortedKeys(filterFuncs)...),
+		query("brightness", "number", "Brightness multiplier."),
+		query("contrast", "number", "Contrast multiplier."),
+		query("duotone", "string", "Duotone colors as shadow,highlight."),
+		query("grid", "string", "Labelled cells as COLUMNSxROWS."),
+		query("overlay", "string", "Comma separated overlays, ruler and grid:N."),
+		query("guides", "string", "Comma separated guides, thirds, safe and center."),
+		query("dpi", "number", "Resolution for physical sizes and PNG metadata."),
+		query("reproducible", "boolean", "Renders identically on every platform."),
+		query("meta", "boolean", "Writes the parameters into PNG metadata, on by default."),
+		query("compression", "string", "PNG compression, defaults to PNG_COMPRESSION, which routes without this parameter always use.", sortedKeys(compressionLevels)...),
+		query("colors", "integer", "Reduces a PNG to a palette of 2 to 256 colors."),
+		query("anim", "string", "Animates the image as an APNG, or a GIF with format=gif.", sortedKeys(animations)...),
+		query("fps", "integer", "Frames per second of anim, from 1 to 30."),
+		query("duration", "number", "Seconds an anim loop lasts, up to 10."),
+		query("layers", "string", "Comma separated plugin layers, drawn over the watermark.", layer.Names()...),
+		query("format", "string", "Output format, negotiated from Accept when absent.", formats...),
+		query("onerror", "string", "Response to a failed render, an error image or JSON. Defaults to the image when Accept starts with image/.", "image", "json"),
+	}
+}
+
+func endpoints() []endpoint {
+	colors := []parameter{
+		query("bg", "string", "Background color as hex."),
+		query("fg", "string", "Foreground color as hex."),
+	}
+	size := path("size", "WIDTHxHEIGHT, SIZE, a physical size like 85x55mm or a paper size like a4.")
+
+	return []endpoint{
+		{"/{size}", "Placeholder image", []string{"image/png", "image/avif", "application/pdf", "text/plain", "application/json"},
+			append([]parameter{size}, imageParameters()...)},
+		{"/blurhash/{size}", "BlurHash of a placeholder image", []string{"text/plain"},
+			append([]parameter{size}, imageParameters()...)},
+		{"/pair/{size}", "URLs of the light and dark variants", []string{"application/json"},
+			append([]parameter{size}, imageParameters()...)},
+		{"/snippet/{size}", "<img> markup with a srcset at 1x, 2x and 3x", []string{"text/plain"},
+			append([]parameter{size}, imageParameters()...)},
+		{"/set/{size}", "The image at several widths, as a manifest or a ZIP", []string{"application/json", "application/zip"},
+			append([]parameter{size, query("widths", "string", "Comma separated widths, 320,640,1280 by default."),
+				query("archive", "string", "Renders every width into a ZIP.", "zip")}, imageParameters()...)},
+		{"/avatar/{size}", "Avatar with initials", []string{"image/png"},
+			append([]parameter{path("size", "Size in pixels."), query("name", "string", "Name to take the initials from."),
+				query("circle", "boolean", "Crops the avatar to a circle.")}, colors...)},
+		{"/qr/{size}", "QR code", []string{"image/png"},
+			append([]parameter{path("size", "Size in pixels."), query("data", "string", "Data to encode."),
+				query("ecc", "string", "Error correction level.", "L", "M", "Q", "H")}, colors...)},
+		{"/barcode/{size}", "Barcode", []string{"image/png"},
+			append([]parameter{size, query("type", "string", "Symbology.", sortedKeys(barcodeEncoders)...),
+				query("data", "string", "Data to encode."), query("label", "boolean", "Prints the data under the bars.")}, colors...)},
+		{"/og/{template}", "Social card", []string{"image/png"},
+			append([]parameter{path("template", "Card layout.", sortedKeys(ogTemplates)...),
+				query("title", "string", "Title."), query("subtitle", "string", "Subtitle."), query("footer", "string", "Footer."),
+				query("logo", "string", "A LOGO_PRESETS name or an allowlisted URL."), query("accent", "string", "Accent color as hex.")}, colors...)},
+		{"/t/{name}", "Template from TEMPLATES_DIR, variables are passed as query parameters", []string{"image/png"},
+			[]parameter{path("name", "Template name.", templateNames()...)}},
+		{"/photo/{size}", "Stock photo from PHOTOS_DIR", []string{"image/jpeg"},
+			[]parameter{size, query("seed", "string", "Picks a stable photo."), query("grayscale", "boolean", "Removes color."),
+				query("blur", "integer", "Blur radius from 0 to 10."), query("meta", "boolean", "Writes EXIF metadata, on by default.")}},
+		{"/proxy/{size}", "Remote image resized to fit", []string{"image/png", "image/jpeg"},
+			[]parameter{size, query("src", "string", "URL on the fetch allowlist."), query("fit", "string", "Resize mode.", "cover", "contain"),
+				query("bg", "string", "Padding color for contain."), query("format", "string", "Output format.", "png", "jpeg")}},
+		{"/device/{model}", "Placeholder in a device frame", []string{"image/png"},
+			append([]parameter{path("model", "Device.", sortedKeys(devices)...)}, imageParameters()...)},
+		{"/chart/{size}", "Chart", []string{"image/png"},
+			append([]parameter{size, query("type", "string", "Chart type.", "bar", "line", "pie"),
+				query("series", "string", "Comma separated values."), query("seed", "string", "Derives stable colors from any string.")}, colors...)},
+		{"/progress/{size}", "Progress bar", []string{"image/png"},
+			[]parameter{size, query("value", "number", "Percentage filled, 50 by default."),
+				query("label", "string", "Label on the bar, the rounded percentage by default, or none."),
+				query("radius", "number", "Corner radius in pixels, a pill by default."),
+				query("bg", "string", "Color around the bar, transparent by default."), query("bar", "string", "Color of the filled part."),
+				query("track", "string", "Color of the empty part."), query("fg", "string", "Label color, contrasting by default.")}},
+		{"/video/{size}", "Silent video of the placeholder, when ffmpeg is installed", []string{"video/mp4", "video/webm"},
+			append([]parameter{size, query("duration", "integer", "Length in seconds, from 1 to 30."),
+				query("format", "string", "Container.", "mp4", "webm")},
+				slices.DeleteFunc(imageParameters(), func(p parameter) bool { return p.name == "format" })...)},
+		{"/favicon.ico", "Favicon", []string{"image/x-icon", "image/png"},
+			append([]parameter{query("text", "string", "One or two characters."), query("size", "integer", "Serves a single PNG of this size."),
+				query("circle", "boolean", "Crops the icon to a circle.")}, colors...)},
+		{"/apple-touch-icon.png", "Home screen icon", []string{"image/png"},
+			append([]parameter{query("text", "string", "One or two characters.")}, colors...)},
+	}
+}
+
+// openapiHandler serves an OpenAPI 3 document built from the endpoint
+// list, with enums taken from the registries the handlers use.
+func openapiHandler(c *gin.Context) {
+	paths := gin.H{}
+	for _, e := range endpoints() {
+		parameters := []gin.H{}
+		for _, p := range e.parameters {
+			schema := gin.H{"type": p.kind}
+			if len(p.enum) > 0 {
+				schema["enum"] = p.enum
+			}
+			parameters = append(parameters, gin.H{
+				"name":        p.name,
+				"in":          p.in,
+				"required":    p.in == "path",
+				"description": p.description,
+				"schema":      schema,
+			})
+		}
+
+		content := gin.H{}
+		for _, contentType := range e.contentTypes {
+			content[contentType] = gin.H{}
+		}
+		paths[e.path] = gin.H{
+			"get": gin.H{
+				"summary":    e.summary,
+				"parameters": parameters,
+				"responses": gin.H{
+					"200": gin.H{"description": "The rendered output.", "content": content},
+					"400": gin.H{"description": "Invalid parameters."},
+				},
+			},
+			"head": gin.H{
+				"summary":    e.summary + ", headers only",
+				"parameters": parameters,
+				"responses": gin.H{
+					"200": gin.H{"description": "The headers of the rendered output, with its Content-Length."},
+					"400": gin.H{"description": "Invalid parameters."},
+				},
+			},
+		}
+	}
+
+	if config.urlSigningKey == "" {
+		properties := gin.H{"size": gin.H{"type": "string", "description": "WIDTHxHEIGHT, SIZE, a physical size like 85x55mm or a paper size like a4."}}
+		for _, p := range imageParameters() {
+			property := gin.H{"type": p.kind, "description": p.description}
+			if len(p.enum) > 0 {
+				property["enum"] = p.enum
+			}
+			properties[p.name] = property
+		}
+		paths["/render"] = gin.H{
+			"post": gin.H{
+				"summary": "Placeholder image from a JSON spec, with the parameters of /{size}",
+				"requestBody": gin.H{
+					"required": true,
+					"content": gin.H{"application/json": gin.H{"schema": gin.H{
+						"type":                 "object",
+						"required":             []string{"size"},
+						"properties":           properties,
+						"additionalProperties": true,
+					}}},
+				},
+				"responses": gin.H{
+					"200": gin.H{"description": "The rendered output."},
+					"400": gin.H{"description": "Invalid spec or parameters."},
+				},
+			},
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"openapi": "3.0.3",
+		"info":    gin.H{"title": "placeholder", "version": "1.0.0"},
+		"paths":   paths,
+	})
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
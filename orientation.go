@@ -0,0 +1,57 @@
+package main
+
+import (
+	"image"
+	"math"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+func (i *Image) setOrientation(orientation string) {
+	i.orientation = ternary(orientation == "vertical", "vertical", "horizontal")
+}
+
+// setTextRotate reads ?textRotate=-45, the angle in degrees by which the
+// text turns clockwise around the center, like CSS rotate().
+func (i *Image) setTextRotate(value string) {
+	i.textRotate = 0
+	if degrees, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(degrees, 0) && !math.IsNaN(degrees) {
+		i.textRotate = math.Mod(degrees, 360)
+	}
+}
+
+// drawRotated draws src centered over the bounds of dst, turned clockwise by
+// degrees around the center. Corners that turn past the edges are cut off.
+func drawRotated(dst, src *image.RGBA, degrees float64) {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	sx, sy := float64(src.Bounds().Dx())/2, float64(src.Bounds().Dy())/2
+	bounds := dst.Bounds()
+	dx, dy := float64(bounds.Min.X)+float64(bounds.Dx())/2, float64(bounds.Min.Y)+float64(bounds.Dy())/2
+	// The matrix maps src to dst: it moves the center of src to the
+	// origin, rotates, and moves it to the center of dst. With y pointing
+	// down, this turns clockwise.
+	matrix := f64.Aff3{
+		cos, -sin, dx - cos*sx + sin*sy,
+		sin, cos, dy - sin*sx - cos*sy,
+	}
+	draw.BiLinear.Transform(dst, matrix, src, src.Bounds(), draw.Over, nil)
+}
+
+// rotateClockwise returns src turned 90° clockwise, so text reads top to
+// bottom.
+func rotateClockwise(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, height, width))
+
+	for y := 0; y < width; y++ {
+		for x := 0; x < height; x++ {
+			from := src.PixOffset(bounds.Min.X+y, bounds.Min.Y+height-1-x)
+			to := dst.PixOffset(x, y)
+			copy(dst.Pix[to:to+4], src.Pix[from:from+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// setOverlay reads a comma separated list of measurement overlays: "ruler"
+// for tick marks along the top and left edges, and "grid:N" for a grid
+// every N pixels.
+func (i *Image) setOverlay(value string) {
+	i.overlays = nil
+	for _, overlay := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(overlay, ":")
+		switch name {
+		case "ruler":
+			i.overlays = append(i.overlays, "ruler")
+		case "grid":
+			step, err := strconv.Atoi(arg)
+			if err != nil {
+				step = 8
+			}
+			i.overlays = append(i.overlays, "grid:"+strconv.Itoa(clamp(step, 2, 1000)))
+		}
+	}
+}
+
+func (i *Image) drawOverlays(img *image.RGBA) {
+	for _, overlay := range i.overlays {
+		name, arg, _ := strings.Cut(overlay, ":")
+		switch name {
+		case "ruler":
+			drawRuler(img, i.fg)
+		case "grid":
+			step, _ := strconv.Atoi(arg)
+			drawGrid(img, step, i.fg)
+		}
+	}
+}
+
+// drawGrid draws faint one pixel lines every step pixels.
+func drawGrid(img *image.RGBA, step int, fg color.RGBA) {
+	line := &image.Uniform{color.NRGBA{fg.R, fg.G, fg.B, 0x40}}
+	bounds := img.Bounds()
+	for x := step; x < bounds.Dx(); x += step {
+		draw.Draw(img, image.Rect(x, 0, x+1, bounds.Dy()), line, image.Point{}, draw.Over)
+	}
+	for y := step; y < bounds.Dy(); y += step {
+		draw.Draw(img, image.Rect(0, y, bounds.Dx(), y+1), line, image.Point{}, draw.Over)
+	}
+}
+
+// drawRuler draws ticks along the top and left edges every 10 pixels, longer
+// every 50, and labels every 100 pixels.
+func drawRuler(img *image.RGBA, fg color.RGBA) {
+	ink := &image.Uniform{fg}
+	bounds := img.Bounds()
+	tickLength := func(n int) int {
+		switch {
+		case n%100 == 0:
+			return 12
+		case n%50 == 0:
+			return 8
+		default:
+			return 4
+		}
+	}
+
+	for x := 10; x < bounds.Dx(); x += 10 {
+		draw.Draw(img, image.Rect(x, 0, x+1, tickLength(x)), ink, image.Point{}, draw.Src)
+	}
+	for y := 10; y < bounds.Dy(); y += 10 {
+		draw.Draw(img, image.Rect(0, y, tickLength(y), y+1), ink, image.Point{}, draw.Src)
+	}
+
+	if regularFontErr != nil {
+		return
+	}
+	drawer := &font.Drawer{
+		Dst: img,
+		Src: ink,
+		Face: newFace(regularFont, &truetype.Options{
+			Size:    10,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		}),
+	}
+	for x := 100; x < bounds.Dx(); x += 100 {
+		drawer.Dot = fixed.P(x+2, 22)
+		drawer.DrawString(strconv.Itoa(x))
+	}
+	for y := 100; y < bounds.Dy(); y += 100 {
+		drawer.Dot = fixed.P(14, y+4)
+		drawer.DrawString(strconv.Itoa(y))
+	}
+}
+
+// setGuides reads a comma separated list of layout guides: "thirds" for
+// rule of thirds lines, "safe" for the broadcast action and title safe
+// areas and "center" for crosshairs.
+func (i *Image) setGuides(value string) {
+	i.guides = nil
+	for _, guide := range strings.Split(value, ",") {
+		switch guide {
+		case "thirds", "safe", "center":
+			i.guides = append(i.guides, guide)
+		}
+	}
+}
+
+func (i *Image) drawGuides(img *image.RGBA) {
+	ink := &image.Uniform{color.NRGBA{i.fg.R, i.fg.G, i.fg.B, 0xA0}}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	for _, guide := range i.guides {
+		switch guide {
+		case "thirds":
+			for n := 1; n <= 2; n++ {
+				x, y := width*n/3, height*n/3
+				draw.Draw(img, image.Rect(x, 0, x+1, height), ink, image.Point{}, draw.Over)
+				draw.Draw(img, image.Rect(0, y, width, y+1), ink, image.Point{}, draw.Over)
+			}
+		case "safe":
+			// Action safe is 93% and title safe 90% of the frame.
+			for _, percent := range []int{93, 90} {
+				insetX, insetY := width*(100-percent)/200, height*(100-percent)/200
+				drawOutline(img, image.Rect(insetX, insetY, width-insetX, height-insetY), ink)
+			}
+		case "center":
+			arm := ternary(width < height, width, height) / 20
+			x, y := width/2, height/2
+			draw.Draw(img, image.Rect(x-arm, y, x+arm+1, y+1), ink, image.Point{}, draw.Over)
+			draw.Draw(img, image.Rect(x, y-arm, x+1, y), ink, image.Point{}, draw.Over)
+			draw.Draw(img, image.Rect(x, y+1, x+1, y+arm+1), ink, image.Point{}, draw.Over)
+		}
+	}
+}
+
+// drawOutline draws the one pixel border just inside rect.
+func drawOutline(img *image.RGBA, rect image.Rectangle, ink image.Image) {
+	draw.Draw(img, image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+1), ink, image.Point{}, draw.Over)
+	draw.Draw(img, image.Rect(rect.Min.X, rect.Max.Y-1, rect.Max.X, rect.Max.Y), ink, image.Point{}, draw.Over)
+	draw.Draw(img, image.Rect(rect.Min.X, rect.Min.Y+1, rect.Min.X+1, rect.Max.Y-1), ink, image.Point{}, draw.Over)
+	draw.Draw(img, image.Rect(rect.Max.X-1, rect.Min.Y+1, rect.Max.X, rect.Max.Y-1), ink, image.Point{}, draw.Over)
+}
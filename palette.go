@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"log"
+	"maps"
+	"math/rand"
+	"os"
+
+	colors "github.com/gitkumi/placeholder/color"
+)
+
+// Swatch is one background and text color pair of a palette.
+type Swatch struct {
+	bg color.RGBA
+	fg color.RGBA
+}
+
+//go:embed palettes/palettes.json
+var builtinPalettes embed.FS
+
+// palettes holds the built-in palettes and those from PALETTES_FILE, which
+// win on name clashes.
+var palettes = loadPalettes(config.palettesFile)
+
+// loadPalettes reads palettes as JSON: an object of palette names to arrays
+// of {"bg": "#ffd1dc", "fg": "#5a2a3a"} swatches. fg is optional and derived
+// from bg when missing.
+func loadPalettes(file string) map[string][]Swatch {
+	loaded, err := readPalettes(file)
+	if err != nil {
+		log.Printf("Failed to load palettes: %v", err)
+	}
+	return loaded
+}
+
+// readPalettes returns the built-in palettes and those in file. On error,
+// the built-in ones are still returned.
+func readPalettes(file string) (map[string][]Swatch, error) {
+	loaded := map[string][]Swatch{}
+	data, _ := builtinPalettes.ReadFile("palettes/palettes.json")
+	if err := parsePalettes(data, loaded); err != nil {
+		panic(err)
+	}
+
+	if file == "" {
+		return loaded, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return loaded, err
+	}
+	builtin := maps.Clone(loaded)
+	if err := parsePalettes(data, loaded); err != nil {
+		return builtin, err
+	}
+	return loaded, nil
+}
+
+func parsePalettes(data []byte, into map[string][]Swatch) error {
+	var definitions map[string][]struct {
+		Bg string `json:"bg"`
+		Fg string `json:"fg"`
+	}
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return err
+	}
+
+	for name, definition := range definitions {
+		if len(definition) == 0 {
+			return fmt.Errorf("palette %s has no colors", name)
+		}
+		swatches := make([]Swatch, len(definition))
+		for n, swatch := range definition {
+			bg, err := colors.Parse(swatch.Bg)
+			if err != nil {
+				return fmt.Errorf("palette %s: %v", name, err)
+			}
+			fg := contrastingColor(bg)
+			if swatch.Fg != "" {
+				if fg, err = colors.Parse(swatch.Fg); err != nil {
+					return fmt.Errorf("palette %s: %v", name, err)
+				}
+			}
+			swatches[n] = Swatch{bg, fg}
+		}
+		into[name] = swatches
+	}
+	return nil
+}
+
+// setPalette picks a swatch from ?palette=, by the seed when there is one
+// or the render is reproducible, and at random otherwise. Explicit bg and
+// fg still win.
+func (i *Image) setPalette(name string) error {
+	i.swatch = nil
+	if name == "" {
+		return nil
+	}
+	swatches, ok := lookupPalette(name)
+	if !ok {
+		return badRequest("unknown_palette", "Unknown palette %q.", name)
+	}
+
+	index := rand.Intn(len(swatches))
+	if i.seed != "" || i.reproducible {
+		sum := sha256.Sum256([]byte(i.seed))
+		index = int(binary.BigEndian.Uint32(sum[:]) % uint32(len(swatches)))
+	}
+	i.swatch = &swatches[index]
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+)
+
+// pageSize returns the size of a PDF page in points. Physical sizes keep
+// their size even when the raster was clamped, pixel sizes are converted
+// at the image's DPI.
+func (i *Image) pageSize() (float64, float64) {
+	if i.pageWidth > 0 && i.pageHeight > 0 {
+		return i.pageWidth, i.pageHeight
+	}
+	return float64(i.width) * 72 / i.resolution(), float64(i.height) * 72 / i.resolution()
+}
+
+// encodePDF writes a single page PDF of width x height points, with img
+// stretched over the whole page.
+func encodePDF(img *image.RGBA, width, height float64) ([]byte, error) {
+	bounds := img.Bounds()
+
+	// PDF images have no alpha here, so pixels are flattened onto white.
+	var pixels bytes.Buffer
+	compressor := zlib.NewWriter(&pixels)
+	row := make([]byte, 3*bounds.Dx())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			white := 255 - int(c.A)
+			n := 3 * (x - bounds.Min.X)
+			row[n], row[n+1], row[n+2] = uint8(int(c.R)+white), uint8(int(c.G)+white), uint8(int(c.B)+white)
+		}
+		if _, err := compressor.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+
+	w, h := formatPoints(width), formatPoints(height)
+	content := fmt.Sprintf("q %s 0 0 %s 0 0 cm /Im0 Do Q", w, h)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /XObject << /Im0 4 0 R >> >> /Contents 5 0 R >>", w, h),
+		fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+			bounds.Dx(), bounds.Dy(), pixels.Len(), pixels.Bytes()),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	offsets := make([]int, len(objects))
+	for n, object := range objects {
+		offsets[n] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", n+1, object)
+	}
+
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes(), nil
+}
+
+func formatPoints(points float64) string {
+	return strconv.FormatFloat(math.Round(points*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// photos are the files in PHOTOS_DIR, sorted so a seed always picks the same
+// photo.
+var photos = listPhotos(config.photosDir)
+
+// decodedPhotos keeps recently served originals, which are expensive to
+// decode.
+var decodedPhotos = newSizedLRUCache[string, image.Image](config.photoCacheSize, config.photoCacheBytes, imageBytes)
+
+func listPhotos(dir string) []string {
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Failed to read photos: %v", err)
+		return nil
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+type Photo struct {
+	width     int
+	height    int
+	path      string
+	grayscale bool
+	blur      int
+	meta      bool
+}
+
+func photoHandler(c *gin.Context) {
+	img := &Image{maxSize: maxSizeFor(c)}
+	if err := img.setSize(c.Param("size")); err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if len(photos) == 0 {
+		problem(c, http.StatusNotFound, "no_photos", "No photos are configured.")
+		return
+	}
+
+	// Without a seed every request gets a random photo, unless the server
+	// is DETERMINISTIC.
+	index := rand.Intn(len(photos))
+	if seed := c.Query("seed"); seed != "" || config.deterministic {
+		sum := sha256.Sum256([]byte(seed))
+		index = int(binary.BigEndian.Uint32(sum[:]) % uint32(len(photos)))
+	}
+
+	photo := &Photo{
+		width:  img.width,
+		height: img.height,
+		path:   photos[index],
+	}
+	photo.grayscale, _ = strconv.ParseBool(c.Query("grayscale"))
+	if radius, err := strconv.Atoi(c.Query("blur")); err == nil {
+		photo.blur = clamp(radius, 0, 10)
+	}
+	img.setMeta(c.Query("meta"))
+	photo.meta = img.meta
+
+	serveRender(c, photo.cacheKey(), "image/jpeg", photo.render)
+}
+
+func (p *Photo) cacheKey() string {
+	spec := fmt.Sprintf("photo|%d|%d|%q|%v|%d|%v", p.width, p.height, p.path, p.grayscale, p.blur, p.meta)
+	return renderKey(spec)
+}
+
+func (p *Photo) render(ctx context.Context, w io.Writer) error {
+	original, ok := decodedPhotos.get(p.path)
+	if !ok {
+		file, err := os.Open(p.path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if original, _, err = image.Decode(file); err != nil {
+			return err
+		}
+		decodedPhotos.add(p.path, original)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	img := cover(original, p.width, p.height)
+	if p.grayscale {
+		grayscale(img)
+	}
+	// The radius is relative to a 1000 pixel wide image, so the blur looks
+	// the same at every size.
+	blur(img, p.blur*p.width/1000+ternary(p.blur > 0, 1, 0))
+
+	w = &contextWriter{ctx, w}
+	if p.meta {
+		w = withExif(w, p.parameters())
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
+
+// parameters returns the parameters of the photo as JSON, for its metadata.
+func (p *Photo) parameters() string {
+	data, _ := json.Marshal(map[string]any{
+		"width":     p.width,
+		"height":    p.height,
+		"photo":     filepath.Base(p.path),
+		"grayscale": p.grayscale,
+		"blur":      p.blur,
+	})
+	return string(data)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The playground is plain HTML, CSS and JavaScript embedded in the binary, so
+// it works from a single file deploy.
+//
+//go:embed playground
+var playgroundFiles embed.FS
+
+var playgroundFS = func() http.FileSystem {
+	sub, err := fs.Sub(playgroundFiles, "playground")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(sub)
+}()
+
+// playgroundHandler serves the playground page at /.
+func playgroundHandler(c *gin.Context) {
+	c.FileFromFS("/", playgroundFS)
+}
+
+// playgroundAssetHandler serves the playground's scripts and styles.
+func playgroundAssetHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.FileFromFS(c.Param("filepath"), playgroundFS)
+}
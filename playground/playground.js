@@ -0,0 +1,251 @@
+"use strict";
+
+const controls = document.getElementById("controls");
+const urlLabel = document.getElementById("url");
+const image = document.getElementById("image");
+const text = document.getElementById("text");
+const error = document.getElementById("error");
+
+// Formats that are not images are fetched and shown as text.
+const textFormats = new Set(["blurhash", "lqip", "datauri", "datauri-json", "json"]);
+
+// spec builds the path and query of the current controls, the same string
+// collections store.
+function spec() {
+  const form = new FormData(controls);
+  const width = form.get("width") || "150";
+  const height = form.get("height") || width;
+  const params = new URLSearchParams();
+
+  for (const name of ["text", "fontSize", "seed"]) {
+    if (form.get(name)) params.set(name, form.get(name));
+  }
+  if (!form.get("seed")) {
+    params.set("bg", form.get("bg").slice(1));
+    params.set("fg", form.get("fg").slice(1));
+  }
+  if (form.get("format") !== "png") params.set("format", form.get("format"));
+
+  const query = params.toString();
+  return `${width}x${height}` + (query ? `?${query}` : "");
+}
+
+let pending;
+
+// Images are previewed over a WebSocket when the server offers one, which
+// only renders the latest update while typing. Other formats, and servers
+// without /ws/preview, use plain requests.
+let socket;
+let socketReady = false;
+let lastID = 0;
+let frame;
+
+// A socket that closes after it opened, when the server restarts or the
+// network drops, is reconnected, waiting longer after each failed attempt.
+// Servers that never accept it don't offer /ws/preview.
+let reconnectDelay = 1000;
+let connected = false;
+
+function connect() {
+  const url = new URL("/ws/preview", location.href);
+  url.protocol = location.protocol === "https:" ? "wss:" : "ws:";
+  socket = new WebSocket(url);
+  socket.binaryType = "blob";
+  socket.addEventListener("open", () => {
+    socketReady = true;
+    connected = true;
+    reconnectDelay = 1000;
+    update();
+  });
+  socket.addEventListener("close", () => {
+    socketReady = false;
+    if (!connected) return;
+    setTimeout(connect, reconnectDelay);
+    reconnectDelay = Math.min(reconnectDelay * 2, 30000);
+  });
+  socket.addEventListener("message", (event) => {
+    if (typeof event.data === "string") {
+      frame = JSON.parse(event.data);
+      if (frame.status !== 200 && frame.id === lastID) {
+        error.textContent = frame.detail || frame.code;
+        error.hidden = false;
+      }
+      return;
+    }
+    // Binary messages are the image of the frame announced before.
+    if (frame.id !== lastID) return;
+    URL.revokeObjectURL(image.src);
+    image.src = URL.createObjectURL(new Blob([event.data], { type: frame.contentType }));
+    image.hidden = false;
+    text.hidden = true;
+  });
+}
+
+async function update() {
+  const path = "/" + spec();
+  const format = new FormData(controls).get("format");
+  urlLabel.textContent = location.origin + path;
+  error.hidden = true;
+  lastID++;
+
+  if (socketReady && !textFormats.has(format) && format !== "pdf") {
+    if (pending) pending.abort();
+    socket.send(JSON.stringify({ id: lastID, spec: spec() }));
+    return;
+  }
+
+  if (pending) pending.abort();
+  pending = new AbortController();
+
+  try {
+    const response = await fetch(path, { signal: pending.signal });
+    if (!response.ok) {
+      const body = await response.json().catch(() => ({}));
+      throw new Error(body.detail || response.statusText);
+    }
+
+    if (textFormats.has(format)) {
+      text.textContent = await response.text();
+    } else if (format === "pdf") {
+      text.textContent = "PDF, open the URL to view it.";
+    } else {
+      const blob = await response.blob();
+      URL.revokeObjectURL(image.src);
+      image.src = URL.createObjectURL(blob);
+    }
+    const showImage = !textFormats.has(format) && format !== "pdf";
+    image.hidden = !showImage;
+    text.hidden = showImage;
+  } catch (err) {
+    if (err.name === "AbortError") return;
+    error.textContent = err.message;
+    error.hidden = false;
+  }
+}
+
+// Debounce typing so every keystroke does not render an image.
+let timer;
+controls.addEventListener("input", () => {
+  clearTimeout(timer);
+  timer = setTimeout(update, 250);
+});
+
+document.getElementById("copy").addEventListener("click", () => {
+  navigator.clipboard.writeText(urlLabel.textContent);
+});
+
+// Collections belong to the API key of the save form, sent as a bearer
+// token.
+const save = document.getElementById("save");
+const saved = document.getElementById("saved");
+const collection = document.getElementById("collection");
+
+function collectionRequest(path, options = {}) {
+  const key = save.elements.key.value;
+  return fetch(path, {
+    ...options,
+    headers: { ...options.headers, Authorization: `Bearer ${key}` },
+  });
+}
+
+// showProblem shows the detail of a failed collection request.
+async function showProblem(response) {
+  const body = await response.json().catch(() => ({}));
+  saved.textContent = body.detail || response.statusText;
+  saved.hidden = false;
+}
+
+function shareLink(id) {
+  const link = document.createElement("a");
+  link.href = `/collections/${id}`;
+  link.textContent = location.origin + link.getAttribute("href");
+  return link;
+}
+
+save.addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const form = new FormData(event.target);
+
+  const response = await collectionRequest("/collections", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ name: form.get("name"), spec: spec() }),
+  });
+  if (!response.ok) {
+    await showProblem(response);
+    return;
+  }
+  const body = await response.json();
+  saved.hidden = false;
+  saved.replaceChildren("Saved as ", shareLink(body.id));
+  if (!collection.hidden) listCollection();
+});
+
+// listCollection shows the saved specs of the key, with the link they are
+// re-rendered and shared at.
+async function listCollection() {
+  const response = await collectionRequest("/collections");
+  if (!response.ok) {
+    await showProblem(response);
+    return;
+  }
+  const specs = (await response.json()) || [];
+  collection.replaceChildren(
+    ...specs.map((item) => {
+      const entry = document.createElement("li");
+      const remove = document.createElement("button");
+      remove.type = "button";
+      remove.textContent = "Delete";
+      remove.addEventListener("click", async () => {
+        const response = await collectionRequest(`/collections/${item.id}`, { method: "DELETE" });
+        if (!response.ok) {
+          await showProblem(response);
+          return;
+        }
+        entry.remove();
+      });
+      entry.append(item.name || item.spec, shareLink(item.id), remove);
+      return entry;
+    })
+  );
+  if (specs.length === 0) collection.replaceChildren("Nothing saved yet.");
+  collection.hidden = false;
+}
+
+document.getElementById("list").addEventListener("click", () => {
+  if (save.elements.key.reportValidity()) listCollection();
+});
+
+// The manifest is downloaded through a request, as links can't send the
+// key as a header.
+document.getElementById("export").addEventListener("click", async () => {
+  if (!save.elements.key.reportValidity()) return;
+  const response = await collectionRequest("/collections/export");
+  if (!response.ok) {
+    await showProblem(response);
+    return;
+  }
+  const link = document.createElement("a");
+  link.href = URL.createObjectURL(await response.blob());
+  link.download = "manifest.json";
+  link.click();
+  URL.revokeObjectURL(link.href);
+});
+
+// The formats come from the OpenAPI document, so AVIF only shows up on
+// builds that support it.
+async function loadFormats() {
+  const response = await fetch("/openapi.json");
+  const openapi = await response.json();
+  const parameters = openapi.paths["/{size}"].get.parameters;
+  const format = parameters.find((p) => p.name === "format");
+  const select = controls.elements.format;
+  select.replaceChildren(
+    ...format.schema.enum.map((name) => new Option(name, name))
+  );
+}
+
+loadFormats().catch(() => {}).finally(() => {
+  update();
+  connect();
+});
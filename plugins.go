@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gitkumi/placeholder/layer"
+)
+
+// maxPluginLayers caps the layers of ?layers=.
+const maxPluginLayers = 8
+
+// setLayers reads ?layers=mycorp-frame,mycorp-stamp, the plugin layers
+// registered with layer.Register, to draw in that order over the watermark.
+// The options the layers declare are read before the factories run, so all
+// of them are part of the cache key.
+func (i *Image) setLayers(names string, read func(string) string) error {
+	i.plugins, i.pluginNames, i.pluginParams = nil, nil, url.Values{}
+	if names == "" {
+		return nil
+	}
+	list := strings.Split(names, ",")
+	if len(list) > maxPluginLayers {
+		return badRequest("invalid_layers", "An image can have at most %d layers.", maxPluginLayers)
+	}
+	described := layer.Image{Width: i.width, Height: i.height, Background: i.bg, Foreground: i.fg, Text: i.text, Seed: i.seed}
+	for _, name := range list {
+		factory, params, ok := layer.Lookup(name)
+		if !ok {
+			return badRequest("unknown_layer", "Layer %q is not registered on this server.", name)
+		}
+		declared := url.Values{}
+		for _, param := range params {
+			declared.Set(param, read(param))
+			i.pluginParams.Set(param, declared.Get(param))
+		}
+		plugin, err := factory(described, declared.Get)
+		if err != nil {
+			return err
+		}
+		i.plugins = append(i.plugins, plugin)
+		i.pluginNames = append(i.pluginNames, name)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// previewMessage is a parameter update from a preview client, such as
+// {"id": 7, "spec": "600x400?text=hello"}. The spec is a path like in
+// collections, and the id is echoed so clients can match the frame.
+type previewMessage struct {
+	ID   int    `json:"id"`
+	Spec string `json:"spec"`
+
+	// malformed is set for messages that aren't such JSON.
+	malformed bool
+}
+
+// previewFrame announces a rendered frame, which follows as a binary
+// message, or reports why the render failed.
+type previewFrame struct {
+	ID          int    `json:"id"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Code        string `json:"code,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+var previewUpgrader = websocket.Upgrader{
+	// Browsers send an Origin with WebSocket requests, which other pages
+	// could otherwise use to render through their visitors.
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || slices.Contains(config.corsOrigins, "*") || slices.Contains(config.corsOrigins, origin) {
+			return true
+		}
+		parsed, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(parsed.Host, r.Host)
+	},
+}
+
+// previewHandler serves /ws/preview, which renders specs sent as JSON
+// messages through the routes of renders. Updates that arrive while a frame
+// renders replace each other, so only the latest one is rendered next, and
+// fast typing doesn't queue up renders. The API key of the connection is
+// sent with every render, and every render takes a token of limiter, if
+// any, like a request would.
+func previewHandler(renders *gin.Engine, limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conn, err := previewUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader has responded already.
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(8 << 10)
+
+		// The server doesn't cancel the context of a hijacked request, so
+		// renders stop when reading from the connection fails instead.
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		latest := make(chan previewMessage, 1)
+		go func() {
+			defer close(latest)
+			defer cancel()
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				var message previewMessage
+				if err := json.Unmarshal(data, &message); err != nil || message.Spec == "" {
+					message.malformed = true
+				}
+				// Drop the update that hasn't been rendered yet.
+				select {
+				case <-latest:
+				default:
+				}
+				latest <- message
+			}
+		}()
+
+		for message := range latest {
+			if limiter != nil {
+				// Waiting for a token rather than failing the frame lets the
+				// latest update render once the client slows down.
+				ok, wait := limiter.allow(c.ClientIP())
+				for !ok {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(wait):
+					}
+					ok, wait = limiter.allow(c.ClientIP())
+				}
+				select {
+				case newer, open := <-latest:
+					if open {
+						message = newer
+					}
+				default:
+				}
+			}
+			request, err := http.NewRequestWithContext(ctx, http.MethodGet, "/"+strings.TrimPrefix(message.Spec, "/"), nil)
+			if err != nil || message.malformed {
+				err = conn.WriteJSON(previewFrame{ID: message.ID, Status: http.StatusBadRequest, Code: "invalid_spec",
+					Detail: `Messages should look like {"id": 1, "spec": "600x400?text=hello"}.`})
+				if err != nil {
+					return
+				}
+				continue
+			}
+			request.Host = c.Request.Host
+			// Browsers can't set headers on WebSocket requests, so the key
+			// can also come from ?key= of the connection.
+			for _, name := range []string{"Authorization", "X-API-Key"} {
+				request.Header.Set(name, c.GetHeader(name))
+			}
+			if key := c.Query("key"); key != "" {
+				query := request.URL.Query()
+				query.Set("key", key)
+				request.URL.RawQuery = query.Encode()
+			}
+
+			response := httptest.NewRecorder()
+			renders.ServeHTTP(response, request)
+			if response.Code != http.StatusOK {
+				var failure previewFrame
+				json.Unmarshal(response.Body.Bytes(), &failure)
+				err = conn.WriteJSON(previewFrame{ID: message.ID, Status: response.Code,
+					Code: ternary(failure.Code != "", failure.Code, "not_found"), Detail: failure.Detail})
+			} else {
+				err = conn.WriteJSON(previewFrame{ID: message.ID, Status: http.StatusOK, ContentType: response.Header().Get("Content-Type")})
+				if err == nil {
+					err = conn.WriteMessage(websocket.BinaryMessage, response.Body.Bytes())
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
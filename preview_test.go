@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestPreviewCancelsRenderOnClose(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	renders := gin.New()
+	renders.GET("/:size", func(c *gin.Context) {
+		close(started)
+		select {
+		case <-c.Request.Context().Done():
+			close(canceled)
+		case <-time.After(10 * time.Second):
+		}
+	})
+	r := gin.New()
+	r.GET("/ws/preview", previewHandler(renders, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/preview", http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(previewMessage{ID: 1, Spec: "600x400"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	conn.Close()
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Error("the render went on after the client disconnected")
+	}
+}
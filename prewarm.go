@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// prewarm renders the images listed in path into the render cache. It runs
+// before the server listens, so a new deploy doesn't answer its first
+// requests for popular images with cold renders.
+func prewarm(path string) error {
+	if renderCache == nil {
+		return errors.New("PREWARM_FILE needs a render cache, set CACHE_BACKEND")
+	}
+	specs, err := readPrewarmFile(path)
+	if err != nil {
+		return err
+	}
+
+	// The requests skip the rate and concurrency limits of the public
+	// routes, and are spread over the cores instead.
+	r := gin.New()
+	registerRenderRoutes(r, limitRenders(nil))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	warmed := 0
+	queue := make(chan string)
+	for n := 0; n < runtime.GOMAXPROCS(0); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for spec := range queue {
+				req, err := http.NewRequest(http.MethodGet, spec, nil)
+				if err != nil {
+					log.Printf("Skipping prewarm spec %q: %v", spec, err)
+					continue
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					log.Printf("Skipping prewarm spec %q: status %d", spec, w.Code)
+					continue
+				}
+				mu.Lock()
+				warmed++
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, spec := range specs {
+		queue <- spec
+	}
+	close(queue)
+	wg.Wait()
+
+	log.Printf("Prewarmed %d of %d images in %v", warmed, len(specs), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// readPrewarmFile returns the distinct request paths in a manifest or an
+// access log.
+func readPrewarmFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var specs []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if spec, ok := prewarmSpec(scanner.Text()); ok && !seen[spec] {
+			seen[spec] = true
+			specs = append(specs, spec)
+		}
+	}
+	return specs, scanner.Err()
+}
+
+// prewarmSpec reads one line of a prewarm file. A manifest lists a spec per
+// line like collections, "/600x400?text=hi" or "600x400?text=hi", with #
+// comments. In access logs, the quoted GET request is used, as in gin's
+// `GET "/600x400"` and the common log format's "GET /600x400 HTTP/1.1",
+// or the uri of JSON access log lines.
+func prewarmSpec(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+
+	if strings.HasPrefix(line, "{") {
+		var entry struct {
+			Method string `json:"method"`
+			URI    string `json:"uri"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.Method != http.MethodGet || entry.URI == "" {
+			return "", false
+		}
+		return entry.URI, true
+	}
+
+	if before, quoted, ok := strings.Cut(line, `"`); ok {
+		request, _, _ := strings.Cut(quoted, `"`)
+		fields := strings.Fields(request)
+		if len(fields) == 1 {
+			// gin logs the method in the column before the quoted path,
+			// possibly between color codes.
+			column := before[strings.LastIndex(before, "|")+1:]
+			if strings.Contains(column, http.MethodGet) {
+				fields = []string{http.MethodGet, fields[0]}
+			}
+		}
+		if len(fields) < 2 || fields[0] != http.MethodGet {
+			return "", false
+		}
+		line = fields[1]
+	}
+
+	if !strings.HasPrefix(line, "/") {
+		line = "/" + line
+	}
+	return line, true
+}
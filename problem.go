@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Problem is an RFC 7807 error body. Code is stable, for clients to branch
+// on, and detail is meant for people and may change.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// problem responds with an application/problem+json body.
+func problem(c *gin.Context, status int, code, detail string) {
+	c.Header("Content-Type", "application/problem+json")
+	c.JSON(status, Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	})
+}
+
+// abortProblem responds like problem and stops the handler chain.
+func abortProblem(c *gin.Context, status int, code, detail string) {
+	c.Abort()
+	problem(c, status, code, detail)
+}
+
+// apiError is an error with the status and code to respond with.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// badRequest returns a 400 error with code.
+func badRequest(code, format string, args ...any) error {
+	return &apiError{http.StatusBadRequest, code, fmt.Sprintf(format, args...)}
+}
+
+// problemFor responds with the status and code of err, or with status and
+// code for errors that have none.
+func problemFor(c *gin.Context, err error, status int, code string) {
+	var known *apiError
+	if errors.As(err, &known) {
+		status, code = known.status, known.code
+	}
+	problem(c, status, code, err.Error())
+}
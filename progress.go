@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// minProgressHeight is the thinnest bar that still fits a label.
+const minProgressHeight = 8
+
+var (
+	progressBar   = color.RGBA{0x0C, 0x79, 0xED, 0xFF}
+	progressTrack = color.RGBA{0xE5, 0xE7, 0xEB, 0xFF}
+)
+
+type Progress struct {
+	width  int
+	height int
+	value  float64
+	label  string
+	radius float64
+	bg     color.RGBA
+	bar    color.RGBA
+	track  color.RGBA
+	// fg is the label color, or zero for one that contrasts with the bar
+	// and the track under each part of the label.
+	fg color.RGBA
+}
+
+// progressHandler serves /progress/:size?value=65, a progress bar filled to
+// the percentage for emails and dashboards that can't draw one.
+func progressHandler(c *gin.Context) {
+	img := &Image{maxSize: maxSizeFor(c)}
+	if err := img.setSize(c.Param("size")); err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	// Bars are usually thinner than MIN_SIZE, so only the width is held
+	// to it.
+	if _, height, err := parseDimensions(strings.Split(c.Param("size"), "x")); err == nil {
+		img.height = clamp(height, minProgressHeight, img.height)
+	}
+
+	value, err := strconv.ParseFloat(c.DefaultQuery("value", "50"), 64)
+	if err != nil || math.IsNaN(value) {
+		problem(c, http.StatusBadRequest, "invalid_request", "Value should be a percentage like 65.")
+		return
+	}
+	value = math.Max(0, math.Min(value, 100))
+	label, err := sanitizeTexts(c.DefaultQuery("label", strconv.FormatFloat(math.Round(value), 'f', -1, 64)+"%"))
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	progress := &Progress{
+		width:  img.width,
+		height: img.height,
+		value:  value,
+		label:  ternary(label[0] == "none", "", label[0]),
+		radius: -1,
+		bg:     parseColor(c.Query("bg"), color.RGBA{}),
+		bar:    parseColor(c.Query("bar"), progressBar),
+		track:  parseColor(c.Query("track"), progressTrack),
+		fg:     parseColor(c.Query("fg"), color.RGBA{}),
+	}
+	if radius, err := strconv.ParseFloat(c.Query("radius"), 64); err == nil && radius >= 0 {
+		progress.radius = radius
+	}
+
+	serveRender(c, progress.cacheKey(), "image/png", progress.render)
+}
+
+func (p *Progress) cacheKey() string {
+	spec := fmt.Sprintf("progress|%d|%d|%v|%q|%v|%v|%v|%v|%v", p.width, p.height, p.value, p.label, p.radius, p.bg, p.bar, p.track, p.fg)
+	return renderKey(spec)
+}
+
+func (p *Progress) render(ctx context.Context, w io.Writer) error {
+	img, err := p.draw()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return pngEncoder.Encode(&contextWriter{ctx, w}, img)
+}
+
+// draw fills the image with bg and draws the bar inset by a small margin,
+// rounded to a pill unless a radius is given.
+func (p *Progress) draw() (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, p.width, p.height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{p.bg}, image.Point{}, draw.Src)
+
+	margin := min(p.width, p.height) / 10
+	bar := img.Bounds().Inset(margin)
+	radius := float32(math.Min(float64(bar.Dy())/2, float64(bar.Dx())/2))
+	if p.radius >= 0 {
+		radius = float32(math.Min(p.radius, float64(radius)))
+	}
+	fillRoundedRect(img, bar, radius, p.track)
+
+	// The fill is the whole rounded bar, cut off at the value.
+	filled := image.NewRGBA(img.Bounds())
+	fillRoundedRect(filled, bar, radius, p.bar)
+	split := bar.Min.X + int(math.Round(float64(bar.Dx())*p.value/100))
+	fill := image.Rect(bar.Min.X, bar.Min.Y, split, bar.Max.Y)
+	draw.Draw(img, fill, filled, fill.Min, draw.Over)
+
+	if p.label == "" {
+		return img, nil
+	}
+	if regularFontErr != nil {
+		return nil, regularFontErr
+	}
+	face := newFace(regularFont, &truetype.Options{Size: float64(bar.Dy()) * 0.55, DPI: 72, Hinting: font.HintingFull})
+	drawer := &font.Drawer{Face: face}
+	bounds, advance := drawer.BoundString(p.label)
+	// Center the ink of the label on the bar, like avatar initials.
+	dot := fixed.Point26_6{
+		X: fixed.I(bar.Min.X) + (fixed.I(bar.Dx())-advance)/2,
+		Y: fixed.I(bar.Min.Y) + (fixed.I(bar.Dy())-(bounds.Max.Y-bounds.Min.Y))/2 - bounds.Min.Y,
+	}
+	// Each side of the split gets its own color, so labels over both stay
+	// readable.
+	for _, part := range []struct {
+		clip  image.Rectangle
+		under color.RGBA
+	}{
+		{image.Rect(0, 0, split, p.height), p.bar},
+		{image.Rect(split, 0, p.width, p.height), p.track},
+	} {
+		fg := ternary(p.fg.A > 0, p.fg, contrastingColor(part.under))
+		drawer.Dst = img.SubImage(part.clip).(*image.RGBA)
+		drawer.Src = &image.Uniform{fg}
+		drawer.Dot = dot
+		drawer.DrawString(p.label)
+	}
+	return img, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ProxyImage struct {
+	width  int
+	height int
+	src    string
+	fit    string
+	bg     color.RGBA
+	format string
+}
+
+// proxyHandler resizes a remote image from the fetch allowlist, so
+// development environments don't need a separate image proxy.
+func proxyHandler(c *gin.Context) {
+	img := &Image{maxSize: maxSizeFor(c)}
+	if err := img.setSize(c.Param("size")); err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	src, err := url.Parse(c.Query("src"))
+	if err != nil || c.Query("src") == "" {
+		problem(c, http.StatusBadRequest, "invalid_src", "The src parameter must be a URL.")
+		return
+	}
+	if err := checkFetchURL(src); err != nil {
+		problemFor(c, err, http.StatusBadRequest, "fetch_not_allowed")
+		return
+	}
+
+	proxy := &ProxyImage{
+		width:  img.width,
+		height: img.height,
+		src:    src.String(),
+		fit:    ternary(c.Query("fit") == "contain", "contain", "cover"),
+		bg:     parseColor(c.Query("bg"), color.RGBA{}),
+		format: ternary(c.Query("format") == "jpeg", "jpeg", "png"),
+	}
+
+	serveRender(c, proxy.cacheKey(), "image/"+proxy.format, proxy.render)
+}
+
+func (p *ProxyImage) cacheKey() string {
+	spec := fmt.Sprintf("proxy|%d|%d|%q|%s|%v|%s", p.width, p.height, p.src, p.fit, p.bg, p.format)
+	return renderKey(spec)
+}
+
+func (p *ProxyImage) render(ctx context.Context, w io.Writer) error {
+	src, err := fetchImage(ctx, p.src)
+	if err != nil {
+		return err
+	}
+
+	var img *image.RGBA
+	if p.fit == "contain" {
+		img = contain(src, p.width, p.height, p.bg)
+	} else {
+		img = cover(src, p.width, p.height)
+	}
+
+	if p.format == "jpeg" {
+		return jpeg.Encode(&contextWriter{ctx, w}, img, &jpeg.Options{Quality: 85})
+	}
+	return pngEncoder.Encode(&contextWriter{ctx, w}, img)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/gin-gonic/gin"
+)
+
+// quietZone is the number of blank modules scanners need around a QR code.
+const quietZone = 4
+
+type QRCode struct {
+	width  int
+	height int
+	data   string
+	level  qr.ErrorCorrectionLevel
+	bg     color.RGBA
+	fg     color.RGBA
+	code   barcode.Barcode
+}
+
+var qrLevels = map[string]qr.ErrorCorrectionLevel{"L": qr.L, "M": qr.M, "Q": qr.Q, "H": qr.H}
+
+func qrHandler(c *gin.Context) {
+	img := &Image{maxSize: maxSizeFor(c)}
+	if err := img.setSize(c.Param("size")); err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	data := c.Query("data")
+	if data == "" {
+		problem(c, http.StatusBadRequest, "missing_data", "The data parameter is required.")
+		return
+	}
+
+	level, ok := qrLevels[strings.ToUpper(c.Query("ecc"))]
+	if !ok {
+		level = qr.M
+	}
+
+	// Like barcodes, the data is encoded up front to report data that
+	// doesn't fit the level, or the size, as a 400.
+	encoded, err := qr.Encode(data, level, qr.Auto)
+	if err != nil {
+		problem(c, http.StatusBadRequest, "invalid_qr_data", err.Error())
+		return
+	}
+	// Modules smaller than a pixel would be cut off, which no scanner reads.
+	if minSide := encoded.Bounds().Dx() + 2*quietZone; min(img.width, img.height) < minSide {
+		problem(c, http.StatusBadRequest, "size_out_of_range", fmt.Sprintf("The QR code needs a width and height of at least %d pixels.", minSide))
+		return
+	}
+
+	code := &QRCode{
+		width:  img.width,
+		height: img.height,
+		data:   data,
+		level:  level,
+		bg:     parseColor(c.Query("bg"), color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}),
+		fg:     parseColor(c.Query("fg"), color.RGBA{0x00, 0x00, 0x00, 0xFF}),
+		code:   encoded,
+	}
+
+	serveRender(c, code.cacheKey(), "image/png", code.render)
+}
+
+func (q *QRCode) cacheKey() string {
+	spec := fmt.Sprintf("qr|%d|%d|%q|%v|%v|%v", q.width, q.height, q.data, q.level, q.bg, q.fg)
+	return renderKey(spec)
+}
+
+func (q *QRCode) render(ctx context.Context, w io.Writer) error {
+	img := image.NewRGBA(image.Rect(0, 0, q.width, q.height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{q.bg}, image.Point{}, draw.Src)
+	drawModules(img, q.code, q.fg)
+
+	return pngEncoder.Encode(&contextWriter{ctx, w}, img)
+}
+
+// drawModules draws the dark modules of a 2D code as the largest whole-pixel
+// squares that fit, centered with a quiet zone.
+func drawModules(img *image.RGBA, code barcode.Barcode, fg color.RGBA) {
+	modules := code.Bounds().Dx()
+	side := ternary(img.Rect.Dx() < img.Rect.Dy(), img.Rect.Dx(), img.Rect.Dy())
+	size := clamp(side/(modules+2*quietZone), 1, side)
+	left := (img.Rect.Dx() - modules*size) / 2
+	top := (img.Rect.Dy() - modules*size) / 2
+
+	for y := 0; y < modules; y++ {
+		for x := 0; x < modules; x++ {
+			if r, _, _, _ := code.At(x, y).RGBA(); r != 0 {
+				continue
+			}
+			rect := image.Rect(left+x*size, top+y*size, left+(x+1)*size, top+(y+1)*size)
+			draw.Draw(img, rect, &image.Uniform{fg}, image.Point{}, draw.Src)
+		}
+	}
+}
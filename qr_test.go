@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQRCodeChecks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerRoutes(r, nil, nil)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		code   string
+	}{
+		{"fits", "/qr/100?data=hello", http.StatusOK, ""},
+		// 2500 characters need a version of 165 modules, plus the quiet zones.
+		{"too small", "/qr/150?ecc=L&data=" + strings.Repeat("a", 2500), http.StatusBadRequest, "size_out_of_range"},
+		{"too long", "/qr/300?ecc=H&data=" + strings.Repeat("a", 5000), http.StatusBadRequest, "invalid_qr_data"},
+	}
+	for _, test := range tests {
+		w := request(r, http.MethodGet, test.target, "", nil)
+		var body struct{ Code string }
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != test.status || body.Code != test.code {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, w.Code, body.Code, test.status, test.code)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"sort"
+	"strconv"
+)
+
+// compressionLevels are the values of PNG_COMPRESSION and ?compression=.
+var compressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// setCompression reads ?compression=best and ?colors=64, which reduces a
+// PNG to a palette of at most that many colors.
+func (i *Image) setCompression(compression, colors string) {
+	// Reproducible renders don't depend on the PNG_COMPRESSION of the
+	// server they run on.
+	i.compression = ternary(i.reproducible, "default", config.pngCompression)
+	if _, ok := compressionLevels[compression]; ok {
+		i.compression = compression
+	}
+	i.colors = 0
+	if n, err := strconv.Atoi(colors); err == nil && n > 0 {
+		i.colors = clamp(n, 2, 256)
+	}
+}
+
+// encoder returns a PNG encoder with the compression of the request.
+func (i *Image) encoder() *png.Encoder {
+	return &png.Encoder{CompressionLevel: compressionLevels[i.compression]}
+}
+
+// quantize reduces img to at most n colors with median cut. Flat
+// placeholders have few colors besides the antialiased edges of the text,
+// so an 8-bit PNG is usually a fraction of the size and looks the same.
+func quantize(img *image.RGBA, n int) *image.Paletted {
+	counts := map[color.RGBA]int{}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for x := 0; x < len(row); x += 4 {
+			counts[color.RGBA{row[x], row[x+1], row[x+2], row[x+3]}]++
+		}
+	}
+
+	histogram := make([]colorCount, 0, len(counts))
+	for c, count := range counts {
+		histogram = append(histogram, colorCount{c, count})
+	}
+	// Map order is random, sorting keeps the palette the same on every run.
+	sort.Slice(histogram, func(a, b int) bool { return rgbaKey(histogram[a].color) < rgbaKey(histogram[b].color) })
+	palette := medianCut(histogram, n)
+
+	dst := image.NewPaletted(bounds, palette)
+	indexes := map[color.RGBA]uint8{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		out := dst.Pix[dst.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < len(row); x += 4 {
+			c := color.RGBA{row[x], row[x+1], row[x+2], row[x+3]}
+			index, ok := indexes[c]
+			if !ok {
+				index = uint8(palette.Index(c))
+				indexes[c] = index
+			}
+			out[x/4] = index
+		}
+	}
+	return dst
+}
+
+type colorCount struct {
+	color color.RGBA
+	count int
+}
+
+func rgbaKey(c color.RGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
+
+// medianCut splits the colors into n boxes, each time halving the box with
+// the widest channel at its weighted median, and returns the mean of each
+// box.
+func medianCut(histogram []colorCount, n int) color.Palette {
+	boxes := [][]colorCount{histogram}
+	for len(boxes) < n {
+		widest, channel, span := -1, 0, 0
+		for b, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, s := widestChannel(box); s > span {
+				widest, channel, span = b, c, s
+			}
+		}
+		if widest < 0 {
+			break
+		}
+
+		box := boxes[widest]
+		sort.SliceStable(box, func(a, b int) bool { return channelOf(box[a].color, channel) < channelOf(box[b].color, channel) })
+		total := 0
+		for _, entry := range box {
+			total += entry.count
+		}
+		split, seen := 1, 0
+		for e, entry := range box[:len(box)-1] {
+			seen += entry.count
+			split = e + 1
+			if 2*seen >= total {
+				break
+			}
+		}
+		boxes[widest] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	palette := make(color.Palette, len(boxes))
+	for b, box := range boxes {
+		var r, g, bl, a, total int
+		for _, entry := range box {
+			r += int(entry.color.R) * entry.count
+			g += int(entry.color.G) * entry.count
+			bl += int(entry.color.B) * entry.count
+			a += int(entry.color.A) * entry.count
+			total += entry.count
+		}
+		palette[b] = color.RGBA{uint8(r / total), uint8(g / total), uint8(bl / total), uint8(a / total)}
+	}
+	return palette
+}
+
+// widestChannel returns the channel, R, G, B or A, with the largest range
+// in box and that range.
+func widestChannel(box []colorCount) (int, int) {
+	channel, span := 0, 0
+	for c := 0; c < 4; c++ {
+		low, high := 255, 0
+		for _, entry := range box {
+			v := int(channelOf(entry.color, c))
+			low, high = min(low, v), max(high, v)
+		}
+		if high-low > span {
+			channel, span = c, high-low
+		}
+	}
+	return channel, span
+}
+
+func channelOf(c color.RGBA, channel int) uint8 {
+	return [4]uint8{c.R, c.G, c.B, c.A}[channel]
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a per-client token bucket limiter.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rps     float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+// newRateLimiter allows rps requests per second per client, and bursts of
+// burst requests. Bursts below 1 would never allow a request, so they are 1.
+func newRateLimiter(rps float64, burst int) *RateLimiter {
+	limiter := &RateLimiter{
+		rps:     rps,
+		burst:   float64(max(burst, 1)),
+		buckets: map[string]*tokenBucket{},
+	}
+	go limiter.sweep(time.Minute)
+	return limiter
+}
+
+// allow takes a token for the client, or returns how long until one is available.
+func (l *RateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rps)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / l.rps * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have refilled completely, since they behave the
+// same as a new bucket.
+func (l *RateLimiter) sweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		l.mu.Lock()
+		now := time.Now()
+		for client, bucket := range l.buckets {
+			if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rps >= l.burst {
+				delete(l.buckets, client)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+func rateLimit(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := limiter.allow(c.ClientIP()); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			abortProblem(c, http.StatusTooManyRequests, "rate_limited", "Too many requests.")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	for _, burst := range []int{-1, 0, 1, 3} {
+		limiter := newRateLimiter(1, burst)
+		allowed := 0
+		for range 5 {
+			if ok, _ := limiter.allow("client"); ok {
+				allowed++
+			}
+		}
+		if want := max(burst, 1); allowed != want {
+			t.Errorf("burst %d: %d requests allowed, want %d", burst, allowed, want)
+		}
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	limiter := newRateLimiter(1, 1)
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Fatal("first request refused")
+	}
+	ok, wait := limiter.allow("a")
+	if ok {
+		t.Fatal("request over the burst allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %v, want at most a second", wait)
+	}
+	if ok, _ := limiter.allow("b"); !ok {
+		t.Error("other client refused")
+	}
+
+	limiter.buckets["a"].last = time.Now().Add(-time.Second)
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Error("request refused after refilling")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	r := gin.New()
+	r.Use(rateLimit(newRateLimiter(0.5, 1)))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for i, want := range []int{http.StatusNoContent, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != want {
+			t.Fatalf("request %d: status %d, want %d", i, w.Code, want)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "2" {
+			t.Errorf("Retry-After = %q, want 2", w.Header().Get("Retry-After"))
+		}
+	}
+}
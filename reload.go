@@ -0,0 +1,178 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/freetype/truetype"
+)
+
+// assetsMu guards fallbackFonts, templates and palettes, which are replaced
+// whole when WATCH_ASSETS reloads them, so a render sees either the old or
+// the new set.
+var assetsMu sync.RWMutex
+
+func currentFallbackFonts() []*truetype.Font {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	return fallbackFonts
+}
+
+func lookupTemplate(name string) (*Template, bool) {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	template, ok := templates[name]
+	return template, ok
+}
+
+func templateNames() []string {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	return sortedKeys(templates)
+}
+
+func lookupPalette(name string) ([]Swatch, bool) {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	swatches, ok := palettes[name]
+	return swatches, ok
+}
+
+func paletteNames() []string {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+	return sortedKeys(palettes)
+}
+
+// reloadDelay is how long the watcher waits after the last change before
+// reloading. Editors and deploys often write a file in several steps.
+const reloadDelay = 250 * time.Millisecond
+
+type assetKind int
+
+const (
+	fontAssets assetKind = iota
+	templateAssets
+	paletteAssets
+)
+
+// watchAssets reloads fonts, templates and palettes when their files
+// change. Directories are watched rather than files, because replacing a
+// file, as most editors do, would end a watch on the file itself.
+func watchAssets() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	kinds := map[string]assetKind{}
+	dirs := map[string]bool{}
+	for _, path := range config.fontFallbacks {
+		if path, err = filepath.Abs(path); err == nil {
+			kinds[path] = fontAssets
+			dirs[filepath.Dir(path)] = true
+		}
+	}
+	if config.palettesFile != "" {
+		if path, err := filepath.Abs(config.palettesFile); err == nil {
+			kinds[path] = paletteAssets
+			dirs[filepath.Dir(path)] = true
+		}
+	}
+	templatesDir := ""
+	if config.templatesDir != "" {
+		if templatesDir, err = filepath.Abs(config.templatesDir); err == nil {
+			dirs[templatesDir] = true
+		}
+	}
+
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		pending := map[assetKind]bool{}
+		timer := time.NewTimer(0)
+		<-timer.C
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				path, _ := filepath.Abs(event.Name)
+				kind, ok := kinds[path]
+				if !ok && templatesDir != "" && filepath.Dir(path) == templatesDir {
+					// Fonts next to the templates are used by their regions.
+					kind, ok = templateAssets, true
+				}
+				if ok {
+					pending[kind] = true
+					timer.Reset(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Failed to watch assets: %v", err)
+			case <-timer.C:
+				for kind := range pending {
+					reloadAssets(kind)
+				}
+				clear(pending)
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadAssets loads one kind of asset again and drops what was rendered
+// with the old version. Palettes and templates need no purge: swatch colors
+// and template contents are part of the cache keys, so changed ones render
+// under new keys.
+func reloadAssets(kind assetKind) {
+	switch kind {
+	case fontAssets:
+		fonts := loadFonts(config.fontFallbacks)
+		assetsMu.Lock()
+		fallbackFonts = fonts
+		assetsMu.Unlock()
+
+		// Fallbacks change how any text is measured and drawn.
+		layouts.purge()
+		if renderCache != nil {
+			if _, err := renderCache.purge(); err != nil {
+				log.Printf("Failed to purge the cache: %v", err)
+			}
+		}
+		log.Printf("Reloaded %d fallback fonts", len(fonts))
+		auditServer("assets.reload", map[string]string{"kind": "fonts", "count": strconv.Itoa(len(fonts))})
+
+	case templateAssets:
+		loaded := loadTemplates(config.templatesDir)
+		assetsMu.Lock()
+		templates = loaded
+		assetsMu.Unlock()
+		log.Printf("Reloaded %d templates", len(loaded))
+		auditServer("assets.reload", map[string]string{"kind": "templates", "count": strconv.Itoa(len(loaded))})
+
+	case paletteAssets:
+		loaded, err := readPalettes(config.palettesFile)
+		if err != nil {
+			log.Printf("Keeping the current palettes: %v", err)
+			return
+		}
+		assetsMu.Lock()
+		palettes = loaded
+		assetsMu.Unlock()
+		log.Printf("Reloaded %d palettes", len(loaded))
+		auditServer("assets.reload", map[string]string{"kind": "palettes", "count": strconv.Itoa(len(loaded))})
+	}
+}
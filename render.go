@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSpecBytes caps the body of POST /render.
+const maxSpecBytes = 64 << 10
+
+// RenderSpec is the size and the parameters of a placeholder, keyed by
+// their query string names. GET requests read it from the URL, and POST
+// /render from a JSON body.
+type RenderSpec struct {
+	Size   string
+	Params url.Values
+}
+
+// get returns the value of a parameter, or "" when it isn't set.
+func (s RenderSpec) get(name string) string {
+	return s.Params.Get(name)
+}
+
+// has reports whether a parameter is set, even to "".
+func (s RenderSpec) has(name string) bool {
+	_, ok := s.Params[name]
+	return ok
+}
+
+// decodeRenderSpec reads a JSON spec like
+//
+//	{"size": "1200x630", "text": "Hello", "filter": ["blur", "grayscale"],
+//	 "texts": [{"text": "Caption", "size": 18}]}
+//
+// Every key is a query parameter. Lists of strings and numbers are joined
+// with commas, and lists of objects or objects are passed on as JSON.
+func decodeRenderSpec(body []byte) (RenderSpec, error) {
+	var fields map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		return RenderSpec{}, badRequest("invalid_spec", `Spec should be a JSON object like {"size": "1200x630", "text": "Hello"}.`)
+	}
+
+	spec := RenderSpec{Params: url.Values{}}
+	for name, raw := range fields {
+		value, ok, err := specValue(raw)
+		if err != nil {
+			return RenderSpec{}, badRequest("invalid_spec", "Spec field %q should be a string, number, boolean, list or object.", name)
+		}
+		if !ok {
+			continue
+		}
+		if name == "size" {
+			spec.Size = value
+			continue
+		}
+		spec.Params.Set(name, value)
+	}
+	if spec.Size == "" {
+		return RenderSpec{}, badRequest("invalid_spec", `Spec should have a size like "1200x630".`)
+	}
+	return spec, nil
+}
+
+// specValue returns a JSON value as it would be written in a query string.
+// Nulls are left out.
+func specValue(raw json.RawMessage) (string, bool, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", false, err
+	}
+	switch value := value.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return value, true, nil
+	case json.Number:
+		return value.String(), true, nil
+	case bool:
+		return strconv.FormatBool(value), true, nil
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			switch item := item.(type) {
+			case string:
+				items = append(items, item)
+			case json.Number:
+				items = append(items, item.String())
+			default:
+				return string(raw), true, nil
+			}
+		}
+		return strings.Join(items, ","), true, nil
+	default:
+		return string(raw), true, nil
+	}
+}
+
+// renderHandler serves POST /render, a placeholder from a JSON spec for
+// parameters that are unwieldy in a query string. The spec maps onto the
+// same parameters as GET /:size, so both render and cache alike.
+func renderHandler(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSpecBytes+1))
+	if err != nil {
+		problem(c, http.StatusBadRequest, "invalid_body", "Invalid request body.")
+		return
+	}
+	if len(body) > maxSpecBytes {
+		problem(c, http.StatusRequestEntityTooLarge, "invalid_spec", "Spec is too large.")
+		return
+	}
+	spec, err := decodeRenderSpec(body)
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_spec")
+		return
+	}
+	img, err := imageFromSpec(c, spec)
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	serveImage(c, img)
+}
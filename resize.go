@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// cover scales src to fill width x height, cropping the overflow evenly from
+// both sides of the longer axis.
+func cover(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	crop := bounds
+	if bounds.Dx()*height > bounds.Dy()*width {
+		cropWidth := bounds.Dy() * width / height
+		crop.Min.X += (bounds.Dx() - cropWidth) / 2
+		crop.Max.X = crop.Min.X + cropWidth
+	} else {
+		cropHeight := bounds.Dx() * height / width
+		crop.Min.Y += (bounds.Dy() - cropHeight) / 2
+		crop.Max.Y = crop.Min.Y + cropHeight
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	return dst
+}
+
+// contain scales src to fit inside width x height, keeping its aspect ratio,
+// and centers it on bg.
+func contain(src image.Image, width, height int, bg color.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	fit := image.Rect(0, 0, width, height)
+	if bounds.Dx()*height > bounds.Dy()*width {
+		fitHeight := clamp(bounds.Dy()*width/bounds.Dx(), 1, height)
+		fit.Min.Y = (height - fitHeight) / 2
+		fit.Max.Y = fit.Min.Y + fitHeight
+	} else {
+		fitWidth := clamp(bounds.Dx()*height/bounds.Dy(), 1, width)
+		fit.Min.X = (width - fitWidth) / 2
+		fit.Max.X = fit.Min.X + fitWidth
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, fit, src, bounds, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// ribbonAngles turn the ribbon of each corner across it, so it runs from
+// one edge to the other.
+var ribbonAngles = map[string]float64{
+	"tl": -45,
+	"tr": 45,
+	"bl": 45,
+	"br": -45,
+}
+
+// Ribbon is a diagonal band with a label across a corner of the image.
+type Ribbon struct {
+	text     string
+	color    color.RGBA
+	position string
+	// below draws the ribbon under the text instead of over it.
+	below bool
+}
+
+// setRibbon reads ?ribbon=BETA&ribbonColor=e11&ribbonPos=tr&ribbonZ=above.
+func (i *Image) setRibbon(text, ribbonColor, position, z string) {
+	i.ribbon = Ribbon{}
+	if text == "" {
+		return
+	}
+	i.ribbon = Ribbon{
+		text:     text,
+		color:    parseColor(ribbonColor, color.RGBA{0xE1, 0x1D, 0x48, 0xFF}),
+		position: "tr",
+		below:    z == "below",
+	}
+	if _, ok := ribbonAngles[position]; ok {
+		i.ribbon.position = position
+	}
+}
+
+// drawRibbon draws the ribbon across a square corner of the image, with the
+// label centered on the band in a color that contrasts with it.
+func (i *Image) drawRibbon(img *image.RGBA) {
+	if i.ribbon.text == "" || regularFontErr != nil {
+		return
+	}
+	side := min(i.width, i.height) * 2 / 5
+	thickness := max(side/4, 6)
+	band := newPooledRGBA(image.Rect(0, 0, int(float64(side)*math.Sqrt2)+thickness, thickness))
+	defer releaseRGBA(band)
+	draw.Draw(band, band.Bounds(), &image.Uniform{i.ribbon.color}, image.Point{}, draw.Src)
+
+	options := i.faceOptions()
+	options.Size = float64(thickness) * 0.6
+	drawer := &font.Drawer{
+		Dst:  band,
+		Src:  &image.Uniform{contrastingColor(i.ribbon.color)},
+		Face: newFace(regularFont, options),
+	}
+	bounds, advance := drawer.BoundString(i.ribbon.text)
+	drawer.Dot = fixed.Point26_6{
+		X: (fixed.I(band.Bounds().Dx()) - advance) / 2,
+		Y: (fixed.I(thickness)-(bounds.Max.Y-bounds.Min.Y))/2 - bounds.Min.Y,
+	}
+	drawer.DrawString(i.ribbon.text)
+
+	corner := image.Rect(0, 0, side, side)
+	if i.ribbon.position[1] == 'r' {
+		corner = corner.Add(image.Pt(i.width-side, 0))
+	}
+	if i.ribbon.position[0] == 'b' {
+		corner = corner.Add(image.Pt(0, i.height-side))
+	}
+	drawRotated(img.SubImage(corner).(*image.RGBA), band, ribbonAngles[i.ribbon.position])
+}
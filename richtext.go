@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// headingScales are the size prefixes of a markup line.
+var headingScales = []struct {
+	prefix string
+	scale  float64
+}{
+	{"## ", 1.3},
+	{"# ", 1.6},
+}
+
+// textRun is a stretch of text in one style.
+type textRun struct {
+	text   string
+	bold   bool
+	italic bool
+}
+
+// markupLine is one line of the text, split at line breaks, with its size
+// relative to fontSize.
+type markupLine struct {
+	scale float64
+	runs  []textRun
+}
+
+func (i *Image) setMarkup(value string) {
+	i.markup, _ = strconv.ParseBool(value)
+}
+
+// parseMarkup reads **bold** and //italic// runs, and "# " and "## "
+// prefixes that enlarge a line. Markers that are never closed style the
+// rest of the line.
+func parseMarkup(text string) []markupLine {
+	var lines []markupLine
+	for _, source := range strings.Split(text, "\n") {
+		line := markupLine{scale: 1}
+		for _, heading := range headingScales {
+			if rest, ok := strings.CutPrefix(source, heading.prefix); ok {
+				line.scale, source = heading.scale, rest
+				break
+			}
+		}
+
+		run := textRun{}
+		for len(source) > 0 {
+			marker := strings.Index(source, "**")
+			italic := strings.Index(source, "//")
+			if italic >= 0 && (marker < 0 || italic < marker) {
+				marker = italic
+			}
+			if marker < 0 {
+				run.text += source
+				break
+			}
+
+			run.text += source[:marker]
+			if run.text != "" {
+				line.runs = append(line.runs, run)
+			}
+			run = textRun{bold: run.bold, italic: run.italic}
+			if source[marker] == '*' {
+				run.bold = !run.bold
+			} else {
+				run.italic = !run.italic
+			}
+			source = source[marker+2:]
+		}
+		if run.text != "" {
+			line.runs = append(line.runs, run)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// markupFont returns the face for a run. Markup adds to fontWeight and
+// fontStyle, so **bold** is bold even in a medium text.
+func (i *Image) markupFont(bold, italic bool) *truetype.Font {
+	key := i.fontKey()
+	if bold {
+		key.weight = "bold"
+	}
+	if italic {
+		key.style = "italic"
+	}
+	parsed, _ := lookupFont(key)
+	return parsed
+}
+
+// placedText is a word, or part of one, at its offset in a wrapped line.
+type placedText struct {
+	text string
+	face font.Face
+	x    fixed.Int26_6
+}
+
+type richLine struct {
+	pieces  []placedText
+	width   fixed.Int26_6
+	ascent  fixed.Int26_6
+	descent fixed.Int26_6
+}
+
+// drawRichText draws markup text. Every run can have its own face, so it
+// is laid out piece by piece instead of as whole strings. Lines are not
+// reordered for right to left scripts.
+func (i *Image) drawRichText(ctx context.Context, dst *image.RGBA) error {
+	if regularFontErr != nil {
+		return errors.New("Cannot parse font.")
+	}
+
+	type faceKey struct {
+		bold, italic bool
+		scale        float64
+	}
+	faces := map[faceKey]font.Face{}
+	faceFor := func(bold, italic bool, scale float64) font.Face {
+		key := faceKey{bold, italic, scale}
+		if face, ok := faces[key]; ok {
+			return face
+		}
+		options := *i.faceOptions()
+		options.Size *= scale
+		face := newFace(i.markupFont(bold, italic), &options)
+		faces[key] = face
+		return face
+	}
+
+	drawer := &font.Drawer{Dst: dst}
+	padding := 30
+	maxWidth := fixed.I(dst.Bounds().Dx() - padding)
+
+	var lines []richLine
+	for _, source := range parseMarkup(i.text) {
+		regular := faceFor(false, false, source.scale)
+		line := richLine{}
+		newLine := func() {
+			metrics := regular.Metrics()
+			line.ascent = max(line.ascent, metrics.Ascent)
+			line.descent = max(line.descent, metrics.Descent)
+			lines = append(lines, line)
+			line = richLine{}
+		}
+
+		// Words are split at spaces and can span runs, like "**bold**er".
+		var word []placedText
+		var wordWidth, wordSpace fixed.Int26_6
+		addWord := func() {
+			if len(word) == 0 {
+				return
+			}
+			if len(line.pieces) > 0 && line.width+wordSpace+wordWidth > maxWidth {
+				newLine()
+			}
+			offset := line.width
+			if len(line.pieces) > 0 {
+				offset += wordSpace
+			}
+			for _, piece := range word {
+				piece.x += offset
+				line.pieces = append(line.pieces, piece)
+				metrics := piece.face.Metrics()
+				line.ascent = max(line.ascent, metrics.Ascent)
+				line.descent = max(line.descent, metrics.Descent)
+			}
+			line.width = offset + wordWidth
+			word, wordWidth, wordSpace = nil, 0, 0
+		}
+
+		for _, run := range source.runs {
+			drawer.Face = faceFor(run.bold, run.italic, source.scale)
+			for n, part := range strings.Split(run.text, " ") {
+				if n > 0 {
+					addWord()
+					wordSpace = drawer.MeasureString(" ") + i.style.tracking
+				}
+				if part != "" {
+					word = append(word, placedText{part, drawer.Face, wordWidth})
+					wordWidth += measureString(drawer, part, i.style.tracking)
+				}
+			}
+		}
+		addWord()
+		newLine()
+	}
+
+	// Lines are spaced like plain text, with a fifth of their height extra.
+	total := fixed.Int26_6(0)
+	for _, line := range lines {
+		total += (line.ascent + line.descent) * 6 / 5
+	}
+	y := (fixed.I(dst.Bounds().Dy()) - total) / 2
+	for _, line := range lines {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		height := (line.ascent + line.descent) * 6 / 5
+		baseline := y + (height-line.ascent-line.descent)/2 + line.ascent
+		left := (fixed.I(dst.Bounds().Dx()) - line.width) / 2
+		for _, piece := range line.pieces {
+			drawer.Face = piece.face
+			if err := i.style.draw(ctx, drawer, layoutLine{piece.text, fixed.Point26_6{X: left + piece.x, Y: baseline}}, i.fg); err != nil {
+				return err
+			}
+		}
+		y += height
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// denylist matches the words of DENYLIST and DENYLIST_FILE, or is nil
+// when there are none.
+var denylist = loadDenylist(config.denylist, config.denylistFile)
+
+// loadDenylist builds a case insensitive pattern of whole words, from
+// words and a file of one word per line with # comments.
+func loadDenylist(words []string, file string) *regexp.Regexp {
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Printf("Failed to load the denylist: %v", err)
+		} else {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				if word := strings.TrimSpace(scanner.Text()); word != "" && !strings.HasPrefix(word, "#") {
+					words = append(words, word)
+				}
+			}
+			f.Close()
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(words))
+	for n, word := range words {
+		quoted[n] = regexp.QuoteMeta(word)
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// sanitizeTexts prepares user supplied text for drawing. Text longer than
+// MAX_TEXT_LENGTH is refused, control characters other than line breaks
+// are removed, and denylisted words are masked, or refused with
+// DENYLIST_MODE=reject.
+func sanitizeTexts(texts ...string) ([]string, error) {
+	sanitized := make([]string, len(texts))
+	for n, text := range texts {
+		if config.maxTextLength > 0 && utf8.RuneCountInString(text) > config.maxTextLength {
+			return nil, &apiError{http.StatusUnprocessableEntity, "text_too_long",
+				fmt.Sprintf("Text is longer than %d characters.", config.maxTextLength)}
+		}
+
+		text = strings.Map(func(r rune) rune {
+			if (unicode.IsControl(r) && r != '\n') || isBidiControl(r) {
+				return -1
+			}
+			return r
+		}, text)
+
+		if denylist != nil && denylist.MatchString(text) {
+			if config.denylistMode == "reject" {
+				return nil, &apiError{http.StatusUnprocessableEntity, "blocked_text", "Text contains a blocked word."}
+			}
+			text = denylist.ReplaceAllStringFunc(text, func(word string) string {
+				return strings.Repeat("*", utf8.RuneCountInString(word))
+			})
+		}
+		sanitized[n] = text
+	}
+	return sanitized, nil
+}
+
+// isBidiControl reports embedding, override and isolate characters. Text
+// is reordered by the server, so they would only serve to disguise text.
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"image/color"
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	colors "github.com/gitkumi/placeholder/color"
+)
+
+// schemeHint is the client hint browsers send with the user's color scheme
+// once the server asks for it with Accept-CH.
+const schemeHint = "Sec-CH-Prefers-Color-Scheme"
+
+// setScheme reads ?scheme=light|dark|auto. auto follows the client hint and
+// falls back to light.
+func (i *Image) setScheme(scheme, hint string) error {
+	switch scheme {
+	case "", "light":
+		i.scheme = "light"
+	case "dark":
+		i.scheme = "dark"
+	case "auto":
+		i.scheme = ternary(hint == "dark", "dark", "light")
+	default:
+		return badRequest("invalid_scheme", "Unknown scheme %q, expected light, dark or auto.", scheme)
+	}
+	return nil
+}
+
+// schemeBackground is the default background without a seed or palette.
+// The text color is derived from it.
+func (i *Image) schemeBackground() color.RGBA {
+	if i.scheme == "dark" {
+		return color.RGBA{0x26, 0x26, 0x26, 0xFF}
+	}
+	return color.RGBA{0xD4, 0xD4, 0xD4, 0xFF}
+}
+
+// pairHandler returns the URLs of the light and dark variants of a
+// placeholder, for design systems that need matching pairs. The URLs are
+// signed like those of snippets, and a random background is picked once so
+// both variants share it.
+func pairHandler(c *gin.Context) {
+	query := c.Request.URL.Query()
+	query.Del("scheme")
+	c.Request.URL.RawQuery = query.Encode()
+	img, err := parseImage(c, c.Param("size"))
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	for _, name := range []string{"key", "signature"} {
+		query.Del(name)
+	}
+	if query.Get("bg") == "random" {
+		query.Set("bg", strings.TrimPrefix(colors.Hex(img.bg), "#"))
+	}
+	tenant := tenantFor(c).id()
+	variant := func(scheme string) string {
+		values := maps.Clone(query)
+		values.Set("scheme", scheme)
+		return variantURL(tenant, img.width, img.height, values, 1)
+	}
+	c.JSON(http.StatusOK, gin.H{"light": variant("light"), "dark": variant("dark")})
+}
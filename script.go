@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	colors "github.com/gitkumi/placeholder/color"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// maxScriptString caps each string a script builds, and maxScriptBytes all
+// of them together. Lua has no allocation hooks, so the bytes are counted
+// where strings are built: by .., the string library and table.concat.
+const (
+	maxScriptString = 64 << 10
+	maxScriptBytes  = 1 << 20
+)
+
+// concatName is the local that .. is compiled to a call of. It isn't a
+// valid Lua name, so scripts can't replace it.
+const concatName = "(concat)"
+
+// scriptLibs are the Lua libraries scripts can use. There is no io, os or
+// package library, so scripts can't reach files, the environment or other
+// code.
+var scriptLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// scriptRemoved are the functions of those libraries that load code, touch
+// the process or aren't deterministic, which renders must be to be cached.
+var scriptRemoved = map[string][]string{
+	lua.BaseLibName:   {"collectgarbage", "dofile", "getfenv", "load", "loadfile", "loadstring", "module", "newproxy", "print", "require", "setfenv"},
+	lua.StringLibName: {"dump"},
+	lua.MathLibName:   {"random", "randomseed"},
+}
+
+// compileScript compiles the script of a template once, when it is loaded.
+func compileScript(name, source string) (*lua.FunctionProto, error) {
+	if !config.templateScripts {
+		return nil, errors.New("scripts are disabled, set TEMPLATE_SCRIPTS=true to run them")
+	}
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	budgetConcats(reflect.ValueOf(&chunk).Elem())
+	bind := &ast.LocalAssignStmt{Names: []string{concatName}, Exprs: []ast.Expr{&ast.IdentExpr{Value: concatName}}}
+	return lua.Compile(append([]ast.Stmt{bind}, chunk...), name)
+}
+
+// budgetConcats replaces every a .. b in the syntax tree at v with a call
+// of concatName, which counts the bytes it builds.
+func budgetConcats(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			budgetConcats(v.Elem())
+		}
+	case reflect.Slice:
+		for n := 0; n < v.Len(); n++ {
+			budgetConcats(v.Index(n))
+		}
+	case reflect.Struct:
+		for n := 0; n < v.NumField(); n++ {
+			if v.Type().Field(n).IsExported() {
+				budgetConcats(v.Field(n))
+			}
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		budgetConcats(v.Elem())
+		if concat, ok := v.Interface().(*ast.StringConcatOpExpr); ok {
+			ident := &ast.IdentExpr{Value: concatName}
+			ident.SetLine(concat.Line())
+			call := &ast.FuncCallExpr{Func: ident, Args: []ast.Expr{concat.Lhs, concat.Rhs}, AdjustRet: true}
+			call.SetLine(concat.Line())
+			call.SetLastLine(concat.LastLine())
+			v.Set(reflect.ValueOf(call))
+		}
+	}
+}
+
+// runScript runs a compiled template script with the variables in the
+// global vars table, and returns the table's strings and numbers after it
+// ran. Scripts are stopped after SCRIPT_TIMEOUT, their stacks and call
+// depth are capped, and so are the strings they build.
+func runScript(ctx context.Context, script *lua.FunctionProto, values map[string]string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.scriptTimeout)
+	defer cancel()
+
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:        true,
+		CallStackSize:       200,
+		RegistrySize:        1024,
+		RegistryMaxSize:     64 * 1024,
+		MinimizeStackMemory: true,
+	})
+	defer L.Close()
+	for _, lib := range scriptLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+		table := L.GetGlobal(lib.name)
+		if lib.name == lua.BaseLibName {
+			table = L.Get(lua.GlobalsIndex)
+		}
+		for _, name := range scriptRemoved[lib.name] {
+			L.SetField(table, name, lua.LNil)
+		}
+	}
+	budget := &scriptBudget{}
+	budget.wrap(L)
+	L.SetGlobal(concatName, L.NewFunction(budget.concat))
+	L.SetGlobal("hash", L.NewFunction(scriptHash))
+	L.SetGlobal("swatches", L.NewFunction(scriptSwatches))
+
+	vars := L.NewTable()
+	for name, value := range values {
+		vars.RawSetString(name, lua.LString(value))
+	}
+	L.SetGlobal("vars", vars)
+
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(script))
+	if err := L.PCall(0, 0, nil); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("script ran longer than %v", config.scriptTimeout)
+		}
+		return nil, err
+	}
+
+	scripted := map[string]string{}
+	vars.ForEach(func(key, value lua.LValue) {
+		name, ok := key.(lua.LString)
+		if !ok {
+			return
+		}
+		switch value := value.(type) {
+		case lua.LString, lua.LNumber:
+			scripted[string(name)] = value.String()
+		}
+	})
+	return scripted, nil
+}
+
+// scriptBudget counts the bytes of the strings a script builds.
+type scriptBudget struct {
+	used int
+}
+
+// charge counts n bytes, and stops the script once a string is over
+// maxScriptString or all of them are over maxScriptBytes.
+func (b *scriptBudget) charge(L *lua.LState, n int) {
+	if n > maxScriptString {
+		L.RaiseError("strings can have at most %d bytes", maxScriptString)
+	}
+	if b.used += n; b.used > maxScriptBytes {
+		L.RaiseError("scripts can build at most %d bytes of strings", maxScriptBytes)
+	}
+}
+
+// wrap counts the strings the functions of the string library and
+// table.concat return. The ones that can build a lot in a single call are
+// checked before they run.
+func (b *scriptBudget) wrap(L *lua.LState) {
+	checks := map[string]func(*lua.LState){
+		"rep":    b.checkRep,
+		"gsub":   b.checkGsub,
+		"format": b.checkFormat,
+	}
+	library := L.GetGlobal(lua.StringLibName).(*lua.LTable)
+	library.ForEach(func(name, function lua.LValue) {
+		if function, ok := function.(*lua.LFunction); ok {
+			L.SetField(library, name.String(), b.counted(L, function, checks[name.String()]))
+		}
+	})
+	table := L.GetGlobal(lua.TabLibName).(*lua.LTable)
+	concat := L.GetField(table, "concat").(*lua.LFunction)
+	L.SetField(table, "concat", b.counted(L, concat, b.checkTableConcat))
+}
+
+// counted calls function after check, and counts the strings it returns.
+func (b *scriptBudget) counted(L *lua.LState, function *lua.LFunction, check func(*lua.LState)) *lua.LFunction {
+	return L.NewFunction(func(L *lua.LState) int {
+		if check != nil {
+			check(L)
+		}
+		top := L.GetTop()
+		L.Push(function)
+		for n := 1; n <= top; n++ {
+			L.Push(L.Get(n))
+		}
+		L.Call(top, lua.MultRet)
+		for n := top + 1; n <= L.GetTop(); n++ {
+			if s, ok := L.Get(n).(lua.LString); ok {
+				b.charge(L, len(s))
+			}
+		}
+		return L.GetTop() - top
+	})
+}
+
+// concat is a .. b, for strings and numbers or through a __concat
+// metamethod.
+func (b *scriptBudget) concat(L *lua.LState) int {
+	lhs, rhs := L.Get(1), L.Get(2)
+	if concatenable(lhs) && concatenable(rhs) {
+		s := lua.LVAsString(lhs) + lua.LVAsString(rhs)
+		b.charge(L, len(s))
+		L.Push(lua.LString(s))
+		return 1
+	}
+	for _, operand := range []lua.LValue{lhs, rhs} {
+		if metamethod := L.GetMetaField(operand, "__concat"); metamethod != lua.LNil {
+			L.Push(metamethod)
+			L.Push(lhs)
+			L.Push(rhs)
+			L.Call(2, 1)
+			return 1
+		}
+	}
+	L.RaiseError("cannot concatenate a %s", ternary(concatenable(lhs), rhs, lhs).Type())
+	return 0
+}
+
+func concatenable(v lua.LValue) bool {
+	return v.Type() == lua.LTString || v.Type() == lua.LTNumber
+}
+
+// remaining is how many bytes a single call can still build.
+func (b *scriptBudget) remaining() int {
+	return min(maxScriptString, maxScriptBytes-b.used)
+}
+
+// checkRep refuses string.rep results over the budget.
+func (b *scriptBudget) checkRep(L *lua.LState) {
+	s, n := L.CheckString(1), L.CheckInt(2)
+	if n > 0 && len(s) > b.remaining()/n {
+		L.RaiseError("string.rep result is over %d bytes", b.remaining())
+	}
+}
+
+// checkGsub refuses string.gsub calls whose replacement string could make
+// the result go over the budget, where every % can insert the whole subject.
+// Replacement functions and tables are counted as gsub calls them.
+func (b *scriptBudget) checkGsub(L *lua.LState) {
+	s := L.CheckString(1)
+	switch replacement := L.Get(3).(type) {
+	case lua.LString, lua.LNumber:
+		matches := len(s) + 1
+		if limit, ok := L.Get(4).(lua.LNumber); ok && int(limit) >= 0 {
+			matches = min(matches, int(limit))
+		}
+		repl := lua.LVAsString(replacement)
+		each := len(repl) + strings.Count(repl, "%")*len(s)
+		if each > 0 && matches > (b.remaining()-len(s))/each {
+			L.RaiseError("string.gsub result could be over %d bytes", b.remaining())
+		}
+	case *lua.LFunction:
+		L.Replace(3, L.NewFunction(func(L *lua.LState) int {
+			top := L.GetTop()
+			L.Push(replacement)
+			for n := 1; n <= top; n++ {
+				L.Push(L.Get(n))
+			}
+			L.Call(top, 1)
+			b.charge(L, len(lua.LVAsString(L.Get(-1))))
+			return 1
+		}))
+	case *lua.LTable:
+		L.Replace(3, L.NewFunction(func(L *lua.LState) int {
+			value := L.GetTable(replacement, L.Get(1))
+			b.charge(L, len(lua.LVAsString(value)))
+			L.Push(value)
+			return 1
+		}))
+	}
+}
+
+// checkFormat refuses string.format calls whose arguments, widths and
+// precisions add up to more than the budget.
+func (b *scriptBudget) checkFormat(L *lua.LState) {
+	format := L.CheckString(1)
+	size := len(format)
+	for n := 2; n <= L.GetTop(); n++ {
+		size += ternary(L.Get(n).Type() == lua.LTString, len(lua.LVAsString(L.Get(n))), 32)
+	}
+	// Widths and precisions, like %99s or %.50f, pad the arguments.
+	for n := 0; n < len(format); n++ {
+		if format[n] != '%' {
+			continue
+		}
+		number := 0
+		for n++; n < len(format) && strings.IndexByte("-+ #.0123456789", format[n]) >= 0; n++ {
+			if digit := format[n]; digit >= '0' && digit <= '9' {
+				number = min(number*10+int(digit-'0'), maxScriptBytes)
+			} else {
+				size, number = size+number, 0
+			}
+		}
+		size += number
+	}
+	if size > b.remaining() {
+		L.RaiseError("string.format result could be over %d bytes", b.remaining())
+	}
+}
+
+// checkTableConcat refuses table.concat results over the budget.
+func (b *scriptBudget) checkTableConcat(L *lua.LState) {
+	table := L.CheckTable(1)
+	separator := L.OptString(2, "")
+	size := 0
+	for n := L.OptInt(3, 1); n <= L.OptInt(4, table.Len()); n++ {
+		value := table.RawGetInt(n)
+		if value == lua.LNil {
+			// table.concat itself fails on the gap.
+			return
+		}
+		if size += len(lua.LVAsString(value)) + len(separator); size > b.remaining() {
+			L.RaiseError("table.concat result is over %d bytes", b.remaining())
+		}
+	}
+}
+
+// scriptHash is hash(s), a stable number from 0 to 2^32-1 for any string,
+// the same hash seeded palettes pick their swatch by.
+func scriptHash(L *lua.LState) int {
+	sum := sha256.Sum256([]byte(L.CheckString(1)))
+	L.Push(lua.LNumber(binary.BigEndian.Uint32(sum[:])))
+	return 1
+}
+
+// scriptSwatches is swatches(name), the swatches of a palette as a list of
+// {bg = "#ffd1dc", fg = "#5a2a3a"} tables, or nil for unknown palettes.
+func scriptSwatches(L *lua.LState) int {
+	swatches, ok := lookupPalette(L.CheckString(1))
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
+	}
+	list := L.CreateTable(len(swatches), 0)
+	for _, swatch := range swatches {
+		entry := L.CreateTable(0, 2)
+		entry.RawSetString("bg", lua.LString(colors.Hex(swatch.bg)))
+		entry.RawSetString("fg", lua.LString(colors.Hex(swatch.fg)))
+		list.Append(entry)
+	}
+	L.Push(list)
+	return 1
+}
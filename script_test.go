@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// runTestScript compiles and runs source with TEMPLATE_SCRIPTS on.
+func runTestScript(t *testing.T, source string) (map[string]string, error) {
+	t.Helper()
+	defer func(enabled bool) { config.templateScripts = enabled }(config.templateScripts)
+	config.templateScripts = true
+	script, err := compileScript("test.lua", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runScript(context.Background(), script, map[string]string{"title": "Hello"})
+}
+
+func TestScriptStringBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"doubling", `local s = "x" while true do s = s .. s end`},
+		{"method", `local s = ("x"):rep(60000) local t = {} for i = 1, 100 do t[i] = s:upper() end`},
+		{"rep", `local s = string.rep("abc", 1e9)`},
+		{"gsub", `local s = ("x"):rep(60000) s = s:gsub("x", "%0%0%0%0")`},
+		{"gsub function", `local s = ("x"):rep(60000) s = s:gsub("x", function(c) return c:rep(100) end)`},
+		{"format", `local s = string.format("%99999999s", "x")`},
+		{"table.concat", `local s, t = ("x"):rep(60000), {} for i = 1, 100 do t[i] = s end s = table.concat(t)`},
+		{"metamethod", `local t = setmetatable({}, {__concat = function(a, b) return "y" .. ("x"):rep(60000) end}) for i = 1, 100 do local s = t .. t end`},
+	}
+	for _, test := range tests {
+		_, err := runTestScript(t, test.source)
+		if err == nil || !strings.Contains(err.Error(), "bytes") {
+			t.Errorf("%s: got %v, want the string budget to stop it", test.name, err)
+		}
+	}
+}
+
+func TestScriptConcat(t *testing.T) {
+	values, err := runTestScript(t, `
+local n = 2
+vars.title = vars.title .. ", " .. n .. " worlds"
+vars.upper = vars.title:upper()
+vars.joined = table.concat({"a", "b"}, "-")
+vars.formatted = string.format("%5.1f", 3.14159)
+vars.meta = setmetatable({}, {__concat = function(a, b) return "meta" end}) .. "x"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"title": "Hello, 2 worlds", "upper": "HELLO, 2 WORLDS", "joined": "a-b", "formatted": "  3.1", "meta": "meta"}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("%s is %q, want %q", name, values[name], value)
+		}
+	}
+	if _, err := runTestScript(t, `local s = "x" .. {}`); err == nil {
+		t.Error("concatenating a table without __concat worked")
+	}
+}
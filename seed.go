@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"strconv"
+
+	colors "github.com/gitkumi/placeholder/color"
+)
+
+func (i *Image) setSeed(seed, identicon string) {
+	i.seed = seed
+	if seed != "" {
+		i.identicon, _ = strconv.ParseBool(identicon)
+	}
+}
+
+// seedColors derives a stable background from the seed's hash, with a
+// lighter or darker shade of the same hue for the text.
+func seedColors(seed string) (color.RGBA, color.RGBA) {
+	sum := sha256.Sum256([]byte(seed))
+	hue := float64(int(sum[0])<<8|int(sum[1])) / 65536 * 360
+	saturation := 0.45 + float64(sum[2])/255*0.2
+	lightness := 0.45 + float64(sum[3])/255*0.15
+
+	bg := colors.HSL(hue, saturation, lightness)
+	fg := colors.HSL(hue, saturation, ternary(luminance(bg) > 0.4, 0.15, 0.92))
+	return bg, fg
+}
+
+// darkSeedColors is seedColors for dark mode, a deep shade of the same hue
+// with light text.
+func darkSeedColors(seed string) (color.RGBA, color.RGBA) {
+	sum := sha256.Sum256([]byte(seed))
+	hue := float64(int(sum[0])<<8|int(sum[1])) / 65536 * 360
+	saturation := 0.35 + float64(sum[2])/255*0.2
+	lightness := 0.16 + float64(sum[3])/255*0.08
+
+	return colors.HSL(hue, saturation, lightness), colors.HSL(hue, saturation, 0.85)
+}
+
+// randomColor picks a random hue at the same saturation and lightness as
+// seeded colors, so it never comes out garish or muddy.
+func randomColor() color.RGBA {
+	return colors.HSL(rand.Float64()*360, 0.45+rand.Float64()*0.2, 0.45+rand.Float64()*0.15)
+}
+
+// drawIdenticon draws a mirrored 5x5 grid, like GitHub's default avatars,
+// centered in img.
+func (i *Image) drawIdenticon(img *image.RGBA) {
+	sum := sha256.Sum256([]byte(i.seed))
+
+	cell := ternary(i.width < i.height, i.width, i.height) * 7 / 10 / 5
+	left := (i.width - cell*5) / 2
+	top := (i.height - cell*5) / 2
+
+	// Cells are clipped to each band, so the bands fill them together.
+	fillBands(img, func(band *image.RGBA) {
+		for row := 0; row < 5; row++ {
+			for column := 0; column < 3; column++ {
+				if sum[4+row*3+column]&1 == 0 {
+					continue
+				}
+				for _, x := range []int{column, 4 - column} {
+					rect := image.Rect(left+x*cell, top+row*cell, left+(x+1)*cell, top+(row+1)*cell)
+					draw.Draw(band, rect, &image.Uniform{i.fg}, image.Point{}, draw.Src)
+				}
+			}
+		}
+	})
+}
+
+// luminance returns the relative luminance of c between 0 and 1.
+func luminance(c color.RGBA) float64 {
+	return 0.2126*srgbToLinear(c.R) + 0.7152*srgbToLinear(c.G) + 0.0722*srgbToLinear(c.B)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Timeouts of the servers. Slow clients would otherwise hold connections
+// open forever. Writes aren't limited, as renders have RENDER_TIMEOUT and
+// hijacked WebSocket connections drop these deadlines.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	idleTimeout       = 2 * time.Minute
+)
+
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// serve runs the server over HTTPS when a certificate is configured, and
+// over plain HTTP otherwise. Go negotiates HTTP/2 on TLS connections. It
+// returns when any of the listeners fails.
+func serve(r *gin.Engine) error {
+	listeners, err := listen()
+	if err != nil {
+		return err
+	}
+
+	server := newServer(r)
+	errs := make(chan error, len(listeners)+1)
+	if len(config.autocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.autocertDomains...),
+			Cache:      autocert.DirCache(config.autocertCacheDir),
+			Email:      config.autocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		// Let's Encrypt checks domains on port 80, which otherwise
+		// redirects to HTTPS.
+		challenges, err := net.Listen("tcp", config.autocertHTTPAddr)
+		if err != nil {
+			return err
+		}
+		log.Printf("Answering ACME challenges on %s", challenges.Addr())
+		go func() {
+			errs <- newServer(manager.HTTPHandler(nil)).Serve(challenges)
+		}()
+	}
+
+	for _, listener := range listeners {
+		log.Printf("Listening on %s", listener.Addr())
+		go func() {
+			switch {
+			case server.TLSConfig != nil:
+				// The certificates come from the autocert manager.
+				errs <- server.ServeTLS(listener, "", "")
+			case config.tlsCertFile != "":
+				errs <- server.ServeTLS(listener, config.tlsCertFile, config.tlsKeyFile)
+			default:
+				errs <- server.Serve(listener)
+			}
+		}()
+	}
+	return <-errs
+}
+
+// listen returns the sockets that systemd passed with socket activation,
+// or listens on each of the configured addresses.
+func listen() ([]net.Listener, error) {
+	var listeners []net.Listener
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
+		count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		for n := 0; n < count; n++ {
+			// Passed sockets start at file descriptor 3.
+			listener, err := net.FileListener(os.NewFile(uintptr(3+n), "systemd"))
+			if err != nil {
+				return nil, err
+			}
+			listeners = append(listeners, listener)
+		}
+		if len(listeners) > 0 {
+			return listeners, nil
+		}
+	}
+
+	for _, addr := range config.addrs {
+		listener, err := listenOn(addr)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenOn listens on a TCP address, such as ":3000" or "127.0.0.1:3000",
+// or on a Unix socket given by its path or as "unix:path".
+func listenOn(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok && !strings.HasPrefix(addr, "/") {
+		return net.Listen("tcp", addr)
+	}
+	if !ok {
+		path = addr
+	}
+	// A socket left by a previous run would make the bind fail.
+	if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSetWidths caps the widths of a set, which are all rendered for ZIPs.
+const maxSetWidths = 10
+
+// setImage is an entry of a set manifest.
+type setImage struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+// setHandler serves /set/:size, the image at several widths for responsive
+// breakpoints. Heights and any fontSize are scaled with the width. It
+// returns a manifest of the URLs and a srcset, or with ?archive=zip every
+// image rendered through the routes of renders. The URLs keep any ?expires=
+// of the request.
+func setHandler(renders *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		img, err := parseImage(c, c.Param("size"))
+		if err != nil {
+			problemFor(c, err, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		widths, err := parseWidths(c.DefaultQuery("widths", "320,640,1280"))
+		if err != nil {
+			problemFor(c, err, http.StatusBadRequest, "invalid_widths")
+			return
+		}
+
+		query := c.Request.URL.Query()
+		for _, name := range []string{"key", "signature", "widths", "archive"} {
+			query.Del(name)
+		}
+		maxSize := ternary(img.maxSize > 0, img.maxSize, config.maxSize)
+		var images []setImage
+		var srcset []string
+		for _, width := range widths {
+			height := int(math.Round(float64(width) * float64(img.height) / float64(img.width)))
+			if config.strict {
+				if err := checkBounds(width, height, maxSize); err != nil {
+					problemFor(c, err, http.StatusBadRequest, "invalid_request")
+					return
+				}
+			}
+			width, height = clamp(width, config.minSize, maxSize), clamp(height, config.minSize, maxSize)
+			if err := checkPixels(width, height); err != nil {
+				problemFor(c, err, http.StatusBadRequest, "invalid_request")
+				return
+			}
+			link := variantURL(tenantFor(c).id(), width, height, query, float64(width)/float64(img.width))
+			images = append(images, setImage{width, height, link})
+			srcset = append(srcset, fmt.Sprintf("%s %dw", link, width))
+		}
+
+		if c.Query("archive") != "zip" {
+			c.JSON(http.StatusOK, gin.H{"images": images, "srcset": strings.Join(srcset, ", ")})
+			return
+		}
+
+		archive := new(bytes.Buffer)
+		files := zip.NewWriter(archive)
+		for _, image := range images {
+			request, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, image.URL, nil)
+			if err != nil {
+				problem(c, http.StatusInternalServerError, "render_failed", err.Error())
+				return
+			}
+			// Renders get the defaults of the tenant of the request.
+			request.Host = c.Request.Host
+			for _, name := range []string{"Authorization", "X-API-Key"} {
+				request.Header.Set(name, c.GetHeader(name))
+			}
+			response := httptest.NewRecorder()
+			renders.ServeHTTP(response, request)
+			if response.Code != http.StatusOK {
+				// Pass the problem of the failed render on.
+				c.Data(response.Code, response.Header().Get("Content-Type"), response.Body.Bytes())
+				return
+			}
+			mediaType, _, _ := mime.ParseMediaType(response.Header().Get("Content-Type"))
+			file, err := files.Create(fmt.Sprintf("%dx%d%s", image.Width, image.Height, outputExtensions[mediaType]))
+			if err == nil {
+				_, err = file.Write(response.Body.Bytes())
+			}
+			if err != nil {
+				problem(c, http.StatusInternalServerError, "render_failed", err.Error())
+				return
+			}
+		}
+		manifest, _ := json.MarshalIndent(images, "", "  ")
+		file, err := files.Create("manifest.json")
+		if err == nil {
+			_, err = file.Write(manifest)
+		}
+		if err == nil {
+			err = files.Close()
+		}
+		if err != nil {
+			problem(c, http.StatusInternalServerError, "render_failed", err.Error())
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="set.zip"`)
+		c.Data(http.StatusOK, "application/zip", archive.Bytes())
+	}
+}
+
+// parseWidths reads comma separated widths like 320,640,1280.
+func parseWidths(list string) ([]int, error) {
+	var widths []int
+	for _, field := range strings.Split(list, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || width <= 0 {
+			return nil, badRequest("invalid_widths", "Widths should be comma separated numbers like 320,640,1280.")
+		}
+		widths = append(widths, width)
+	}
+	if len(widths) > maxSetWidths {
+		return nil, badRequest("invalid_widths", "A set can have at most %d widths.", maxSetWidths)
+	}
+	return widths, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// renderCall is a render of one cache key, shared by every request for the
+// key that arrives while it runs.
+type renderCall struct {
+	done    chan struct{}
+	output  []byte
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// renderGroup deduplicates concurrent renders of the same cache key, so a
+// burst of requests for a placeholder that isn't cached yet renders it once.
+// A render is canceled once every request waiting on it has gone away.
+type renderGroup struct {
+	mu    sync.Mutex
+	calls map[string]*renderCall
+}
+
+// join waits on the render of key, and reports whether the caller has to
+// start it with run.
+func (g *renderGroup) join(key string) (*renderCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if call, ok := g.calls[key]; ok {
+		call.waiters++
+		return call, false
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*renderCall)
+	}
+	call := &renderCall{done: make(chan struct{}), waiters: 1, cancel: func() {}}
+	g.calls[key] = call
+	return call, true
+}
+
+// leave stops waiting on call. The last request to leave cancels it, and
+// later requests for key start a new render.
+func (g *renderGroup) leave(key string, call *renderCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+	call.cancel()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// run renders call into stream, with a slot of limiter and within
+// RENDER_TIMEOUT, and caches the output. It runs on its own goroutine, so
+// requests can stop waiting on it without stopping it for the others.
+func (g *renderGroup) run(parent context.Context, key string, call *renderCall, limiter *RenderLimiter, stream *responseStream, render func(ctx context.Context, w io.Writer) error) {
+	g.mu.Lock()
+	base, cancel := context.WithCancel(context.WithoutCancel(parent))
+	call.cancel = cancel
+	if call.waiters == 0 {
+		// Everyone left before the render started.
+		cancel()
+	}
+	g.mu.Unlock()
+
+	call.output, call.err = renderShared(base, key, limiter, stream, render)
+	cancel()
+	g.mu.Lock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	close(call.done)
+}
+
+// renderShared renders into stream and caches the output.
+func renderShared(base context.Context, key string, limiter *RenderLimiter, stream *responseStream, render func(ctx context.Context, w io.Writer) error) (output []byte, err error) {
+	// A panic would crash the server from the goroutine of the render.
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("Render panicked: %v\n%s", recovered, debug.Stack())
+			err = fmt.Errorf("render panicked: %v", recovered)
+		}
+	}()
+
+	// The slot is held for as long as the render runs, not only while a
+	// request waits on it.
+	if limiter != nil {
+		if err := limiter.acquire(base.Done()); err != nil {
+			if base.Err() != nil {
+				return nil, base.Err()
+			}
+			return nil, err
+		}
+		defer limiter.release()
+	}
+
+	ctx, cancel := context.WithTimeout(base, config.renderTimeout)
+	defer cancel()
+	if err := render(ctx, stream); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	output = stream.output.Bytes()
+	if renderCache != nil {
+		if err := renderCache.put(key, output); err != nil {
+			log.Printf("Failed to cache image: %v", err)
+		}
+	}
+	return output, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newSigningRouter returns the routes of a server with URL_SIGNING_KEY set.
+func newSigningRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	key := config.urlSigningKey
+	t.Cleanup(func() { config.urlSigningKey = key })
+	config.urlSigningKey = "test-signing-key"
+
+	r := gin.New()
+	registerRoutes(r, nil, nil)
+	return r
+}
+
+// signURL signs path and query like /admin/sign does.
+func signURL(path string, query url.Values) string {
+	query.Set("signature", signature("", path, query))
+	return path + "?" + query.Encode()
+}
+
+// request serves a request with the headers in header.
+func request(r http.Handler, method, target string, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSignedURLs(t *testing.T) {
+	r := newSigningRouter(t)
+
+	signed := signURL("/300x200", url.Values{"text": {"hi"}})
+	if w := request(r, http.MethodGet, signed, "", nil); w.Code != http.StatusOK {
+		t.Errorf("signed URL got %d, want 200", w.Code)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		code   string
+	}{
+		{"unsigned", "/300x200?text=hi", "invalid_signature"},
+		{"forged", strings.Replace(signed, "text=hi", "text=forged", 1), "invalid_signature"},
+		{"other path", strings.Replace(signed, "/300x200", "/3000x3000", 1), "invalid_signature"},
+		{"expired", signURL("/300x200", url.Values{"expires": {strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)}}), "url_expired"},
+	}
+	for _, test := range tests {
+		w := request(r, http.MethodGet, test.target, "", nil)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), test.code) {
+			t.Errorf("%s URL got %d %s, want 403 %s", test.name, w.Code, w.Body, test.code)
+		}
+	}
+}
+
+func TestCollectionsNeedSignatures(t *testing.T) {
+	r := newSigningRouter(t)
+
+	header := http.Header{"X-Api-Key": {"collector"}, "Content-Type": {"application/json"}}
+	w := request(r, http.MethodPost, "/collections", `{"name": "big", "spec": "300x200?text=unsigned"}`, header)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("saving got %d %s", w.Code, w.Body)
+	}
+	var saved SavedSpec
+	if err := json.Unmarshal(w.Body.Bytes(), &saved); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { collections.remove("collector", saved.ID) })
+
+	if w := request(r, http.MethodGet, "/collections/"+saved.ID, "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("unsigned collection render got %d, want 403", w.Code)
+	}
+	if w := request(r, http.MethodGet, signURL("/collections/"+saved.ID, url.Values{}), "", nil); w.Code != http.StatusOK {
+		t.Errorf("signed collection render got %d %s, want 200", w.Code, w.Body)
+	}
+}
+
+func TestCollectionKeysMustBeAPIKeys(t *testing.T) {
+	r := newSigningRouter(t)
+	defer func(keys map[string]*APIKey) { apiKeys = keys }(apiKeys)
+	apiKeys = map[string]*APIKey{"known": {}}
+
+	if w := request(r, http.MethodGet, "/collections", "", http.Header{"X-Api-Key": {"made-up"}}); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key got %d, want 401", w.Code)
+	}
+	if w := request(r, http.MethodGet, "/collections", "", http.Header{"X-Api-Key": {"known"}}); w.Code != http.StatusOK {
+		t.Errorf("known key got %d, want 200", w.Code)
+	}
+
+	// Bearer tokens pick the same collection as the other ways to send a key.
+	bearer := http.Header{"Authorization": {"Bearer known"}, "Content-Type": {"application/json"}}
+	w := request(r, http.MethodPost, "/collections", `{"name":"bearer","spec":"300x200"}`, bearer)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("saving with a bearer token got %d, want 201", w.Code)
+	}
+	var saved SavedSpec
+	json.Unmarshal(w.Body.Bytes(), &saved)
+	defer collections.remove(hashKey("known"), saved.ID)
+	w = request(r, http.MethodGet, "/collections?key=known", "", nil)
+	if !strings.Contains(w.Body.String(), saved.ID) {
+		t.Errorf("collection of ?key= is %s, want the spec saved with the bearer token", w.Body)
+	}
+}
+
+// childURLs returns the image URLs in the body of a snippet or set.
+func childURLs(body string) []string {
+	var links []string
+	for _, field := range strings.FieldsFunc(body, func(r rune) bool { return strings.ContainsRune(" \",\n<>", r) }) {
+		if n := strings.Index(field, "/"); strings.Contains(field, "signature=") && n >= 0 {
+			link, err := url.Parse(html.UnescapeString(field))
+			if err == nil {
+				links = append(links, link.RequestURI())
+			}
+		}
+	}
+	return links
+}
+
+func TestSnippetURLsExpire(t *testing.T) {
+	r := newSigningRouter(t)
+
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	if w := request(r, http.MethodGet, signURL("/snippet/300x200", url.Values{"expires": {expired}}), "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expired snippet URL got %d, want 403", w.Code)
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	w := request(r, http.MethodGet, signURL("/snippet/300x200", url.Values{"expires": {expires}}), "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("snippet got %d %s", w.Code, w.Body)
+	}
+	links := childURLs(w.Body.String())
+	if len(links) == 0 {
+		t.Fatalf("no image URLs in %s", w.Body)
+	}
+	for _, link := range links {
+		if !strings.Contains(link, "expires="+expires) {
+			t.Errorf("%s doesn't expire with the snippet URL", link)
+		}
+		if w := request(r, http.MethodGet, link, "", nil); w.Code != http.StatusOK {
+			t.Errorf("%s got %d, want 200", link, w.Code)
+		}
+		if w := request(r, http.MethodGet, strings.Replace(link, "expires="+expires, "expires=9999999999", 1), "", nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s with a later expiry got %d, want 403", link, w.Code)
+		}
+	}
+}
+
+func TestSnippetVariantsKeepText(t *testing.T) {
+	r := gin.New()
+	registerRoutes(r, nil, nil)
+	w := request(r, http.MethodGet, "/snippet/300x200", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("snippet got %d %s", w.Code, w.Body)
+	}
+	img := &Image{width: 300, height: 200, format: "png"}
+	text := url.Values{"text": {img.renderDefaultText()}}.Encode()
+	for _, variant := range []string{"/600x400?", "/900x600?"} {
+		if !strings.Contains(html.UnescapeString(w.Body.String()), variant+text) {
+			t.Errorf("%s in %s doesn't have the text of the 1x image", variant, w.Body)
+		}
+	}
+}
+
+func TestSetURLsExpire(t *testing.T) {
+	r := newSigningRouter(t)
+
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	if w := request(r, http.MethodGet, signURL("/set/300x200", url.Values{"expires": {expired}}), "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expired set URL got %d, want 403", w.Code)
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	w := request(r, http.MethodGet, signURL("/set/300x200", url.Values{"expires": {expires}}), "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("set got %d %s", w.Code, w.Body)
+	}
+	var set struct{ Images []setImage }
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range set.Images {
+		if !strings.Contains(image.URL, "expires="+expires) {
+			t.Errorf("%s doesn't expire with the set URL", image.URL)
+		}
+		if w := request(r, http.MethodGet, image.URL, "", nil); w.Code != http.StatusOK {
+			t.Errorf("%s got %d, want 200", image.URL, w.Code)
+		}
+	}
+
+	query := url.Values{"expires": {expires}, "archive": {"zip"}}
+	if w := request(r, http.MethodGet, signURL("/set/300x200", query), "", nil); w.Code != http.StatusOK {
+		t.Errorf("set archive got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestPairURLsSigned(t *testing.T) {
+	r := newSigningRouter(t)
+
+	if w := request(r, http.MethodGet, "/pair/300x200?bg=random", "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("unsigned pair URL got %d, want 403", w.Code)
+	}
+	w := request(r, http.MethodGet, signURL("/pair/300x200", url.Values{"bg": {"random"}}), "", nil)
+	var pair struct{ Light, Dark string }
+	if err := json.Unmarshal(w.Body.Bytes(), &pair); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("pair got %d %s", w.Code, w.Body)
+	}
+	bgs := map[string]bool{}
+	for _, link := range []string{pair.Light, pair.Dark} {
+		if w := request(r, http.MethodGet, link, "", nil); w.Code != http.StatusOK {
+			t.Errorf("%s got %d, want 200", link, w.Code)
+		}
+		parsed, _ := url.Parse(link)
+		bgs[parsed.Query().Get("bg")] = true
+	}
+	if len(bgs) != 1 || bgs["random"] {
+		t.Errorf("variants have backgrounds %v, want the same picked color", bgs)
+	}
+}
+
+func TestSignatureCoversTenant(t *testing.T) {
+	r := newSigningRouter(t)
+	defer func(saved *Tenants, token string) { tenants, config.adminToken = saved, token }(tenants, config.adminToken)
+	docs := &Tenant{name: "docs", Defaults: map[string]string{"bg": "0c79ed"}}
+	tenants = &Tenants{byKey: map[string]*Tenant{}, byHost: map[string]*Tenant{"docs.example.com": docs}, byName: map[string]*Tenant{"docs": docs}}
+	config.adminToken = "test-admin-token"
+
+	host := func(target, host string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	plain := signURL("/300x200", url.Values{})
+	if code := host(plain, "docs.example.com"); code != http.StatusForbidden {
+		t.Errorf("URL signed without a tenant got %d on a tenant host, want 403", code)
+	}
+	if code := host(plain, "example.com"); code != http.StatusOK {
+		t.Errorf("URL signed without a tenant got %d, want 200", code)
+	}
+
+	header := http.Header{"Authorization": {"Bearer test-admin-token"}}
+	w := request(r, http.MethodGet, "/admin/sign?url=/300x200&tenant=docs", "", header)
+	var body struct{ URL string }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if code := host(body.URL, "docs.example.com"); code != http.StatusOK {
+		t.Errorf("URL signed for the tenant got %d on its host, want 200", code)
+	}
+	if code := host(body.URL, "example.com"); code != http.StatusForbidden {
+		t.Errorf("URL signed for the tenant got %d on another host, want 403", code)
+	}
+	if w := request(r, http.MethodGet, "/admin/sign?url=/300x200&tenant=missing", "", header); w.Code != http.StatusBadRequest {
+		t.Errorf("signing for an unknown tenant got %d, want 400", w.Code)
+	}
+}
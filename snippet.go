@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// snippetDensities are the pixel densities snippets list in srcset.
+var snippetDensities = []int{1, 2, 3}
+
+// snippetHandler serves /snippet/:size, markup to paste into a mockup: an
+// <img> with a srcset at 1x, 2x and 3x, inside a <picture> with an AVIF
+// source when the build supports AVIF. The image URLs have the parameters
+// of the request, without the API key, and are signed when URL signing is
+// on, keeping any ?expires= of the request.
+func snippetHandler(c *gin.Context) {
+	size := c.Param("size")
+	img, err := parseImage(c, size)
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	query := c.Request.URL.Query()
+	for _, name := range []string{"key", "signature", "format"} {
+		query.Del(name)
+	}
+	// The default text shows the size, so the larger variants get the text
+	// of the 1x image instead of their own size.
+	if !query.Has("text") && img.text != "" {
+		query.Set("text", strings.NewReplacer("{", "{{", "}", "}}").Replace(img.text))
+	}
+	base, tenant := publicURL(c), tenantFor(c).id()
+
+	// srcset lists the densities the caller may render, as the text and
+	// any fontSize are scaled with the size.
+	srcset := func(format string) string {
+		var entries []string
+		for _, density := range snippetDensities {
+			width, height := img.width*density, img.height*density
+			if density > 1 && checkBounds(width, height, img.maxSize) != nil {
+				break
+			}
+			values := maps.Clone(query)
+			if format != "" {
+				values.Set("format", format)
+			}
+			entries = append(entries, fmt.Sprintf("%s%s %dx", base, variantURL(tenant, width, height, values, float64(density)), density))
+		}
+		return strings.Join(entries, ", ")
+	}
+
+	png := srcset("")
+	src, _, _ := strings.Cut(png, " ")
+	tag := fmt.Sprintf(`<img src="%s" srcset="%s" width="%d" height="%d" alt="%s" loading="lazy">`,
+		html.EscapeString(src), html.EscapeString(png), img.width, img.height, html.EscapeString(img.text))
+	if avifSupported {
+		tag = fmt.Sprintf("<picture>\n  <source type=\"image/avif\" srcset=\"%s\">\n  %s\n</picture>",
+			html.EscapeString(srcset("avif")), tag)
+	}
+	c.String(http.StatusOK, tag+"\n")
+}
+
+// variantURL is the path and query of the image in query at another size,
+// with any fontSize multiplied by scale to a tenth, and signed for tenant
+// when URL signing is on.
+func variantURL(tenant string, width, height int, query url.Values, scale float64) string {
+	values := maps.Clone(query)
+	if fontSize, err := strconv.ParseFloat(values.Get("fontSize"), 64); err == nil {
+		values.Set("fontSize", strconv.FormatFloat(math.Round(fontSize*scale*10)/10, 'f', -1, 64))
+	}
+	path := fmt.Sprintf("/%dx%d", width, height)
+	if config.urlSigningKey != "" {
+		values.Set("signature", signature(tenant, path, values))
+	}
+	if len(values) == 0 {
+		return path
+	}
+	return path + "?" + values.Encode()
+}
+
+// publicURL is the origin the service is reached at, PUBLIC_URL or the
+// scheme and host of the request.
+func publicURL(c *gin.Context) string {
+	if config.publicURL != "" {
+		return strings.TrimSuffix(config.publicURL, "/")
+	}
+	return ternary(c.Request.TLS != nil, "https", "http") + "://" + c.Request.Host
+}
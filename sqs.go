@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SQSQueue receives and sends messages through the JSON API of an SQS queue,
+// or of a compatible one like ElasticMQ or LocalStack.
+type SQSQueue struct {
+	client      *http.Client
+	queueURL    string
+	endpoint    string
+	credentials awsCredentials
+}
+
+// newSQSQueue connects to a queue by its URL, such as
+// https://sqs.us-east-1.amazonaws.com/123456789012/renders. The region is
+// read from AWS hosts, and is CACHE_REGION for others.
+func newSQSQueue(queueURL *url.URL) *SQSQueue {
+	region := config.cacheRegion
+	if parts := strings.Split(queueURL.Host, "."); len(parts) == 4 && parts[0] == "sqs" {
+		region = parts[1]
+	}
+	return &SQSQueue{
+		// Receives wait up to 20 seconds for messages.
+		client:      &http.Client{Timeout: 30 * time.Second},
+		queueURL:    queueURL.String(),
+		endpoint:    queueURL.Scheme + "://" + queueURL.Host + "/",
+		credentials: awsCredentials{region, "sqs", config.workerAccessKey, config.workerSecretKey},
+	}
+}
+
+func (q *SQSQueue) receive(ctx context.Context) ([]delivery, error) {
+	var received struct {
+		Messages []struct {
+			Body          string
+			ReceiptHandle string
+		}
+	}
+	err := q.call(ctx, "ReceiveMessage", map[string]any{
+		"QueueUrl":            q.queueURL,
+		"MaxNumberOfMessages": 10,
+		"WaitTimeSeconds":     20,
+	}, &received)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]delivery, len(received.Messages))
+	for n, message := range received.Messages {
+		handle := message.ReceiptHandle
+		deliveries[n] = delivery{
+			body: []byte(message.Body),
+			ack: func() error {
+				return q.call(context.Background(), "DeleteMessage", map[string]any{
+					"QueueUrl":      q.queueURL,
+					"ReceiptHandle": handle,
+				}, nil)
+			},
+		}
+	}
+	return deliveries, nil
+}
+
+func (q *SQSQueue) publish(body []byte) error {
+	return q.call(context.Background(), "SendMessage", map[string]any{
+		"QueueUrl":    q.queueURL,
+		"MessageBody": string(body),
+	}, nil)
+}
+
+// call makes a request to an action of the SQS API and decodes its
+// response into out.
+func (q *SQSQueue) call(ctx context.Context, action string, input map[string]any, out any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, q.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	request.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	signV4(request, body, time.Now().UTC(), q.credentials)
+
+	response, err := q.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(response.Body).Decode(&failure)
+		return fmt.Errorf("SQS %s responded with %s: %s", action, response.Status, failure.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
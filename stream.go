@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseStream writes an encoder's output straight to the response of the
+// request that started the render. The status and Content-Type are only
+// sent with the first byte, so a render that fails before writing can still
+// respond with a JSON error. The output is also kept for the cache and for
+// requests waiting on the same render. A buffered stream only keeps the
+// output, for the caller to send.
+type responseStream struct {
+	c           *gin.Context
+	contentType string
+	output      bytes.Buffer
+	buffered    bool
+	started     bool
+
+	mu       sync.Mutex
+	detached bool
+}
+
+func (s *responseStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.output.Write(p)
+	if s.buffered || s.detached {
+		return len(p), nil
+	}
+	if !s.started {
+		s.started = true
+		s.c.Header("Content-Type", s.contentType)
+		s.c.Status(http.StatusOK)
+	}
+	// The render goes on for the requests waiting on it, and the cache,
+	// when the client that started it goes away.
+	if _, err := s.c.Writer.Write(p); err != nil {
+		s.detached = true
+	}
+	return len(p), nil
+}
+
+// detach stops writing to the response, which can't be used once its
+// handler returns. Writes in progress finish first.
+func (s *responseStream) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detached = true
+}
+
+// abortResponse closes the connection of a response that failed after its
+// headers were sent. The body is chunked, so closing it early makes clients
+// see an error instead of a truncated image.
+func abortResponse(c *gin.Context) {
+	c.Abort()
+	if conn, _, err := c.Writer.Hijack(); err == nil {
+		conn.Close()
+	}
+}
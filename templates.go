@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	lua "github.com/yuin/gopher-lua"
+	"gopkg.in/yaml.v3"
+)
+
+// Template is an operator defined layout, loaded from a YAML or JSON file.
+// Any string may reference a variable as {{name}}, which is filled in from
+// the query string or the variable's default.
+type Template struct {
+	Width      int               `yaml:"width"`
+	Height     int               `yaml:"height"`
+	Background string            `yaml:"background"`
+	Variables  map[string]string `yaml:"variables"`
+	Regions    []TemplateRegion  `yaml:"regions"`
+	// Script is Lua that computes variables before they are filled in, run
+	// with TEMPLATE_SCRIPTS=true.
+	Script string `yaml:"script"`
+
+	script *lua.FunctionProto
+	// version hashes the file and the fonts of its regions, so renders of
+	// a changed template get new cache keys.
+	version string
+}
+
+type TemplateRegion struct {
+	X        int     `yaml:"x"`
+	Y        int     `yaml:"y"`
+	Width    int     `yaml:"width"`
+	Height   int     `yaml:"height"`
+	Text     string  `yaml:"text"`
+	Font     string  `yaml:"font"`
+	Size     float64 `yaml:"size"`
+	Color    string  `yaml:"color"`
+	Fill     string  `yaml:"fill"`
+	Align    string  `yaml:"align"`
+	MaxLines int     `yaml:"maxLines"`
+
+	face *truetype.Font
+}
+
+var templates = loadTemplates(config.templatesDir)
+
+// loadTemplates reads every .yaml, .yml and .json file in dir, named after
+// the file without its extension. Broken templates are logged and skipped.
+func loadTemplates(dir string) map[string]*Template {
+	loaded := map[string]*Template{}
+	if dir == "" {
+		return loaded
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Failed to read templates: %v", err)
+		return loaded
+	}
+
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ext)
+		template, err := loadTemplate(dir, entry.Name())
+		if err != nil {
+			log.Printf("Failed to load template %s: %v", name, err)
+			continue
+		}
+		loaded[name] = template
+	}
+	return loaded
+}
+
+func loadTemplate(dir, file string) (*Template, error) {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, so one decoder handles both.
+	template := &Template{}
+	version := sha256.New()
+	version.Write(data)
+	if err := yaml.Unmarshal(data, template); err != nil {
+		return nil, err
+	}
+	if err := checkBounds(template.Width, template.Height, config.maxSize); err != nil {
+		return nil, err
+	}
+	if template.Script != "" {
+		var err error
+		if template.script, err = compileScript(file, template.Script); err != nil {
+			return nil, fmt.Errorf("cannot compile script: %v", err)
+		}
+	}
+
+	for n := range template.Regions {
+		region := &template.Regions[n]
+		region.face = regularFont
+		if region.Font != "" {
+			// Font paths are relative to the template.
+			path := region.Font
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			data, err := os.ReadFile(path)
+			if err == nil {
+				region.face, err = freetype.ParseFont(data)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot load font %s: %v", region.Font, err)
+			}
+			version.Write(data)
+		}
+		if region.Size == 0 {
+			region.Size = 32
+		}
+		if region.MaxLines == 0 {
+			region.MaxLines = 1
+		}
+	}
+	template.version = hex.EncodeToString(version.Sum(nil))
+	return template, nil
+}
+
+type TemplateRender struct {
+	name     string
+	template *Template
+	values   map[string]string
+}
+
+func templateHandler(c *gin.Context) {
+	name := c.Param("name")
+	template, ok := lookupTemplate(name)
+	if !ok {
+		problem(c, http.StatusNotFound, "unknown_template", fmt.Sprintf("Unknown template %q.", name))
+		return
+	}
+
+	// Only declared variables are read, so unrelated query parameters don't
+	// create new cache entries.
+	values := map[string]string{}
+	for variable, defaultValue := range template.Variables {
+		value, err := sanitizeTexts(c.DefaultQuery(variable, defaultValue))
+		if err != nil {
+			problemFor(c, err, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		values[variable] = value[0]
+	}
+
+	render := &TemplateRender{name: name, template: template, values: values}
+	serveRender(c, render.cacheKey(), "image/png", render.render)
+}
+
+func (t *TemplateRender) cacheKey() string {
+	names := make([]string, 0, len(t.values))
+	for name := range t.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var spec strings.Builder
+	fmt.Fprintf(&spec, "template|%s|%s", t.name, t.template.version)
+	for _, name := range names {
+		fmt.Fprintf(&spec, "|%s=%q", name, t.values[name])
+	}
+	return renderKey(spec.String())
+}
+
+// expand replaces {{name}} with the value of each variable.
+func (t *TemplateRender) expand(text string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(t.values))
+	for name, value := range t.values {
+		pairs = append(pairs, "{{"+name+"}}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+func (t *TemplateRender) render(ctx context.Context, w io.Writer) error {
+	if regularFontErr != nil {
+		return regularFontErr
+	}
+	// The key is made from the variables before the script, which only
+	// depends on them.
+	if t.template.script != nil {
+		values, err := runScript(ctx, t.template.script, t.values)
+		if err != nil {
+			log.Printf("Script of template %s failed: %v", t.name, err)
+			return err
+		}
+		for name, value := range values {
+			sanitized, err := sanitizeTexts(value)
+			if err != nil {
+				return err
+			}
+			values[name] = sanitized[0]
+		}
+		t.values = values
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, t.template.Width, t.template.Height))
+	bg := parseColor(t.expand(t.template.Background), color.RGBA{0xFF, 0xFF, 0xFF, 0xFF})
+	draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+
+	for _, region := range t.template.Regions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rect := image.Rect(region.X, region.Y, region.X+region.Width, region.Y+region.Height)
+		if region.Fill != "" {
+			fill := parseColor(t.expand(region.Fill), bg)
+			draw.Draw(img, rect, &image.Uniform{fill}, image.Point{}, draw.Over)
+		}
+
+		fg := parseColor(t.expand(region.Color), color.RGBA{0x00, 0x00, 0x00, 0xFF})
+		text := shapeText(t.expand(region.Text))
+		drawRegion(img, textRegion{rect, region.Size, region.MaxLines, region.Align}, text, region.face, fg)
+	}
+
+	return pngEncoder.Encode(&contextWriter{ctx, w}, img)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tenant holds the defaults of the requests for some hosts or API keys,
+// from TENANTS_FILE.
+type Tenant struct {
+	Hosts   []string `json:"hosts"`
+	APIKeys []string `json:"apiKeys"`
+	// MaxSize caps the size below MAX_SIZE and the limit of the API key.
+	MaxSize int `json:"maxSize"`
+	// Fonts are TrueType files by face, like "bold" or "bold-italic",
+	// that replace Go Regular and its variants.
+	Fonts map[string]string `json:"fonts"`
+	// Defaults are query parameters for requests that don't set them.
+	Defaults map[string]string `json:"defaults"`
+
+	name   string
+	family string
+}
+
+// Tenants looks tenants up by API key, by host and by name.
+type Tenants struct {
+	byKey  map[string]*Tenant
+	byHost map[string]*Tenant
+	byName map[string]*Tenant
+}
+
+var tenants = loadTenants(config.tenantsFile)
+
+// loadTenants reads tenants as JSON, an object of names to tenants such as
+// {"docs": {"hosts": ["docs.example.com"], "defaults": {"palette": "pastel"}}}.
+// Fonts that fail to load are logged and skipped.
+func loadTenants(file string) *Tenants {
+	loaded := &Tenants{byKey: map[string]*Tenant{}, byHost: map[string]*Tenant{}, byName: map[string]*Tenant{}}
+	if file == "" {
+		return loaded
+	}
+	data, err := os.ReadFile(file)
+	parsed := map[string]*Tenant{}
+	if err == nil {
+		err = json.Unmarshal(data, &parsed)
+	}
+	if err != nil {
+		log.Printf("Failed to load tenants: %v", err)
+		return loaded
+	}
+
+	for name, tenant := range parsed {
+		tenant.name = name
+		loaded.byName[name] = tenant
+		if err := tenant.loadFonts(); err != nil {
+			log.Printf("Failed to load fonts of tenant %s: %v", name, err)
+		}
+		for _, key := range tenant.APIKeys {
+			loaded.byKey[key] = tenant
+		}
+		for _, host := range tenant.Hosts {
+			loaded.byHost[strings.ToLower(host)] = tenant
+		}
+	}
+	auditServer("tenants.load", map[string]string{"file": file, "count": strconv.Itoa(len(parsed))})
+	return loaded
+}
+
+// loadFonts registers the fonts of the tenant as a family of its own.
+func (t *Tenant) loadFonts() error {
+	if len(t.Fonts) == 0 {
+		return nil
+	}
+	family := "tenant:" + t.name
+	ttfs := map[fontKey][]byte{}
+	for face, path := range t.Fonts {
+		weight, style, _ := strings.Cut(face, "-")
+		if fontWeight(weight) != weight || (style != "" && style != "italic") {
+			return fmt.Errorf("unknown face %q, use a weight like bold with an optional -italic", face)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		ttfs[fontKey{family, weight, ternary(style == "", "normal", style)}] = data
+	}
+	maps.Copy(fontRegistry, registerFonts(ttfs))
+	t.family = family
+	return nil
+}
+
+// tenantFor returns the tenant of the API key of a request, or else of its
+// host, or nil.
+func tenantFor(c *gin.Context) *Tenant {
+	if key := requestAPIKey(c); key != "" {
+		if tenant, ok := tenants.byKey[key]; ok {
+			return tenant
+		}
+	}
+	host, _, err := net.SplitHostPort(c.Request.Host)
+	if err != nil {
+		host = c.Request.Host
+	}
+	return tenants.byHost[strings.ToLower(host)]
+}
+
+// id is the name of the tenant, or empty for no tenant.
+func (t *Tenant) id() string {
+	if t == nil {
+		return ""
+	}
+	return t.name
+}
+
+// withDefaults returns the spec with the defaults of the tenant for the
+// parameters it doesn't set.
+func (t *Tenant) withDefaults(spec RenderSpec) RenderSpec {
+	if t == nil || len(t.Defaults) == 0 {
+		return spec
+	}
+	params := url.Values{}
+	for name, values := range spec.Params {
+		params[name] = values
+	}
+	for name, value := range t.Defaults {
+		if !params.Has(name) {
+			params.Set(name, value)
+		}
+	}
+	return RenderSpec{Size: spec.Size, Params: params}
+}
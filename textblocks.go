@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// maxTextBlocks caps the text blocks next to the main text.
+const maxTextBlocks = 3
+
+// textPositions are where a block of text sits on the canvas.
+var textPositions = map[string]bool{"top": true, "center": true, "bottom": true}
+
+// TextBlock is text drawn next to the main text, like a caption under a
+// heading. It is read from ?text2=Caption&text2Size=18&text2Pos=bottom, or
+// from the entries of ?texts=[{"text": "Caption", "size": 18, "pos": "bottom"}].
+type TextBlock struct {
+	Text     string  `json:"text"`
+	Size     float64 `json:"size,omitempty"`
+	Position string  `json:"pos,omitempty"`
+}
+
+// parseTextBlocks reads text2 to text4 and then the blocks in texts, with
+// their text as given.
+func parseTextBlocks(query func(string) string, texts string) ([]TextBlock, error) {
+	var blocks []TextBlock
+	for n := 2; n <= maxTextBlocks+1; n++ {
+		name := "text" + strconv.Itoa(n)
+		if text := query(name); text != "" {
+			size, _ := strconv.ParseFloat(query(name+"Size"), 64)
+			blocks = append(blocks, TextBlock{text, size, query(name + "Pos")})
+		}
+	}
+	if texts != "" {
+		var listed []TextBlock
+		if err := json.Unmarshal([]byte(texts), &listed); err != nil {
+			return nil, badRequest("invalid_texts", `Texts should be a JSON list like [{"text": "Caption", "size": 18, "pos": "bottom"}].`)
+		}
+		blocks = append(blocks, listed...)
+	}
+	if len(blocks) > maxTextBlocks {
+		return nil, badRequest("invalid_texts", "An image can have at most %d texts besides text.", maxTextBlocks)
+	}
+	return blocks, nil
+}
+
+// setTextBlocks expands the tokens of the blocks like in text. Blocks are
+// half the font size of the main text and sit at the bottom by default.
+func (i *Image) setTextBlocks(blocks []TextBlock) {
+	i.blocks = nil
+	for _, block := range blocks {
+		if block.Text == "" {
+			continue
+		}
+		block.Text = shapeText(i.expandTokens(block.Text))
+		if block.Size <= 0 || math.IsNaN(block.Size) {
+			block.Size = i.fontSize / 2
+		}
+		block.Size = i.clampFontSize(block.Size)
+		if !textPositions[block.Position] {
+			block.Position = "bottom"
+		}
+		i.blocks = append(i.blocks, block)
+	}
+}
+
+// setTextPosition reads ?textPos=top|center|bottom for the main text.
+func (i *Image) setTextPosition(position string) {
+	i.textPosition = ternary(textPositions[position], position, "center")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// TextStyle holds the effects drawn around the text. The zero value draws
+// plain text.
+type TextStyle struct {
+	shadowX      int
+	shadowY      int
+	shadowColor  color.RGBA
+	outlineWidth int
+	outlineColor color.RGBA
+	tracking     fixed.Int26_6
+}
+
+func (i *Image) setStyle(shadow, outline, tracking string) {
+	i.style = parseTextStyle(shadow, outline, tracking)
+}
+
+// parseTextStyle parses ?shadow=2,2,000000aa, ?outline=1,000 and ?tracking=2.
+// Invalid values leave the effect off.
+func parseTextStyle(shadow, outline, tracking string) TextStyle {
+	var style TextStyle
+
+	if parts := strings.Split(shadow, ","); len(parts) >= 2 {
+		x, errX := strconv.Atoi(parts[0])
+		y, errY := strconv.Atoi(parts[1])
+		if errX == nil && errY == nil {
+			style.shadowX, style.shadowY = x, y
+			style.shadowColor = color.RGBA{0x00, 0x00, 0x00, 0x80}
+			if len(parts) > 2 {
+				style.shadowColor = parseColor(parts[2], style.shadowColor)
+			}
+		}
+	}
+
+	if parts := strings.Split(outline, ","); len(parts) >= 1 {
+		if width, err := strconv.Atoi(parts[0]); err == nil && width > 0 {
+			style.outlineWidth = clamp(width, 1, maxOutlineWidth)
+			style.outlineColor = color.RGBA{0x00, 0x00, 0x00, 0xFF}
+			if len(parts) > 1 {
+				style.outlineColor = parseColor(parts[1], style.outlineColor)
+			}
+		}
+	}
+
+	if value, err := strconv.ParseFloat(tracking, 64); err == nil {
+		style.tracking = fixed.Int26_6(float64(value * 64))
+	}
+
+	return style
+}
+
+// maxOutlineWidth caps ?outline=. The outline draws the text once per pixel
+// of a disc of its width, 196 times at 8.
+const maxOutlineWidth = 8
+
+// draw renders a laid out line with its shadow and outline underneath. The
+// outline takes many passes, so ctx is checked between them.
+func (s TextStyle) draw(ctx context.Context, drawer *font.Drawer, line layoutLine, fg color.RGBA) error {
+	if s.shadowX != 0 || s.shadowY != 0 {
+		drawer.Src = &image.Uniform{s.shadowColor}
+		drawer.Dot = line.dot.Add(fixed.P(s.shadowX, s.shadowY))
+		drawString(drawer, line.text, s.tracking)
+	}
+
+	if s.outlineWidth > 0 {
+		drawer.Src = &image.Uniform{s.outlineColor}
+		for dy := -s.outlineWidth; dy <= s.outlineWidth; dy++ {
+			for dx := -s.outlineWidth; dx <= s.outlineWidth; dx++ {
+				if dx*dx+dy*dy > s.outlineWidth*s.outlineWidth || (dx == 0 && dy == 0) {
+					continue
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				drawer.Dot = line.dot.Add(fixed.P(dx, dy))
+				drawString(drawer, line.text, s.tracking)
+			}
+		}
+	}
+
+	drawer.Src = &image.Uniform{fg}
+	drawer.Dot = line.dot
+	drawString(drawer, line.text, s.tracking)
+	return nil
+}
+
+// drawString draws text with extra space between letters.
+func drawString(drawer *font.Drawer, text string, tracking fixed.Int26_6) {
+	if tracking == 0 {
+		drawer.DrawString(text)
+		return
+	}
+
+	previous := rune(-1)
+	for _, r := range text {
+		if previous >= 0 {
+			drawer.Dot.X += drawer.Face.Kern(previous, r) + tracking
+		}
+		drawer.DrawString(string(r))
+		previous = r
+	}
+}
+
+func measureString(drawer *font.Drawer, text string, tracking fixed.Int26_6) fixed.Int26_6 {
+	width := drawer.MeasureString(text)
+	if count := len([]rune(text)); count > 1 {
+		width += tracking * fixed.Int26_6(count-1)
+	}
+	return width
+}
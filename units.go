@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// paperSizes are portrait page sizes in millimeters. Add "-landscape" to a
+// name to swap the sides.
+var paperSizes = map[string][2]float64{
+	"a3":      {297, 420},
+	"a4":      {210, 297},
+	"a5":      {148, 210},
+	"a6":      {105, 148},
+	"letter":  {215.9, 279.4},
+	"legal":   {215.9, 355.6},
+	"tabloid": {279.4, 431.8},
+}
+
+// unitsPerInch converts the physical units sizes can be given in.
+var unitsPerInch = map[string]float64{
+	"mm": 25.4,
+	"cm": 2.54,
+	"in": 1,
+	"pt": 72,
+}
+
+const mmPerInch = 25.4
+
+// setDPI reads ?dpi=. It stays zero when not given, so only images that ask
+// for a resolution carry one in their metadata.
+func (i *Image) setDPI(value string) {
+	i.dpi = 0
+	if dpi, err := strconv.ParseFloat(value, 64); err == nil && dpi >= 1 && dpi <= 1200 {
+		i.dpi = dpi
+	}
+}
+
+// resolution is the DPI used to convert physical sizes, 72 by default.
+func (i *Image) resolution() float64 {
+	return ternary(i.dpi > 0, i.dpi, 72)
+}
+
+// physical reports whether the image has a physical size or resolution,
+// which is then written into PNG metadata.
+func (i *Image) physical() bool {
+	return i.dpi > 0 || i.pageWidth > 0
+}
+
+// paperSize returns the page size in millimeters for names like "a4" or
+// "letter-landscape".
+func paperSize(name string) (float64, float64, bool) {
+	name, landscape := strings.CutSuffix(strings.ToLower(name), "-landscape")
+	size, ok := paperSizes[name]
+	if !ok {
+		return 0, 0, false
+	}
+	if landscape {
+		return size[1], size[0], true
+	}
+	return size[0], size[1], true
+}
+
+// physicalSize parses sizes like "210mmx297mm" or "8.5inx11in" into
+// inches. Both sides need a unit.
+func physicalSize(size string) (float64, float64, bool) {
+	width, height, ok := strings.Cut(size, "x")
+	if !ok {
+		return 0, 0, false
+	}
+	w, ok := parseLength(width)
+	if !ok {
+		return 0, 0, false
+	}
+	h, ok := parseLength(height)
+	if !ok {
+		return 0, 0, false
+	}
+	return w, h, true
+}
+
+// parseLength converts a length such as "210mm" to inches.
+func parseLength(length string) (float64, bool) {
+	for unit, perInch := range unitsPerInch {
+		if value, ok := strings.CutSuffix(length, unit); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v <= 0 || math.IsInf(v, 0) {
+				return 0, false
+			}
+			return v / perInch, true
+		}
+	}
+	return 0, false
+}
+
+// ihdrEnd is where the chunks after the header of a PNG start. The
+// signature is 8 bytes and IHDR 25 bytes with its length, type and CRC.
+const ihdrEnd = 8 + 25
+
+// insertWriter inserts bytes at an offset of the stream written through
+// it, such as chunks right after the IHDR chunk of a PNG.
+type insertWriter struct {
+	w       io.Writer
+	at      int
+	insert  []byte
+	written int
+}
+
+func (d *insertWriter) Write(p []byte) (int, error) {
+	// The bytes go in once the writes reach the offset.
+	if d.written >= d.at || d.written+len(p) < d.at {
+		n, err := d.w.Write(p)
+		d.written += n
+		return n, err
+	}
+
+	head := d.at - d.written
+	n, err := d.w.Write(p[:head])
+	d.written += n
+	if err != nil {
+		return n, err
+	}
+	if _, err := d.w.Write(d.insert); err != nil {
+		return n, err
+	}
+	rest, err := d.w.Write(p[head:])
+	d.written += rest
+	return n + rest, err
+}
+
+func physChunk(dpi float64) []byte {
+	pixelsPerMeter := uint32(math.Round(dpi / 0.0254))
+	chunk := make([]byte, 4+4+9+4)
+	binary.BigEndian.PutUint32(chunk[0:], 9)
+	copy(chunk[4:], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:], pixelsPerMeter)
+	binary.BigEndian.PutUint32(chunk[12:], pixelsPerMeter)
+	chunk[16] = 1 // The unit is the meter.
+	binary.BigEndian.PutUint32(chunk[17:], crc32.ChecksumIEEE(chunk[4:17]))
+	return chunk
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxVideoDuration caps ?duration, in seconds.
+const maxVideoDuration = 30
+
+// videoCodecs are the ffmpeg arguments of each container. The frame is the
+// same throughout, so the encoders are tuned for speed and still images.
+var videoCodecs = map[string][]string{
+	"mp4":  {"-c:v", "libx264", "-preset", "veryfast", "-tune", "stillimage", "-movflags", "+faststart"},
+	"webm": {"-c:v", "libvpx-vp9", "-deadline", "realtime", "-cpu-used", "8", "-b:v", "0", "-crf", "40"},
+}
+
+// videoSupported reports whether FFMPEG_PATH points at an ffmpeg binary.
+func videoSupported() bool {
+	_, err := exec.LookPath(config.ffmpegPath)
+	return err == nil
+}
+
+// videoHandler serves /video/:size, a silent video of the placeholder for
+// video players in mockups. ?format=webm picks WebM over MP4, and ?duration
+// the length in seconds, 5 by default. Videos are encoded by ffmpeg.
+func videoHandler(c *gin.Context) {
+	if !videoSupported() {
+		problem(c, http.StatusBadRequest, "unsupported_format", "Video is not supported by this server.")
+		return
+	}
+	container := ternary(c.Query("format") == "webm", "webm", "mp4")
+	duration := 5
+	if value := c.Query("duration"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxVideoDuration {
+			problem(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Duration should be from 1 to %d seconds.", maxVideoDuration))
+			return
+		}
+		duration = parsed
+	}
+
+	c.Set("format", "png")
+	img, err := parseImage(c, c.Param("size"))
+	if err != nil {
+		problemFor(c, err, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	// ?duration is the length of the video, the frame itself is still.
+	img.format, img.anim = "png", ""
+
+	serveRender(c, renderKey(fmt.Sprintf("video|%s|%d|%s", container, duration, img.cacheKey())), "video/"+container, func(ctx context.Context, w io.Writer) error {
+		defer img.release()
+		if err := img.apply(ctx); err != nil {
+			return err
+		}
+		frame := new(bytes.Buffer)
+		if err := img.encodeRGBA(frame, img.data); err != nil {
+			return err
+		}
+		return encodeVideo(ctx, w, frame, container, duration)
+	})
+}
+
+// encodeVideo loops the PNG in frame for duration seconds. The output goes
+// through a file, as MP4s are only playable while they download when their
+// index is moved to the front afterwards.
+func encodeVideo(ctx context.Context, w io.Writer, frame io.Reader, container string, duration int) error {
+	output, err := os.CreateTemp("", "placeholder-*."+container)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-y",
+		"-loop", "1", "-framerate", "24", "-f", "png_pipe", "-i", "pipe:0", "-t", strconv.Itoa(duration),
+		// 4:2:0 chroma needs even sides.
+		"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2", "-pix_fmt", "yuv420p", "-an"}
+	args = append(append(args, videoCodecs[container]...), output.Name())
+	cmd := exec.CommandContext(ctx, config.ffmpegPath, args...)
+	cmd.Stdin = frame
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	_, err = io.Copy(w, output)
+	return err
+}
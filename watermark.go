@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// watermarkAngle is the angle of the watermark rows, rising to the right.
+const watermarkAngle = -30
+
+// Watermark is text repeated across the whole image, apart from the
+// centered text.
+type Watermark struct {
+	text    string
+	opacity float64
+}
+
+// setWatermark reads ?watermark=DRAFT and ?watermarkOpacity=0.15.
+func (i *Image) setWatermark(text, opacity string) {
+	i.watermark = Watermark{}
+	if text == "" {
+		return
+	}
+	i.watermark.text = text
+	i.watermark.opacity = 0.15
+	if value, err := strconv.ParseFloat(opacity, 64); err == nil && !math.IsNaN(value) {
+		i.watermark.opacity = math.Max(0, math.Min(value, 1))
+	}
+}
+
+// drawWatermark tiles the watermark in staggered rows on a layer that
+// covers the image at any angle, and turns it over the image.
+func (i *Image) drawWatermark(img *image.RGBA) {
+	if i.watermark.text == "" || i.watermark.opacity == 0 || regularFontErr != nil {
+		return
+	}
+	side := int(math.Ceil(math.Hypot(float64(i.width), float64(i.height))))
+	layer := newPooledRGBA(image.Rect(0, 0, side, side))
+	defer releaseRGBA(layer)
+
+	options := i.faceOptions()
+	options.Size = math.Max(float64(min(i.width, i.height))/8, 8)
+	alpha := uint8(i.watermark.opacity * 0xFF)
+	drawer := &font.Drawer{
+		Dst:  layer,
+		Src:  &image.Uniform{color.NRGBA{i.fg.R, i.fg.G, i.fg.B, alpha}},
+		Face: newFace(regularFont, options),
+	}
+
+	size := int(options.Size)
+	step := drawer.MeasureString(i.watermark.text).Ceil() + size*2
+	for row, y := 0, size; y < side+size; row, y = row+1, y+size*3 {
+		// Every other row is shifted by half a step.
+		for x := -step + (row%2)*step/2; x < side; x += step {
+			drawer.Dot = fixed.P(x, y)
+			drawer.DrawString(i.watermark.text)
+		}
+	}
+	drawRotated(img, layer, watermarkAngle)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job is a render job from WORKER_QUEUE, such as
+// {"id": "hero", "spec": "/1200x600?text=Hero", "key": "banners/hero.png"}.
+// The spec is a path like in collections. Without a key, the output is
+// stored as the id, or the hash of the spec, with the extension of its type.
+type Job struct {
+	ID   string `json:"id"`
+	Spec string `json:"spec"`
+	Key  string `json:"key,omitempty"`
+}
+
+// JobEvent is published to WORKER_EVENTS once a job is done or has failed.
+type JobEvent struct {
+	ID          string  `json:"id"`
+	Spec        string  `json:"spec"`
+	Status      string  `json:"status"`
+	Key         string  `json:"key,omitempty"`
+	ContentType string  `json:"contentType,omitempty"`
+	Bytes       int     `json:"bytes,omitempty"`
+	DurationMs  float64 `json:"durationMs"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// delivery is a message from a queue. It is acknowledged once it has been
+// handled, and delivered again otherwise by queues that support it.
+type delivery struct {
+	body []byte
+	ack  func() error
+}
+
+// Queue is a message queue that workers receive jobs from and publish
+// events to.
+type Queue interface {
+	// receive waits for the next messages, until ctx ends.
+	receive(ctx context.Context) ([]delivery, error)
+	publish(body []byte) error
+}
+
+// openQueue connects to an SQS queue by its URL, or to a NATS subject given
+// like nats://host:4222/renders.
+func openQueue(rawURL string) (Queue, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Scheme {
+	case "nats":
+		return newNATSQueue(parsed), nil
+	case "https", "http":
+		return newSQSQueue(parsed), nil
+	default:
+		return nil, fmt.Errorf("unsupported queue %q, use an SQS queue URL or nats://host/subject", redactURL(rawURL))
+	}
+}
+
+// outputExtensions are the file extensions of the types routes render.
+var outputExtensions = map[string]string{
+	"image/png":        ".png",
+	"image/jpeg":       ".jpg",
+	"image/avif":       ".avif",
+	"image/apng":       ".png",
+	"image/gif":        ".gif",
+	"video/mp4":        ".mp4",
+	"video/webm":       ".webm",
+	"image/x-icon":     ".ico",
+	"application/pdf":  ".pdf",
+	"application/json": ".json",
+	"text/plain":       ".txt",
+}
+
+// Worker renders the jobs of a queue into object storage.
+type Worker struct {
+	router  *gin.Engine
+	storage *ObjectCache
+	events  Queue
+}
+
+// runWorker renders jobs from WORKER_QUEUE into the CACHE_BUCKET under
+// WORKER_PREFIX, and publishes an event for each to WORKER_EVENTS, until
+// the process is interrupted. Jobs that are being rendered are finished
+// first.
+func runWorker() error {
+	if config.workerQueue == "" {
+		return errors.New("worker mode needs WORKER_QUEUE")
+	}
+	if config.cacheBackend != "s3" && config.cacheBackend != "gcs" {
+		return errors.New("worker mode stores renders in object storage, set CACHE_BACKEND to s3 or gcs")
+	}
+	jobs, err := openQueue(config.workerQueue)
+	if err != nil {
+		return err
+	}
+	worker := &Worker{router: gin.New()}
+	if config.workerEvents != "" {
+		if worker.events, err = openQueue(config.workerEvents); err != nil {
+			return err
+		}
+	}
+	if worker.storage, err = newObjectStorage(config.cacheBackend); err != nil {
+		return err
+	}
+	worker.storage.prefix = config.workerPrefix
+	// Like prewarming, jobs skip the limits of the public routes.
+	registerRenderRoutes(worker.router, limitRenders(nil))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	queue := make(chan delivery)
+	for n := 0; n < ternary(config.workerConcurrency > 0, config.workerConcurrency, runtime.GOMAXPROCS(0)); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for message := range queue {
+				worker.handle(message)
+			}
+		}()
+	}
+
+	log.Printf("Rendering jobs from %s", redactURL(config.workerQueue))
+	for ctx.Err() == nil {
+		deliveries, err := jobs.receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Failed to receive jobs: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		for _, message := range deliveries {
+			queue <- message
+		}
+	}
+	close(queue)
+	wg.Wait()
+	log.Print("Stopped rendering jobs")
+	return nil
+}
+
+// handle renders a job and acknowledges it. Jobs whose output couldn't be
+// stored are left for the queue to deliver again.
+func (w *Worker) handle(message delivery) {
+	var job Job
+	if err := json.Unmarshal(message.body, &job); err != nil || job.Spec == "" {
+		log.Printf("Dropping malformed job %q", message.body)
+		if err := message.ack(); err != nil {
+			log.Printf("Failed to acknowledge job: %v", err)
+		}
+		return
+	}
+
+	event, err := w.render(job)
+	if err != nil {
+		log.Printf("Failed to store job %q: %v", job.ID, err)
+		return
+	}
+	if w.events != nil {
+		body, _ := json.Marshal(event)
+		if err := w.events.publish(body); err != nil {
+			log.Printf("Failed to publish the event of job %q: %v", job.ID, err)
+		}
+	}
+	if err := message.ack(); err != nil {
+		log.Printf("Failed to acknowledge job %q: %v", job.ID, err)
+	}
+}
+
+// render renders a job and stores its output. Jobs that can't be rendered
+// fail with an event, as rendering them again wouldn't help. Errors are
+// only returned when storing the output failed.
+func (w *Worker) render(job Job) (*JobEvent, error) {
+	start := time.Now()
+	if job.ID == "" {
+		sum := sha256.Sum256([]byte(job.Spec))
+		job.ID = hex.EncodeToString(sum[:8])
+	}
+	event := &JobEvent{ID: job.ID, Spec: job.Spec, Status: "failed"}
+	defer func() {
+		event.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	}()
+
+	spec := "/" + strings.TrimPrefix(job.Spec, "/")
+	request, err := http.NewRequest(http.MethodGet, spec, nil)
+	if err != nil {
+		event.Error = err.Error()
+		return event, nil
+	}
+	response := httptest.NewRecorder()
+	w.router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		var failure struct {
+			Detail string `json:"detail"`
+		}
+		json.Unmarshal(response.Body.Bytes(), &failure)
+		event.Error = ternary(failure.Detail != "", failure.Detail, http.StatusText(response.Code))
+		return event, nil
+	}
+
+	contentType := response.Header().Get("Content-Type")
+	key := job.Key
+	if key == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		key = job.ID + outputExtensions[mediaType]
+	}
+	if err := w.storage.store(key, response.Body.Bytes(), contentType); err != nil {
+		return nil, err
+	}
+	event.Status = "done"
+	event.Key = config.workerPrefix + key
+	event.ContentType = contentType
+	event.Bytes = response.Body.Len()
+	return event, nil
+}
+
+// redactURL hides the credentials in a queue URL for logs, NATS tokens
+// included.
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	if parsed.User != nil {
+		parsed.User = url.User("xxxxx")
+	}
+	return parsed.String()
+}